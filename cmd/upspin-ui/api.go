@@ -0,0 +1,751 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/xsrftoken"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+	"upspin.io/version"
+)
+
+// apiPrefix is the URL path prefix of the JSON API.
+const apiPrefix = "/api/v1/"
+
+// apiKeyHeader is the HTTP header that carries the request key.
+// Requiring a custom header also prevents cross-origin form submissions.
+const apiKeyHeader = "X-Upspin-Key"
+
+// apiRoute describes an API endpoint.
+type apiRoute struct {
+	Method string
+	// Path is the route's path beneath apiPrefix.
+	// If it ends in a slash, the remainder of the URL path is an Upspin
+	// path name that is passed to the handler.
+	Path    string
+	Summary string
+
+	// Request and Response are zero values of the request and response
+	// body types, used to generate the API specification.
+	// A nil Request means the request has no JSON body.
+	Request  interface{}
+	Response interface{}
+
+	// NoConfig is set for routes that may be used before startup
+	// has completed.
+	NoConfig bool
+
+	// AsUser is set for routes that may be performed as a server user
+	// of the current user, named by the asHeader header.
+	AsUser bool
+
+	// Mutates is set for routes that modify the tree. They are refused
+	// when the config is read-only.
+	Mutates bool
+
+	// handler serves the request. The name is the Upspin path name
+	// from the URL for routes whose Path ends in a slash.
+	handler func(s *server, r *http.Request, name upspin.PathName) (interface{}, error)
+}
+
+// apiRoutes lists the API endpoints.
+var apiRoutes = []apiRoute{
+	{
+		Method:   "POST",
+		Path:     "startup",
+		Summary:  "Perform a step of the signup process, or complete startup.",
+		Request:  startupRequest{},
+		Response: startupResult{},
+		NoConfig: true,
+		handler:  (*server).apiStartup,
+	},
+	{
+		Method:   "GET",
+		Path:     "startup/verify",
+		Summary:  "Wait up to 30 seconds for the user awaiting verification to click the link in the verification email.",
+		Response: verifyStatus{},
+		NoConfig: true,
+		handler:  (*server).apiWaitVerified,
+	},
+	{
+		Method:   "GET",
+		Path:     "dir/",
+		Summary:  "List the contents of a directory, with any error that left the list incomplete.",
+		Response: listResponse{},
+		AsUser:   true,
+		handler:  (*server).apiList,
+	},
+	{
+		Method:   "PUT",
+		Path:     "dir/",
+		Summary:  "Create a directory.",
+		Response: entryResponse{},
+		AsUser:   true,
+		Mutates:  true,
+		handler:  (*server).apiMkdir,
+	},
+	{
+		Method:   "POST",
+		Path:     "upload/",
+		Summary:  "Upload the files in a multipart/form-data request body to a directory.",
+		Response: emptyResponse{},
+		AsUser:   true,
+		Mutates:  true,
+		handler:  (*server).apiUpload,
+	},
+	{
+		Method:   "POST",
+		Path:     "delete",
+		Summary:  "Recursively delete files and directories.",
+		Request:  pathsRequest{},
+		Response: emptyResponse{},
+		AsUser:   true,
+		Mutates:  true,
+		handler:  (*server).apiDelete,
+	},
+	{
+		Method:   "POST",
+		Path:     "copy",
+		Summary:  "Recursively copy files and directories to a destination directory.",
+		Request:  pathsRequest{},
+		Response: emptyResponse{},
+		AsUser:   true,
+		Mutates:  true,
+		handler:  (*server).apiCopy,
+	},
+	{
+		Method:   "GET",
+		Path:     "text/",
+		Summary:  "Read a text file and its sequence number.",
+		Response: textResponse{},
+		AsUser:   true,
+		handler:  (*server).apiGetText,
+	},
+	{
+		Method:   "PUT",
+		Path:     "text/",
+		Summary:  "Write a text file if its sequence number matches.",
+		Request:  textRequest{},
+		Response: textResponse{},
+		AsUser:   true,
+		Mutates:  true,
+		handler:  (*server).apiPutText,
+	},
+	{
+		Method:   "GET",
+		Path:     "groups",
+		Summary:  "List the current user's Group files.",
+		Response: groupsResponse{},
+		AsUser:   true,
+		handler:  (*server).apiGroups,
+	},
+	{
+		Method:   "GET",
+		Path:     "groups/",
+		Summary:  "Show the members of a Group file and the users it expands to.",
+		Response: groupResponse{},
+		AsUser:   true,
+		handler:  (*server).apiGroup,
+	},
+	{
+		Method:   "POST",
+		Path:     "groups/",
+		Summary:  "Add members to and remove members from a Group file.",
+		Request:  groupRequest{},
+		Response: groupResponse{},
+		AsUser:   true,
+		Mutates:  true,
+		handler:  (*server).apiUpdateGroup,
+	},
+	{
+		Method:   "POST",
+		Path:     "keys/rotate",
+		Summary:  "Replace the current user's keys and optionally re-wrap their files for the new key.",
+		Request:  rotateRequest{},
+		Response: rotateStatus{},
+		Mutates:  true,
+		handler:  (*server).apiRotate,
+	},
+	{
+		Method:   "GET",
+		Path:     "keys/rotate",
+		Summary:  "Report the progress of the most recent key rotation.",
+		Response: rotateStatus{},
+		handler:  (*server).apiRotateStatus,
+	},
+	{
+		Method:   "GET",
+		Path:     "profiles",
+		Summary:  "List the config files that the server may switch between.",
+		Response: profilesResponse{},
+		handler:  (*server).apiProfiles,
+	},
+	{
+		Method:   "POST",
+		Path:     "profiles",
+		Summary:  "Switch to the named profile. File tokens issued under other profiles become invalid.",
+		Request:  profileRequest{},
+		Response: profilesResponse{},
+		handler:  (*server).apiSwitchProfile,
+	},
+	{
+		Method:   "GET",
+		Path:     "config",
+		Summary:  "Read the settings in the active profile's config file.",
+		Response: configFile{},
+		handler:  (*server).apiGetConfig,
+	},
+	{
+		Method:   "PUT",
+		Path:     "config",
+		Summary:  "Validate and save the settings in the active profile's config file, keeping a backup.",
+		Request:  configRequest{},
+		Response: configFile{},
+		handler:  (*server).apiPutConfig,
+	},
+	{
+		Method:   "GET",
+		Path:     "cache",
+		Summary:  "Report the status and size of the active profile's cacheserver.",
+		Response: cacheStatus{},
+		handler:  (*server).apiCacheStatus,
+	},
+	{
+		Method:   "PUT",
+		Path:     "cache",
+		Summary:  "Turn the cache on or off in the active profile's config file.",
+		Request:  cacheRequest{},
+		Response: cacheStatus{},
+		handler:  (*server).apiSetCache,
+	},
+	{
+		Method:   "POST",
+		Path:     "cache/restart",
+		Summary:  "Restart the cacheserver started by upspin-ui.",
+		Response: cacheStatus{},
+		handler:  (*server).apiRestartCache,
+	},
+	{
+		Method:   "POST",
+		Path:     "cache/flush",
+		Summary:  "Remove the contents of the cache, restarting the cacheserver started by upspin-ui.",
+		Response: cacheStatus{},
+		handler:  (*server).apiFlushCache,
+	},
+	{
+		Method:   "POST",
+		Path:     "endpoints",
+		Summary:  "Change the current user's directory and store servers, which must hold the user's root unless the user is read-only.",
+		Request:  endpointsRequest{},
+		Response: configFile{},
+		handler:  (*server).apiSetEndpoints,
+	},
+	{
+		Method:   "GET",
+		Path:     "spec",
+		Summary:  "Return an OpenAPI specification of this API.",
+		Response: map[string]interface{}{},
+		NoConfig: true,
+		handler:  (*server).apiSpec,
+	},
+}
+
+// Request and response types.
+
+// startupResult is the response to a startup request.
+// If Startup is non-nil, the startup process is not complete and the client
+// should present the given step to the user. Otherwise the remaining fields
+// describe the configured user.
+type startupResult struct {
+	Startup   *startupResponse `json:",omitempty"`
+	UserName  upspin.UserName  `json:",omitempty"`
+	LeftPath  upspin.PathName  `json:",omitempty"`
+	RightPath upspin.PathName  `json:",omitempty"`
+	Version   string
+
+	// ServerUsers lists the server users as whom a browser pane
+	// may act, by sending their name in the X-Upspin-As header.
+	ServerUsers []upspin.UserName `json:",omitempty"`
+
+	// ReadOnly is set if the config names no directory or store server,
+	// in which case requests that modify the tree are refused.
+	ReadOnly bool `json:",omitempty"`
+}
+
+type listResponse struct {
+	Entries []entryWithToken
+	Error   string `json:",omitempty"` // Why Entries may be incomplete.
+}
+
+type entryWithToken struct {
+	*upspin.DirEntry
+	FileToken string
+}
+
+type entryResponse struct {
+	Entry *upspin.DirEntry
+}
+
+type pathsRequest struct {
+	Paths []upspin.PathName
+	Dest  upspin.PathName `json:",omitempty"` // For copy only.
+}
+
+type textRequest struct {
+	Contents string
+	// Sequence is the sequence number of the file that was read,
+	// or -1 if the file should not already exist.
+	Sequence int64
+}
+
+type textResponse struct {
+	Contents string `json:",omitempty"` // Omitted when saving.
+	Sequence int64
+}
+
+type groupsResponse struct {
+	Groups []upspin.PathName
+}
+
+type groupRequest struct {
+	Add    []string `json:",omitempty"`
+	Remove []string `json:",omitempty"`
+}
+
+type groupResponse struct {
+	Members []string
+	Users   []upspin.UserName
+
+	// Unreadable lists the nested groups whose users could not be
+	// included in Users.
+	Unreadable []unreadableGroup `json:",omitempty"`
+}
+
+type rotateRequest struct {
+	// Rewrap is set if the user's files should be re-wrapped for the
+	// new key.
+	Rewrap bool
+}
+
+type profilesResponse struct {
+	Profiles []profile
+}
+
+type profileRequest struct {
+	Name string
+}
+
+type configRequest struct {
+	Fields configFields
+	// Checksum is that of the file that was read.
+	Checksum string
+}
+
+type cacheRequest struct {
+	Enabled bool
+}
+
+type emptyResponse struct{}
+
+// apiError is the response body of a failed request. The Kind, Path, User
+// and Op fields are taken from an upspin.io/errors.Error, where available.
+type apiError struct {
+	Error struct {
+		Kind    string
+		Path    upspin.PathName `json:",omitempty"`
+		User    upspin.UserName `json:",omitempty"`
+		Op      string          `json:",omitempty"`
+		Message string
+	}
+}
+
+// errorKinds maps upspin.io/errors Kinds to the names reported in apiError
+// and the corresponding HTTP status codes.
+var errorKinds = []struct {
+	kind errors.Kind
+	name string
+	code int
+}{
+	{errors.Invalid, "Invalid", http.StatusBadRequest},
+	{errors.Syntax, "Syntax", http.StatusBadRequest},
+	{errors.Permission, "Permission", http.StatusForbidden},
+	{errors.Private, "Private", http.StatusForbidden},
+	{errors.CannotDecrypt, "CannotDecrypt", http.StatusForbidden},
+	{errors.NotExist, "NotExist", http.StatusNotFound},
+	{errors.BrokenLink, "BrokenLink", http.StatusNotFound},
+	{errors.Exist, "Exist", http.StatusConflict},
+	{errors.IsDir, "IsDir", http.StatusConflict},
+	{errors.NotDir, "NotDir", http.StatusConflict},
+	{errors.NotEmpty, "NotEmpty", http.StatusConflict},
+	{errors.Transient, "Transient", http.StatusServiceUnavailable},
+	{errors.IO, "IO", http.StatusInternalServerError},
+	{errors.Internal, "Internal", http.StatusInternalServerError},
+}
+
+// serveAPI dispatches requests beneath apiPrefix to the matching apiRoute
+// and writes its JSON-encoded response or error.
+func (s *server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	var route *apiRoute
+	pathMatched := false
+	for i := range apiRoutes {
+		rt := &apiRoutes[i]
+		if p != rt.Path && !(strings.HasSuffix(rt.Path, "/") && strings.HasPrefix(p, rt.Path)) {
+			continue
+		}
+		pathMatched = true
+		if r.Method == rt.Method {
+			route = rt
+			break
+		}
+	}
+	if route == nil {
+		if pathMatched {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Invalid", errors.Errorf("method %s not allowed", r.Method))
+		} else {
+			writeAPIError(w, http.StatusNotFound, "NotExist", errors.Errorf("no API route %q", p))
+		}
+		return
+	}
+
+	// Require a valid key, except to read the specification.
+	if route.Path != "spec" && r.Header.Get(apiKeyHeader) != s.key {
+		writeAPIError(w, http.StatusForbidden, "Permission", errors.Str("invalid key"))
+		return
+	}
+
+	// Don't permit accesses of non-startup methods if there is no config
+	// nor client; those methods need them.
+	if !route.NoConfig && !s.hasConfig() {
+		writeAPIError(w, http.StatusServiceUnavailable, "NoConfig", errors.Str("no configuration"))
+		return
+	}
+
+	if as := r.Header.Get(asHeader); as != "" {
+		if !route.AsUser {
+			writeAPIError(w, http.StatusBadRequest, "Invalid", errors.Errorf("%s %s cannot be performed as another user", route.Method, route.Path))
+			return
+		}
+		view, err := s.as(upspin.UserName(as))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s = view
+	}
+
+	if route.Mutates && s.readOnly() {
+		writeAPIError(w, http.StatusForbidden, "ReadOnly", readOnlyError)
+		return
+	}
+
+	var name upspin.PathName
+	if strings.HasSuffix(route.Path, "/") {
+		name = upspin.PathName(strings.TrimPrefix(p, route.Path))
+	}
+	resp, err := route.handler(s, r, name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// writeError writes the given error as a JSON-encoded apiError with an HTTP
+// status code that corresponds to the error's Kind.
+func writeError(w http.ResponseWriter, err error) {
+	kind, code := "Other", http.StatusInternalServerError
+	for _, k := range errorKinds {
+		if errors.Match(errors.E(k.kind), err) {
+			kind, code = k.name, k.code
+			break
+		}
+	}
+	writeAPIError(w, code, kind, err)
+}
+
+// writeAPIError writes an apiError with the given status code and kind,
+// and the message and any details from err.
+func writeAPIError(w http.ResponseWriter, code int, kind string, err error) {
+	var resp apiError
+	resp.Error.Kind = kind
+	resp.Error.Message = err.Error()
+	if e, ok := err.(*errors.Error); ok {
+		resp.Error.Path = e.Path
+		resp.Error.User = e.User
+		resp.Error.Op = string(e.Op)
+	}
+	b, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// decodeRequest decodes the JSON request body into v.
+func decodeRequest(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errors.E(errors.Invalid, errors.Errorf("invalid request body: %v", err))
+	}
+	return nil
+}
+
+// Handlers.
+
+func (s *server) apiStartup(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	var req startupRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	sResp, cfg, err := s.startup(&req)
+	if err != nil {
+		return nil, err
+	}
+	resp := startupResult{
+		Startup: sResp,
+		Version: "upspin-ui",
+	}
+	if sha := version.GitSHA; sha != "" {
+		resp.Version = fmt.Sprintf("%s commit %.7s built %s", resp.Version, sha, version.BuildTime.Format("2 Jan 06"))
+	}
+	if cfg != nil {
+		resp.UserName = cfg.UserName()
+		resp.RightPath = defaultPath
+		// If the user has a directory endpoint then open to
+		// their tree. Otherwise, open both panels to augie.
+		if t := cfg.DirEndpoint().Transport; t == upspin.Remote || t == upspin.InProcess {
+			resp.LeftPath = upspin.PathName(resp.UserName + "/")
+		} else {
+			resp.LeftPath = resp.RightPath
+		}
+		users, err := s.serverUsers()
+		if err != nil {
+			return nil, err
+		}
+		resp.ServerUsers = users
+		resp.ReadOnly = isReadOnly(cfg)
+	}
+	return resp, nil
+}
+
+func (s *server) apiList(r *http.Request, name upspin.PathName) (interface{}, error) {
+	_, cli := s.client()
+	des, err := cli.Glob(upspin.AllFilesGlob(name))
+	if err != nil && len(des) == 0 {
+		return nil, err
+	}
+	resp := listResponse{Entries: []entryWithToken{}}
+	if err != nil {
+		// Report the entries that could be listed, such as those
+		// before a link that Glob would not follow.
+		resp.Error = err.Error()
+	}
+	xsrfUser := s.xsrfUser()
+	for _, de := range des {
+		tok := xsrftoken.Generate(s.key, xsrfUser, string(de.Name))
+		resp.Entries = append(resp.Entries, entryWithToken{
+			DirEntry:  de,
+			FileToken: tok,
+		})
+	}
+	return resp, nil
+}
+
+func (s *server) apiMkdir(r *http.Request, name upspin.PathName) (interface{}, error) {
+	_, cli := s.client()
+	de, err := cli.MakeDirectory(name)
+	if err != nil {
+		return nil, err
+	}
+	return entryResponse{Entry: de}, nil
+}
+
+func (s *server) apiUpload(r *http.Request, dir upspin.PathName) (interface{}, error) {
+	const maxMultipartSize = 500e6
+	if err := r.ParseMultipartForm(maxMultipartSize); err != nil {
+		return nil, errors.E(errors.Invalid, errors.Errorf("parse error: %v", err))
+	}
+	if len(r.MultipartForm.File) == 0 {
+		return nil, errors.E(errors.Invalid, "missing file")
+	}
+	for _, fhs := range r.MultipartForm.File {
+		if len(fhs) == 0 {
+			return nil, errors.E(errors.Invalid, "missing file handle")
+		}
+		if err := s.put(dir, fhs[0]); err != nil {
+			return nil, err
+		}
+	}
+	return emptyResponse{}, nil
+}
+
+func (s *server) apiDelete(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	var req pathsRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	_, cli := s.client()
+	for _, p := range req.Paths {
+		if err := rm(cli, p); err != nil {
+			return nil, err
+		}
+	}
+	return emptyResponse{}, nil
+}
+
+func (s *server) apiCopy(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	var req pathsRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	if err := s.copy(req.Dest, req.Paths); err != nil {
+		return nil, err
+	}
+	return emptyResponse{}, nil
+}
+
+func (s *server) apiGetText(r *http.Request, name upspin.PathName) (interface{}, error) {
+	contents, seq, err := s.getText(name)
+	if err != nil {
+		return nil, err
+	}
+	return textResponse{Contents: contents, Sequence: seq}, nil
+}
+
+func (s *server) apiPutText(r *http.Request, name upspin.PathName) (interface{}, error) {
+	var req textRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	seq, err := s.putText(name, req.Sequence, req.Contents)
+	if err != nil {
+		return nil, err
+	}
+	return textResponse{Sequence: seq}, nil
+}
+
+func (s *server) apiGroups(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	groups, err := s.groups()
+	if err != nil {
+		return nil, err
+	}
+	return groupsResponse{Groups: groups}, nil
+}
+
+func (s *server) apiGroup(r *http.Request, name upspin.PathName) (interface{}, error) {
+	members, users, unreadable, err := s.groupMembers(name)
+	if err != nil {
+		return nil, err
+	}
+	return groupResponse{Members: members, Users: users, Unreadable: unreadable}, nil
+}
+
+func (s *server) apiUpdateGroup(r *http.Request, name upspin.PathName) (interface{}, error) {
+	var req groupRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Add) > 0 {
+		if err := s.groupAdd(name, req.Add); err != nil {
+			return nil, err
+		}
+	}
+	if len(req.Remove) > 0 {
+		if err := s.groupRemove(name, req.Remove); err != nil {
+			return nil, err
+		}
+	}
+	return s.apiGroup(r, name)
+}
+
+func (s *server) apiRotate(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	var req rotateRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return s.rotateKeys(req.Rewrap)
+}
+
+func (s *server) apiRotateStatus(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	return s.rotationStatus()
+}
+
+func (s *server) apiProfiles(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	ps, err := s.profiles()
+	if err != nil {
+		return nil, err
+	}
+	return profilesResponse{Profiles: ps}, nil
+}
+
+func (s *server) apiSwitchProfile(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	var req profileRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	if err := s.switchProfile(req.Name); err != nil {
+		return nil, err
+	}
+	return s.apiProfiles(r, "")
+}
+
+func (s *server) apiGetConfig(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	return s.getConfig()
+}
+
+func (s *server) apiPutConfig(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	var req configRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return s.putConfig(req.Fields, req.Checksum)
+}
+
+func (s *server) apiCacheStatus(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	return s.cacheStatus()
+}
+
+func (s *server) apiSetCache(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	var req cacheRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return s.setCache(req.Enabled)
+}
+
+func (s *server) apiRestartCache(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	return s.restartCache(false)
+}
+
+func (s *server) apiFlushCache(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	return s.restartCache(true)
+}
+
+func (s *server) apiSetEndpoints(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	var req endpointsRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return s.setEndpoints(req)
+}
+
+func (s *server) apiWaitVerified(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	return s.waitVerified(r.Context(), verifyWaitTimeout)
+}
+
+func (s *server) apiSpec(r *http.Request, _ upspin.PathName) (interface{}, error) {
+	return apiSpecDoc, nil
+}
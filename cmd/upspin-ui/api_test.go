@@ -0,0 +1,258 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"augie.upspin.io/uiclient"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// TestAPI starts a server against in-process Upspin servers and drives its
+// HTTP API end to end, exercising the list, mkdir, upload, copy, delete,
+// text editing, and Group file operations.
+func TestAPI(t *testing.T) {
+	const user = "api@example.com"
+	s, err := newInProcessServer(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := uiclient.New(ts.URL, s.key)
+
+	const root = upspin.PathName(user + "/")
+	content := []byte("hello, upspin\n")
+
+	runSteps(t, []testStep{
+		{"startup returns config", func() error {
+			r, err := c.Startup(nil)
+			if err != nil {
+				return err
+			}
+			if r.Startup != nil {
+				return errors.Errorf("got startup step %q, want none", r.Startup.Step)
+			}
+			if r.UserName != user {
+				return errors.Errorf("got user %q, want %q", r.UserName, user)
+			}
+			return nil
+		}},
+		{"list empty root", func() error {
+			return expectList(c, root)
+		}},
+		{"mkdir", func() error {
+			_, err := c.MakeDirectory(root + "dir")
+			if err != nil {
+				return err
+			}
+			return expectList(c, root, "dir/")
+		}},
+		{"mkdir existing", func() error {
+			_, err := c.MakeDirectory(root + "dir")
+			return expectError(err, "Exist")
+		}},
+		{"upload", func() error {
+			if err := c.Put(root+"dir", "file.txt", bytes.NewReader(content)); err != nil {
+				return err
+			}
+			return expectList(c, root+"dir", "dir/file.txt")
+		}},
+		{"get uploaded file", func() error {
+			return expectContent(c, root+"dir/file.txt", content)
+		}},
+		{"copy directory", func() error {
+			if _, err := c.MakeDirectory(root + "dst"); err != nil {
+				return err
+			}
+			if err := c.Copy(root+"dst", root+"dir"); err != nil {
+				return err
+			}
+			if err := expectList(c, root+"dst", "dst/dir/"); err != nil {
+				return err
+			}
+			return expectContent(c, root+"dst/dir/file.txt", content)
+		}},
+		{"copy into own subdirectory", func() error {
+			err := c.Copy(root+"dir", root+"dir")
+			if err == nil {
+				return errors.Str("copy succeeded, want error")
+			}
+			return nil
+		}},
+		{"copy to file", func() error {
+			err := c.Copy(root+"dir/file.txt", root+"dst")
+			return expectError(err, "NotDir")
+		}},
+		{"delete directory", func() error {
+			if err := c.Delete(root + "dir"); err != nil {
+				return err
+			}
+			if err := expectList(c, root, "dst/"); err != nil {
+				return err
+			}
+			// The copy must survive removal of the original.
+			return expectContent(c, root+"dst/dir/file.txt", content)
+		}},
+		{"delete missing", func() error {
+			err := c.Delete(root + "dir")
+			return expectError(err, "NotExist")
+		}},
+		{"edit text", func() error {
+			if err := c.Put(root+"dst", "text.txt", strings.NewReader("original\n")); err != nil {
+				return err
+			}
+			text, seq, err := c.Text(root + "dst/text.txt")
+			if err != nil {
+				return err
+			}
+			if text != "original\n" {
+				return errors.Errorf("got text %q, want %q", text, "original\n")
+			}
+			newSeq, err := c.PutText(root+"dst/text.txt", seq, "edited\n")
+			if err != nil {
+				return err
+			}
+			if newSeq == seq {
+				return errors.Errorf("sequence number %d did not change", seq)
+			}
+			// A save based on the old version must not clobber the new one.
+			if _, err := c.PutText(root+"dst/text.txt", seq, "stale\n"); err == nil {
+				return errors.Str("saved over a newer version")
+			}
+			if _, err := c.PutText(root+"dst/text.txt", upspin.SeqNotExist, "new\n"); err == nil {
+				return errors.Str("created a file that already exists")
+			}
+			return expectContent(c, root+"dst/text.txt", []byte("edited\n"))
+		}},
+		{"create text", func() error {
+			if _, err := c.PutText(root+"dst/new.txt", upspin.SeqNotExist, "new\n"); err != nil {
+				return err
+			}
+			return expectContent(c, root+"dst/new.txt", []byte("new\n"))
+		}},
+		{"edit large file", func() error {
+			big := strings.Repeat("x", maxTextSize+1)
+			if err := c.Put(root+"dst", "big.txt", strings.NewReader(big)); err != nil {
+				return err
+			}
+			_, _, err := c.Text(root + "dst/big.txt")
+			if err := expectError(err, "Invalid"); err != nil {
+				return err
+			}
+			_, err = c.PutText(root+"dst/new.txt", upspin.SeqIgnore, big)
+			if err := expectError(err, "Invalid"); err != nil {
+				return err
+			}
+			// A file of exactly the limit may be edited.
+			_, err = c.PutText(root+"dst/big.txt", upspin.SeqIgnore, big[1:])
+			return err
+		}},
+		{"edit binary file", func() error {
+			if err := c.Put(root+"dst", "binary", bytes.NewReader([]byte{'a', 0xff, 0xfe})); err != nil {
+				return err
+			}
+			_, _, err := c.Text(root + "dst/binary")
+			return expectError(err, "Invalid")
+		}},
+		{"group add", func() error {
+			if _, err := c.MakeDirectory(root + "Group"); err != nil {
+				return err
+			}
+			g, err := c.UpdateGroup(root+"Group/friends", []string{"ann@example.com", "bob@example.com"}, nil)
+			if err != nil {
+				return err
+			}
+			return expectGroup(g, "ann@example.com bob@example.com", "ann@example.com bob@example.com")
+		}},
+		{"group remove", func() error {
+			g, err := c.UpdateGroup(root+"Group/friends", nil, []string{"bob@example.com"})
+			if err != nil {
+				return err
+			}
+			return expectGroup(g, "ann@example.com", "ann@example.com")
+		}},
+		{"group invalid member", func() error {
+			if _, err := c.UpdateGroup(root+"Group/friends", []string{"@example.com"}, nil); err == nil {
+				return errors.Str("added an invalid member")
+			}
+			g, err := c.Group(root + "Group/friends")
+			if err != nil {
+				return err
+			}
+			return expectGroup(g, "ann@example.com", "ann@example.com")
+		}},
+		{"group not a Group file", func() error {
+			_, err := c.UpdateGroup(root+"dst/friends", []string{"ann@example.com"}, nil)
+			return expectError(err, "Invalid")
+		}},
+		{"nested groups", func() error {
+			// The family group refers back to friends, and friends
+			// refers to a group that does not exist.
+			if _, err := c.UpdateGroup(root+"Group/family", []string{"carl@example.com", "friends"}, nil); err != nil {
+				return err
+			}
+			g, err := c.UpdateGroup(root+"Group/friends", []string{"family", "missing"}, nil)
+			if err != nil {
+				return err
+			}
+			if err := expectGroup(g, "ann@example.com family missing", "ann@example.com carl@example.com", root+"Group/missing"); err != nil {
+				return err
+			}
+			g, err = c.Group(root + "Group/family")
+			if err != nil {
+				return err
+			}
+			if err := expectGroup(g, "carl@example.com friends", "ann@example.com carl@example.com", root+"Group/missing"); err != nil {
+				return err
+			}
+			groups, err := c.Groups()
+			if err != nil {
+				return err
+			}
+			if got, want := fmt.Sprint(groups), fmt.Sprint([]upspin.PathName{root + "Group/family", root + "Group/friends"}); got != want {
+				return errors.Errorf("got groups %s, want %s", got, want)
+			}
+			return nil
+		}},
+		{"request without key", func() error {
+			_, err := uiclient.New(ts.URL, "bogus").List(root)
+			return expectError(err, "Permission")
+		}},
+	})
+}
+
+// expectGroup checks that g has the given members and users, each listed
+// in order and separated by spaces, and the given unreadable groups.
+func expectGroup(g *uiclient.Group, members, users string, unreadable ...upspin.PathName) error {
+	if got := strings.Join(g.Members, " "); got != members {
+		return errors.Errorf("got members %q, want %q", got, members)
+	}
+	var u []string
+	for _, user := range g.Users {
+		u = append(u, string(user))
+	}
+	if got := strings.Join(u, " "); got != users {
+		return errors.Errorf("got users %q, want %q", got, users)
+	}
+	var bad []upspin.PathName
+	for _, ug := range g.Unreadable {
+		if ug.Error == "" {
+			return errors.Errorf("unreadable group %s has no error", ug.Group)
+		}
+		bad = append(bad, ug.Group)
+	}
+	if fmt.Sprint(bad) != fmt.Sprint(unreadable) {
+		return errors.Errorf("got unreadable groups %v, want %v", bad, unreadable)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// apiSpecDoc is the OpenAPI 3 specification of the API,
+// generated from apiRoutes and served by the "spec" route.
+var apiSpecDoc map[string]interface{}
+
+func init() {
+	// Generate the specification here rather than in apiSpecDoc's
+	// initializer, as apiRoutes refers to the handler that serves it.
+	apiSpecDoc = apiSpec(apiRoutes)
+}
+
+// object is shorthand for a JSON object in the specification.
+type object = map[string]interface{}
+
+// apiSpec returns an OpenAPI 3 specification of the given routes.
+// The request and response schemas are derived from the routes' Request
+// and Response types, following the rules of encoding/json.
+func apiSpec(routes []apiRoute) object {
+	schemas := object{}
+	paths := object{}
+	for _, rt := range routes {
+		p := apiPrefix + rt.Path
+		var params []object
+		if strings.HasSuffix(rt.Path, "/") {
+			p += "{name}"
+			params = append(params, object{
+				"name":        "name",
+				"in":          "path",
+				"required":    true,
+				"description": "Upspin path name",
+				"schema":      object{"type": "string"},
+			})
+		}
+		op := object{
+			"summary": rt.Summary,
+			"responses": object{
+				"200": object{
+					"description": "Success",
+					"content":     jsonContent(schemaRef(reflect.TypeOf(rt.Response), schemas)),
+				},
+				"default": object{
+					"description": "Error",
+					"content":     jsonContent(schemaRef(reflect.TypeOf(apiError{}), schemas)),
+				},
+			},
+		}
+		if rt.Path != "spec" {
+			params = append(params, object{
+				"name":     apiKeyHeader,
+				"in":       "header",
+				"required": true,
+				"schema":   object{"type": "string"},
+			})
+		}
+		if rt.AsUser {
+			params = append(params, object{
+				"name":        asHeader,
+				"in":          "header",
+				"description": "Server user as whom to perform the request",
+				"schema":      object{"type": "string"},
+			})
+		}
+		if rt.Mutates {
+			op["responses"].(object)["403"] = object{
+				"description": "Kind ReadOnly if the config names no directory or store server",
+				"content":     jsonContent(schemaRef(reflect.TypeOf(apiError{}), schemas)),
+			}
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		switch {
+		case rt.Request != nil:
+			op["requestBody"] = object{
+				"required": true,
+				"content":  jsonContent(schemaRef(reflect.TypeOf(rt.Request), schemas)),
+			}
+		case rt.Method == http.MethodPost && rt.Path == "upload/":
+			op["requestBody"] = object{
+				"required": true,
+				"content": object{
+					"multipart/form-data": object{
+						"schema": object{"type": "object"},
+					},
+				},
+			}
+		}
+		item, _ := paths[p].(object)
+		if item == nil {
+			item = object{}
+			paths[p] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+	return object{
+		"openapi": "3.0.0",
+		"info": object{
+			"title":   "upspin-ui API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": schemas,
+		},
+	}
+}
+
+// jsonContent returns an OpenAPI content object for a JSON body with the
+// given schema.
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+// schemaRef returns the schema for the given type. Named struct types are
+// added to schemas and referred to by name.
+func schemaRef(t reflect.Type, schemas object) object {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes []byte as base64.
+			return object{"type": "string", "format": "byte"}
+		}
+		return object{"type": "array", "items": schemaRef(t.Elem(), schemas)}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": schemaRef(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		name := exportedName(t.Name())
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = object{} // Placeholder, in case of recursion.
+			schemas[name] = structSchema(t, schemas)
+		}
+		return object{"$ref": "#/components/schemas/" + name}
+	}
+	return object{}
+}
+
+// structSchema returns the schema of the given struct type.
+func structSchema(t reflect.Type, schemas object) object {
+	props := object{}
+	addStructFields(t, props, schemas)
+	return object{"type": "object", "properties": props}
+}
+
+// addStructFields adds the schemas of the JSON-encoded fields of struct type
+// t to props, flattening embedded structs as encoding/json does.
+func addStructFields(t reflect.Type, props, schemas object) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addStructFields(ft, props, schemas)
+			continue
+		}
+		if f.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaRef(f.Type, schemas)
+	}
+}
+
+// exportedName returns name with its first letter in upper case.
+func exportedName(name string) string {
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[n:]
+}
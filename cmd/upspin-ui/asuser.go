@@ -0,0 +1,126 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+
+	"upspin.io/client"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+	"upspin.io/user"
+)
+
+// asHeader is the HTTP header that names the user as whom an API request is
+// performed, for routes that permit it. Content requests, which are made by
+// links in the browser, use the "as" query parameter instead.
+const asHeader = "X-Upspin-As"
+
+// serverUsers returns the server users of the current user whose configs
+// were written by the deployment process (see serverConfig). A browser pane
+// may act as any of them.
+func (s *server) serverUsers() ([]upspin.UserName, error) {
+	s.mu.Lock()
+	cur, profile := s.cfg.UserName(), s.profile
+	s.mu.Unlock()
+	if profile == "" {
+		// Only configs loaded from files have server users.
+		return nil, nil
+	}
+
+	ps, err := s.profiles()
+	if err != nil {
+		return nil, err
+	}
+	var users []upspin.UserName
+	for _, p := range ps {
+		if p.Error != "" || !isServerUserOf(p.UserName, cur) {
+			continue
+		}
+		// serverConfig names the config file by the user's suffix.
+		if _, suffix, _, _ := user.Parse(p.UserName); suffix != p.Name {
+			continue
+		}
+		users = append(users, p.UserName)
+	}
+	return users, nil
+}
+
+// isServerUserOf reports whether name is a suffixed user of owner,
+// such as ann+server@example.com for ann@example.com.
+func isServerUserOf(name, owner upspin.UserName) bool {
+	u, suffix, domain, err := user.Parse(name)
+	if err != nil || suffix == "" {
+		return false
+	}
+	ou, osuffix, odomain, err := user.Parse(owner)
+	if err != nil || osuffix != "" {
+		return false
+	}
+	return u == ou && domain == odomain
+}
+
+// as returns a server that performs requests as the named user, who must be
+// the current user or one of its server users. The returned server shares
+// the request key and active profile of s, but has the server user's
+// config and client, and so issues and accepts file tokens for that user
+// only.
+func (s *server) as(name upspin.UserName) (*server, error) {
+	s.mu.Lock()
+	cur, profile := s.cfg.UserName(), s.profile
+	view := s.asServers[name]
+	s.mu.Unlock()
+	if name == cur {
+		return s, nil
+	}
+	if !isServerUserOf(name, cur) {
+		return nil, errors.E(name, errors.Permission, errors.Errorf("cannot act as %s; not a server user of %s", name, cur))
+	}
+	if view != nil {
+		return view, nil
+	}
+	cfg, _, err := serverConfig(name)
+	if err != nil {
+		return nil, errors.E(name, errors.NotExist, errors.Errorf("loading server user config: %v", err))
+	}
+	if cfg.UserName() != name {
+		return nil, errors.E(name, errors.Invalid, errors.Errorf("server user config is for %s", cfg.UserName()))
+	}
+	view = &server{
+		key:     s.key,
+		cfg:     cfg,
+		cli:     client.New(cfg),
+		profile: profile,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg.UserName() != cur || s.profile != profile {
+		// The profile was switched while the view was being made,
+		// and the server user belongs to the previous one.
+		return nil, errors.E(name, errors.Permission, errors.Errorf("cannot act as %s; the active profile has changed", name))
+	}
+	if s.asServers == nil {
+		s.asServers = make(map[upspin.UserName]*server)
+	}
+	s.asServers[name] = view
+	return view, nil
+}
+
+// asRequest returns the server that should serve the given content request,
+// as named by its "as" query parameter. If it returns false it has already
+// written an HTTP error.
+func (s *server) asRequest(w http.ResponseWriter, r *http.Request) (*server, bool) {
+	name := r.FormValue("as")
+	if name == "" || !s.hasConfig() {
+		return s, true
+	}
+	view, err := s.as(upspin.UserName(name))
+	if err != nil {
+		httpError(w, err)
+		return nil, false
+	}
+	return view, true
+}
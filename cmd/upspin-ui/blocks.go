@@ -0,0 +1,137 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"upspin.io/client/clientutil"
+	"upspin.io/errors"
+	"upspin.io/pack"
+	"upspin.io/upspin"
+
+	// Packers for the files we read block by block.
+	_ "upspin.io/pack/ee"
+	_ "upspin.io/pack/eeintegrity"
+	_ "upspin.io/pack/plain"
+)
+
+// blockReader is an io.ReadSeeker for the contents of an Upspin file that
+// fetches and unpacks only the blocks that are actually read.
+// It holds the most recently read block in memory, so sequential reads
+// fetch each block once.
+type blockReader struct {
+	cfg   upspin.Config
+	entry *upspin.DirEntry
+	size  int64
+	bu    upspin.BlockUnpacker
+
+	offset int64  // Offset of the next Read.
+	block  int    // Index of the block held in data, or -1 if none.
+	data   []byte // Cleartext of the current block.
+}
+
+// newBlockReader returns a blockReader for the given file entry.
+// The caller must call Close when done with it.
+func newBlockReader(cfg upspin.Config, de *upspin.DirEntry) (*blockReader, error) {
+	if de.IsDir() {
+		return nil, errors.E(de.Name, errors.IsDir)
+	}
+	size, err := de.Size()
+	if err != nil {
+		return nil, err
+	}
+	packer := pack.Lookup(de.Packing)
+	if packer == nil {
+		return nil, errors.E(de.Name, errors.Invalid, errors.Errorf("unrecognized Packing %d", de.Packing))
+	}
+	bu, err := packer.Unpack(cfg, de)
+	if err != nil {
+		return nil, err
+	}
+	return &blockReader{
+		cfg:   cfg,
+		entry: de,
+		size:  size,
+		bu:    bu,
+		block: -1,
+	}, nil
+}
+
+// Read implements io.Reader.
+func (r *blockReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	blocks := r.entry.Blocks
+	i := sort.Search(len(blocks), func(i int) bool {
+		return blocks[i].Offset+blocks[i].Size > r.offset
+	})
+	if i == len(blocks) {
+		return 0, io.EOF
+	}
+	if i != r.block {
+		if err := r.fetch(i); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.data[r.offset-blocks[i].Offset:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// fetch reads and unpacks the i'th block of the file.
+func (r *blockReader) fetch(i int) error {
+	r.block, r.data = -1, nil
+	b, ok := r.bu.SeekBlock(i)
+	if !ok {
+		return errors.E(r.entry.Name, errors.Internal, errors.Errorf("cannot seek to block %d", i))
+	}
+	ciphertext, err := clientutil.ReadLocation(r.cfg, b.Location)
+	if err != nil {
+		return err
+	}
+	cleartext, err := r.bu.Unpack(ciphertext)
+	if err != nil {
+		return err
+	}
+	if int64(len(cleartext)) != b.Size {
+		return errors.E(r.entry.Name, errors.Invalid, errors.Errorf("block %d has size %d, expected %d", i, len(cleartext), b.Size))
+	}
+	r.block, r.data = i, cleartext
+	return nil
+}
+
+// Seek implements io.Seeker.
+func (r *blockReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.Errorf("negative offset %d", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close releases the resources held by the reader.
+func (r *blockReader) Close() error {
+	r.data = nil
+	return r.bu.Close()
+}
+
+// entryETag returns an HTTP entity tag for the given entry,
+// which changes whenever the entry's contents change.
+func entryETag(de *upspin.DirEntry) string {
+	return fmt.Sprintf(`"%d"`, de.Sequence)
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/xsrftoken"
+
+	"upspin.io/flags"
+	"upspin.io/upspin"
+)
+
+// putBlocks writes a file of a little over two and a half blocks of
+// pseudo-random data as the user of s, and returns its entry and contents.
+func putBlocks(t *testing.T, s *server, name upspin.PathName) (*upspin.DirEntry, []byte) {
+	t.Helper()
+	data := make([]byte, 2*flags.BlockSize+flags.BlockSize/2+7)
+	rand.New(rand.NewSource(1)).Read(data)
+	_, cli := s.client()
+	de, err := cli.Put(name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(de.Blocks) < 3 {
+		t.Fatalf("%s has %d blocks, want at least 3", name, len(de.Blocks))
+	}
+	return de, data
+}
+
+func TestBlockReader(t *testing.T) {
+	const user = "blocks@example.com"
+	s, err := newInProcessServer(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	de, data := putBlocks(t, s, user+"/blocks")
+	cfg, _ := s.client()
+	r, err := newBlockReader(cfg, de)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes that differ from the %d written", len(got), len(data))
+	}
+
+	size := int64(len(data))
+	bs := int64(flags.BlockSize)
+	tests := []struct {
+		offset int64
+		whence int
+		n      int
+		want   int64 // Offset in data of the bytes read.
+	}{
+		{0, io.SeekStart, 10, 0},
+		{bs - 5, io.SeekStart, 10, bs - 5},          // Across the first block boundary.
+		{bs - 1, io.SeekStart, int(bs) + 2, bs - 1}, // Across a whole block.
+		{-10, io.SeekEnd, 10, size - 10},
+		{-bs, io.SeekCurrent, 3, size - bs},
+		{2*bs - 1, io.SeekStart, 2, 2*bs - 1},
+	}
+	for _, test := range tests {
+		off, err := r.Seek(test.offset, test.whence)
+		if err != nil {
+			t.Errorf("Seek(%d, %d): %v", test.offset, test.whence, err)
+			continue
+		}
+		if off != test.want {
+			t.Errorf("Seek(%d, %d) = %d, want %d", test.offset, test.whence, off, test.want)
+			continue
+		}
+		got := make([]byte, test.n)
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Errorf("reading %d bytes at %d: %v", test.n, off, err)
+			continue
+		}
+		if want := data[test.want : test.want+int64(test.n)]; !bytes.Equal(got, want) {
+			t.Errorf("%d bytes at %d differ from those written", test.n, off)
+		}
+	}
+
+	// Reads past the end of the file return EOF.
+	for _, off := range []int64{size, size + bs} {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Errorf("Seek(%d): %v", off, err)
+		}
+		if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+			t.Errorf("Read at %d = %d, %v; want 0, EOF", off, n, err)
+		}
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Seek(-1): got no error")
+	}
+}
+
+func TestServeContentRanges(t *testing.T) {
+	const user = "ranges@example.com"
+	s, err := newInProcessServer(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	name := upspin.PathName(user + "/ranges.bin")
+	de, data := putBlocks(t, s, name)
+
+	get := func(header ...string) (*http.Response, []byte) {
+		t.Helper()
+		u := ts.URL + "/" + string(name) + "?token=" + url.QueryEscape(xsrftoken.Generate(s.key, s.xsrfUser(), string(name)))
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, b
+	}
+
+	resp, b := get()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(b, data) {
+		t.Fatalf("GET: status %q and %d bytes, want 200 and %d bytes", resp.Status, len(b), len(data))
+	}
+	etag := resp.Header.Get("ETag")
+	if etag != entryETag(de) {
+		t.Errorf("ETag is %q, want %q", etag, entryETag(de))
+	}
+
+	size := len(data)
+	bs := flags.BlockSize
+	for _, r := range []struct{ first, last int }{
+		{bs - 10, bs + 9},     // Across a block boundary.
+		{bs / 2, 2*bs + bs/4}, // Across a whole block.
+		{2*bs - 1, size - 1},  // To the end of the file.
+		{size - 1, size - 1},  // The last byte.
+	} {
+		rng := fmt.Sprintf("bytes=%d-%d", r.first, r.last)
+		resp, b := get("Range", rng)
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Errorf("%s: status %q, want 206", rng, resp.Status)
+			continue
+		}
+		want := fmt.Sprintf("bytes %d-%d/%d", r.first, r.last, size)
+		if got := resp.Header.Get("Content-Range"); got != want {
+			t.Errorf("%s: Content-Range %q, want %q", rng, got, want)
+		}
+		if !bytes.Equal(b, data[r.first:r.last+1]) {
+			t.Errorf("%s: got %d bytes that differ from those written", rng, len(b))
+		}
+	}
+	if resp, _ := get("Range", fmt.Sprintf("bytes=%d-", size+bs)); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("range past the end: status %q, want 416", resp.Status)
+	}
+
+	if resp, b := get("If-None-Match", etag); resp.StatusCode != http.StatusNotModified || len(b) != 0 {
+		t.Errorf("If-None-Match with current ETag: status %q and %d bytes, want 304 and none", resp.Status, len(b))
+	}
+	// Once the file changes, the old ETag no longer matches.
+	_, cli := s.client()
+	if _, err := cli.Put(name, []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	resp, b = get("If-None-Match", etag)
+	if resp.StatusCode != http.StatusOK || string(b) != "changed" {
+		t.Errorf("If-None-Match with old ETag: status %q and body %q, want 200 and %q", resp.Status, b, "changed")
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Errorf("ETag %q did not change with the file", etag)
+	}
+}
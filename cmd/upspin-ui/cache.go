@@ -0,0 +1,277 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"upspin.io/errors"
+	"upspin.io/flags"
+	"upspin.io/upspin"
+)
+
+// cacheStatus describes the cacheserver of the active profile.
+type cacheStatus struct {
+	// Enabled reports whether the config names a cache endpoint,
+	// and Endpoint is that endpoint.
+	Enabled  bool
+	Endpoint string `json:",omitempty"`
+
+	// Running reports whether a cacheserver is serving the endpoint.
+	// Managed reports whether it was started by upspin-ui, and so may
+	// be restarted and flushed.
+	Running bool
+	Managed bool
+
+	// Dir is the directory that holds the user's cache, and Size and
+	// Files are the number of bytes and files in it. SizeLimit is the
+	// size to which cacheservers started by upspin-ui are limited.
+	// (The cacheserver does not report its hit rate.)
+	Dir       string
+	Size      int64
+	Files     int
+	SizeLimit int64
+
+	// Error is set if the cacheserver could not be started.
+	Error string `json:",omitempty"`
+}
+
+// cacheManager tracks the cacheserver process started by upspin-ui.
+type cacheManager struct {
+	mu   sync.Mutex // Held while starting and stopping the process.
+	proc *cacheProcess
+	err  error // The error from the last attempt to start the process.
+
+	command string // The cacheserver binary; if empty, "cacheserver".
+}
+
+// cacheProcess is a running cacheserver.
+type cacheProcess struct {
+	cmd  *exec.Cmd
+	user upspin.UserName
+	addr upspin.NetAddr
+	done chan struct{} // Closed when the process exits.
+}
+
+func (p *cacheProcess) running() bool {
+	if p == nil {
+		return false
+	}
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// stop stops the process, killing it if it does not exit promptly.
+func (p *cacheProcess) stop() {
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		p.cmd.Process.Kill()
+	}
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+	logf("cache: stopped cacheserver for %s", p.user)
+}
+
+// cacheDir returns the directory in which the cacheserver keeps the cache
+// of the named user.
+func cacheDir(user upspin.UserName) string {
+	return filepath.Join(flags.CacheDir, string(user))
+}
+
+// cacheListening reports whether a server is listening at the cache
+// endpoint ep.
+func cacheListening(ep upspin.Endpoint) bool {
+	if ep.Transport != upspin.Remote {
+		return false
+	}
+	c, err := net.DialTimeout("tcp", string(ep.NetAddr), time.Second)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// syncCache starts or stops the cacheserver to suit cfg, which was loaded
+// from the given file. If cfg names a cache endpoint at which no server is
+// listening, it starts a cacheserver for the user in cfg, replacing any
+// that upspin-ui started for another user. If cfg names no cache endpoint,
+// it stops any cacheserver started by upspin-ui.
+func (s *server) syncCache(cfg upspin.Config, file string) error {
+	m := &s.cache
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ep := cfg.CacheEndpoint()
+	p := m.proc
+	if p.running() {
+		if ep.Transport != upspin.Unassigned && p.user == cfg.UserName() && p.addr == ep.NetAddr {
+			return nil
+		}
+		p.stop()
+	}
+	m.proc, m.err = nil, nil
+	if ep.Transport == upspin.Unassigned || cacheListening(ep) {
+		return nil
+	}
+	m.proc, m.err = m.start(cfg, file)
+	return m.err
+}
+
+// start starts a cacheserver for the user in cfg, which was loaded from the
+// given file, and waits for it to listen at the cache endpoint.
+// m.mu must be held.
+func (m *cacheManager) start(cfg upspin.Config, file string) (*cacheProcess, error) {
+	command := m.command
+	if command == "" {
+		command = "cacheserver"
+	}
+	ep := cfg.CacheEndpoint()
+	cmd := exec.Command(command,
+		"-cachedir="+flags.CacheDir,
+		"-cachesize="+strconv.FormatInt(flags.CacheSizeLimit, 10),
+		"-config="+file,
+	)
+	if err := cmd.Start(); err != nil {
+		return nil, errors.E(errors.IO, errors.Errorf("starting cacheserver: %v", err))
+	}
+	p := &cacheProcess{
+		cmd:  cmd,
+		user: cfg.UserName(),
+		addr: ep.NetAddr,
+		done: make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		close(p.done)
+	}()
+	for i := 0; i < 50; i++ {
+		if cacheListening(ep) {
+			logf("cache: started cacheserver for %s at %s", p.user, p.addr)
+			return p, nil
+		}
+		if !p.running() {
+			return nil, errors.E(errors.IO, errors.Errorf("cacheserver exited: %v", cmd.ProcessState))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	p.stop()
+	return nil, errors.E(errors.Transient, errors.Errorf("cacheserver did not listen at %s", ep.NetAddr))
+}
+
+// cacheStatus returns the status of the active profile's cacheserver.
+func (s *server) cacheStatus() (cacheStatus, error) {
+	s.mu.Lock()
+	cfg := s.cfg
+	s.mu.Unlock()
+
+	m := &s.cache
+	m.mu.Lock()
+	p, startErr := m.proc, m.err
+	m.mu.Unlock()
+
+	st := cacheStatus{
+		Dir:       cacheDir(cfg.UserName()),
+		SizeLimit: flags.CacheSizeLimit,
+	}
+	if ep := cfg.CacheEndpoint(); ep.Transport != upspin.Unassigned {
+		st.Enabled = true
+		st.Endpoint = ep.String()
+		st.Running = cacheListening(ep)
+	}
+	st.Managed = p.running() && p.user == cfg.UserName()
+	if startErr != nil {
+		st.Error = startErr.Error()
+	}
+	err := filepath.Walk(st.Dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			st.Size += fi.Size()
+			st.Files++
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return st, err
+	}
+	return st, nil
+}
+
+// setCache turns the cache on or off in the active profile's config file,
+// starting or stopping the cacheserver to suit.
+func (s *server) setCache(enabled bool) (cacheStatus, error) {
+	cf, err := s.getConfig()
+	if err != nil {
+		return cacheStatus{}, err
+	}
+	fields := cf.Fields
+	switch {
+	case !enabled:
+		fields.Cache = ""
+	case fields.Cache == "" || fields.Cache == "no":
+		fields.Cache = "yes"
+	}
+	if fields != cf.Fields {
+		// putConfig starts or stops the cacheserver.
+		if _, err := s.putConfig(fields, cf.Checksum); err != nil {
+			return cacheStatus{}, err
+		}
+	}
+	return s.cacheStatus()
+}
+
+// restartCache restarts the cacheserver of the active profile. If flush
+// is set, it removes the contents of the cache while the cacheserver is
+// stopped. A cacheserver that was not started by upspin-ui is left alone.
+func (s *server) restartCache(flush bool) (cacheStatus, error) {
+	s.mu.Lock()
+	cfg, profile := s.cfg, s.profile
+	s.mu.Unlock()
+
+	ep := cfg.CacheEndpoint()
+	if ep.Transport == upspin.Unassigned && !flush {
+		return cacheStatus{}, errors.E(errors.Invalid, errors.Str("the cache is not enabled"))
+	}
+
+	m := &s.cache
+	m.mu.Lock()
+	if p := m.proc; p.running() {
+		p.stop()
+	}
+	m.proc, m.err = nil, nil
+	var err error
+	if ep.Transport != upspin.Unassigned && cacheListening(ep) {
+		err = errors.E(errors.Exist, errors.Errorf("a cacheserver not started by upspin-ui is running at %s; stop it first", ep.NetAddr))
+	}
+	if err == nil && flush {
+		err = os.RemoveAll(cacheDir(cfg.UserName()))
+		if err == nil {
+			logf("cache: flushed cache of %s", cfg.UserName())
+		}
+	}
+	if err == nil && ep.Transport != upspin.Unassigned && profile != "" {
+		m.proc, m.err = m.start(cfg, profileFile(profile))
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return cacheStatus{}, err
+	}
+	return s.cacheStatus()
+}
@@ -0,0 +1,352 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"upspin.io/client"
+	"upspin.io/config"
+	"upspin.io/errors"
+)
+
+// configFields are the settings of a config file that may be edited in the
+// user interface. An empty field is absent from the file, and so takes its
+// default value.
+type configFields struct {
+	UserName    string `yaml:"username"`
+	KeyServer   string `yaml:"keyserver"`
+	DirServer   string `yaml:"dirserver"`
+	StoreServer string `yaml:"storeserver"`
+	Packing     string `yaml:"packing"`
+	Cache       string `yaml:"cache"`
+	TLSCerts    string `yaml:"tlscerts"`
+	Secrets     string `yaml:"secrets"`
+}
+
+// values returns the fields by their config key, in the order in which
+// they are added to a config file.
+func (f *configFields) values() [][2]string {
+	return [][2]string{
+		{"username", f.UserName},
+		{"keyserver", f.KeyServer},
+		{"dirserver", f.DirServer},
+		{"storeserver", f.StoreServer},
+		{"packing", f.Packing},
+		{"cache", f.Cache},
+		{"tlscerts", f.TLSCerts},
+		{"secrets", f.Secrets},
+	}
+}
+
+// configFile describes the config file of the active profile.
+type configFile struct {
+	File   string
+	Fields configFields
+
+	// Other lists the keys in the file that are not among Fields.
+	// They are kept as they are when the file is edited.
+	Other []string `json:",omitempty"`
+
+	// Checksum identifies the contents of the file. It must be passed
+	// back when saving, so that concurrent changes are not clobbered.
+	Checksum string
+}
+
+// configBackupSuffix is appended to the name of a config file to name the
+// copy of its previous contents kept by putConfig.
+const configBackupSuffix = ".bak"
+
+// activeConfigFile returns the name of the config file of the active profile.
+func (s *server) activeConfigFile() (string, error) {
+	s.mu.Lock()
+	profile := s.profile
+	s.mu.Unlock()
+	if profile == "" {
+		return "", errors.E(errors.NotExist, errors.Str("the current config was not loaded from a file"))
+	}
+	return profileFile(profile), nil
+}
+
+// getConfig returns the fields of the active profile's config file.
+func (s *server) getConfig() (*configFile, error) {
+	file, err := s.activeConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigFile(file, b)
+}
+
+// parseConfigFile returns a configFile describing the given contents
+// of the named file.
+func parseConfigFile(file string, b []byte) (*configFile, error) {
+	cf := &configFile{
+		File:     file,
+		Checksum: fmt.Sprintf("%x", sha256.Sum256(b)),
+	}
+	if err := yaml.Unmarshal(b, &cf.Fields); err != nil {
+		return nil, errors.E(errors.Invalid, errors.Errorf("parsing %s: %v", file, err))
+	}
+	var all yaml.MapSlice
+	if err := yaml.Unmarshal(b, &all); err != nil {
+		return nil, errors.E(errors.Invalid, errors.Errorf("parsing %s: %v", file, err))
+	}
+	known := make(map[string]bool)
+	for _, kv := range cf.Fields.values() {
+		known[kv[0]] = true
+	}
+	for _, item := range all {
+		if k := fmt.Sprint(item.Key); !known[k] {
+			cf.Other = append(cf.Other, k)
+		}
+	}
+	return cf, nil
+}
+
+// putConfig replaces the fields of the active profile's config file if the
+// file's checksum is still the given one. It validates the new config, keeps
+// a copy of the old file with the suffix configBackupSuffix, and writes the
+// new file atomically. Lines other than those of the fields are kept as
+// they are. The new config takes effect immediately, and the cacheserver
+// is started or stopped to suit it.
+func (s *server) putConfig(fields configFields, checksum string) (*configFile, error) {
+	file, err := s.activeConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	old, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := parseConfigFile(file, old)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != cur.Checksum {
+		return nil, errors.E(errors.Exist, errors.Errorf("%s has changed since it was read", file))
+	}
+	if fields.UserName != cur.Fields.UserName {
+		return nil, errors.E(errors.Invalid, errors.Str("the user name cannot be changed; sign up or switch profiles instead"))
+	}
+
+	b, err := editConfig(old, fields.values())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := config.InitConfig(bytes.NewReader(b)); err != nil {
+		return nil, errors.E(errors.Invalid, errors.Errorf("invalid config: %v", err))
+	}
+	if err := writeFileAtomic(file, b, 0, true); err != nil {
+		return nil, err
+	}
+	logf("config: wrote %s", file)
+
+	cfg, err := config.FromFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cli := client.New(cfg)
+	s.mu.Lock()
+	if s.profile != "" && profileFile(s.profile) == file {
+		// Requests take their own snapshot of these; see client.
+		s.cfg = cfg
+		s.cli = cli
+		s.asServers = nil
+	}
+	s.mu.Unlock()
+	if err := s.syncCache(cfg, file); err != nil {
+		logf("cache: %v", err)
+	}
+	return parseConfigFile(file, b)
+}
+
+// editConfig returns the config file contents b with each key in values set
+// to its value, or removed if the value is empty. Keys missing from b are
+// appended in the given order. Other lines, including comments, are kept as
+// they are.
+func editConfig(b []byte, values [][2]string) ([]byte, error) {
+	set := make(map[string]string)
+	for _, kv := range values {
+		set[kv[0]] = kv[1]
+	}
+	done := make(map[string]bool)
+
+	var out bytes.Buffer
+	lines := strings.SplitAfter(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		key := topLevelKey(line)
+		v, ok := set[key]
+		if !ok {
+			out.WriteString(line)
+			continue
+		}
+		// Skip any continuation lines of the old value.
+		old := line
+		for i+1 < len(lines) && isContinuation(lines[i+1]) {
+			i++
+			old += lines[i]
+		}
+		if done[key] {
+			// Drop duplicate keys.
+			continue
+		}
+		done[key] = true
+		if v == "" {
+			continue
+		}
+		if configValue(old, key) == v {
+			// Keep the line as the user wrote it.
+			out.WriteString(old)
+			continue
+		}
+		l, err := configLine(key, v)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString(l)
+	}
+	if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.WriteString("\n")
+	}
+	for _, kv := range values {
+		if done[kv[0]] || kv[1] == "" {
+			continue
+		}
+		l, err := configLine(kv[0], kv[1])
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString(l)
+	}
+	return out.Bytes(), nil
+}
+
+// topLevelKey returns the key of a line of the form "key: value" that is
+// not indented, or the empty string if line is not of that form.
+func topLevelKey(line string) string {
+	if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' {
+		return ""
+	}
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(line[:i])
+}
+
+// isContinuation reports whether line continues the value of the line
+// before it.
+func isContinuation(line string) bool {
+	return len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && strings.TrimSpace(line) != ""
+}
+
+// configValue returns the value given to key by the YAML in b, or the empty
+// string if b does not give key a string value.
+func configValue(b, key string) string {
+	var m map[string]string
+	if err := yaml.Unmarshal([]byte(b), &m); err != nil {
+		return ""
+	}
+	return m[key]
+}
+
+// configLine returns the YAML line that sets key to the scalar value v.
+// Values that read back as the same string without quotes are written
+// unquoted, as users write them; yaml.Marshal would quote "yes" and "no",
+// for instance, which are booleans in YAML 1.1.
+func configLine(key, v string) (string, error) {
+	if strings.ContainsAny(v, "\n\r") {
+		return "", errors.E(errors.Invalid, errors.Errorf("%s: value must be a single line", key))
+	}
+	if isPlainConfigValue(v) {
+		return key + ": " + v + "\n", nil
+	}
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return key + ": " + string(b), nil
+}
+
+// isPlainConfigValue reports whether v may be written as a plain YAML scalar
+// and read back into a string unchanged.
+func isPlainConfigValue(v string) bool {
+	if v == "" || v != strings.TrimSpace(v) {
+		return false
+	}
+	switch v {
+	case "~", "null", "Null", "NULL":
+		return false
+	}
+	if strings.ContainsRune("-?:,[]{}#&*!|>'\"%@`", rune(v[0])) {
+		return false
+	}
+	if strings.HasSuffix(v, ":") || strings.Contains(v, ": ") || strings.Contains(v, " #") || strings.ContainsRune(v, '\t') {
+		return false
+	}
+	return configValue("k: "+v, "k") == v
+}
+
+// writeFileAtomic writes data to the named file by way of a temporary file
+// in the same directory, so that the file is never left partially written.
+// The file is given the permissions perm or, if perm is zero, keeps its own;
+// a new file is then created with mode 0644. If backup is set, the previous
+// contents of the file are kept in a file with the suffix configBackupSuffix.
+func writeFileAtomic(file string, data []byte, perm os.FileMode, backup bool) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(file); err == nil {
+		mode = fi.Mode().Perm()
+		if backup {
+			old, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(file+configBackupSuffix, old, mode); err != nil {
+				return err
+			}
+		}
+	}
+
+	if perm != 0 {
+		mode = perm
+	}
+
+	// A leading dot keeps the temporary file from being taken for a
+	// profile.
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestEditConfig(t *testing.T) {
+	const file = "# My config.\nusername: ann@example.com\ncache: yes\neditor: vi\n"
+	tests := []struct {
+		name   string
+		in     string
+		values [][2]string
+		want   string
+	}{{
+		name:   "unchanged",
+		in:     file,
+		values: [][2]string{{"username", "ann@example.com"}, {"cache", "yes"}},
+		want:   file,
+	}, {
+		name:   "unchanged quoted",
+		in:     "cache: \"yes\"\n",
+		values: [][2]string{{"cache", "yes"}},
+		want:   "cache: \"yes\"\n",
+	}, {
+		name:   "enable cache",
+		in:     "username: ann@example.com\n",
+		values: [][2]string{{"cache", "yes"}},
+		want:   "username: ann@example.com\ncache: yes\n",
+	}, {
+		name:   "change",
+		in:     file,
+		values: [][2]string{{"username", "bob@example.com"}, {"cache", "no"}},
+		want:   "# My config.\nusername: bob@example.com\ncache: no\neditor: vi\n",
+	}, {
+		name:   "remove",
+		in:     file,
+		values: [][2]string{{"cache", ""}},
+		want:   "# My config.\nusername: ann@example.com\neditor: vi\n",
+	}, {
+		name:   "continuation",
+		in:     "dirserver:\n  remote,dir.example.com\neditor: vi\n",
+		values: [][2]string{{"dirserver", "remote,dir.example.com:443"}},
+		want:   "dirserver: remote,dir.example.com:443\neditor: vi\n",
+	}, {
+		name:   "quoted values",
+		in:     "",
+		values: [][2]string{{"a", "~"}, {"b", "x: y"}, {"c", "#x"}, {"d", " x"}, {"e", "null"}},
+		want:   "a: \"~\"\nb: 'x: y'\nc: '#x'\nd: ' x'\ne: \"null\"\n",
+	}}
+	for _, test := range tests {
+		got, err := editConfig([]byte(test.in), test.values)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+	if _, err := editConfig([]byte(file), [][2]string{{"editor", "vi\nx: y"}}); err == nil {
+		t.Errorf("multi-line value: got no error")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// contentType returns the MIME type of the named file. It uses the file's
+// extension if it is known, and otherwise sniffs the first bytes of the
+// file's content, which it reads from rs before seeking back to the start.
+func contentType(name string, rs io.ReadSeeker) (string, error) {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+	var buf [sniffLen]byte
+	n, err := io.ReadFull(rs, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// activeContentTypes lists the MIME types that a browser may execute,
+// and that must therefore not be displayed on the upspin-ui origin.
+var activeContentTypes = []string{
+	"application/ecmascript",
+	"application/javascript",
+	"application/x-javascript",
+	"application/xhtml+xml",
+	"application/xml",
+	"image/svg+xml",
+	"text/ecmascript",
+	"text/html",
+	"text/javascript",
+	"text/xml",
+}
+
+// isActiveContent reports whether the given MIME type
+// is one that a browser may execute.
+func isActiveContent(ctype string) bool {
+	mediaType, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		// Be conservative with types we don't understand.
+		return true
+	}
+	for _, t := range activeContentTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
+// setContentHeaders sets the Content-Type, Content-Disposition, and related
+// security headers for serving the named file with the given MIME type.
+// The file is served as an attachment (a download) if download is true or if
+// its type is active content; otherwise it is served inline.
+// Content is served in a sandbox, so that it is treated as coming from a
+// unique origin and cannot script the upspin-ui origin. PDFs are exempt,
+// as browsers refuse to display them in a sandbox.
+func setContentHeaders(w http.ResponseWriter, name, ctype string, download bool) {
+	disposition := "inline"
+	if download || isActiveContent(ctype) {
+		disposition = "attachment"
+	}
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": path.Base(name),
+	}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	if !strings.HasPrefix(ctype, "application/pdf") {
+		h.Set("Content-Security-Policy", "sandbox")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/xsrftoken"
+
+	"upspin.io/upspin"
+)
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"a.html", "plain text", "text/html"},
+		{"a.svg", "plain text", "image/svg+xml"},
+		{"a.png", "<html>", "image/png"},
+		{"page", "<html><script>alert(1)</script>", "text/html"},
+		{"notes", "plain text", "text/plain"},
+		{"pixel", "\x89PNG\r\n\x1a\n", "image/png"},
+	}
+	for _, test := range tests {
+		rs := strings.NewReader(test.content)
+		got, err := contentType(test.name, rs)
+		if err != nil {
+			t.Errorf("contentType(%q): %v", test.name, err)
+			continue
+		}
+		if !strings.HasPrefix(got, test.want) {
+			t.Errorf("contentType(%q) = %q, want %q", test.name, got, test.want)
+		}
+		// The content must still be readable in full.
+		if b, _ := ioutil.ReadAll(rs); string(b) != test.content {
+			t.Errorf("contentType(%q) left %q to read, want %q", test.name, b, test.content)
+		}
+	}
+}
+
+// TestServeContentHeaders checks that files whose content a browser may
+// execute are served as sandboxed attachments, and that others are shown
+// inline, though still sandboxed.
+func TestServeContentHeaders(t *testing.T) {
+	const user = "content@example.com"
+	s, err := newInProcessServer(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	_, cli := s.client()
+
+	tests := []struct {
+		name, content string
+		download      bool
+		ctype         string
+		disposition   string
+		sandbox       bool
+	}{
+		{"page.html", "<script>alert(1)</script>", false, "text/html", "attachment", true},
+		{"image.svg", `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`, false, "image/svg+xml", "attachment", true},
+		{"page", "<html><script>alert(1)</script>", false, "text/html", "attachment", true},
+		{"notes.txt", "plain text", false, "text/plain", "inline", true},
+		{"notes.txt", "plain text", true, "text/plain", "attachment", true},
+		{"pixel.png", "\x89PNG\r\n\x1a\n", false, "image/png", "inline", true},
+		{"doc.pdf", "%PDF-1.4\n", false, "application/pdf", "inline", false},
+	}
+	for _, test := range tests {
+		name := upspin.PathName(user + "/" + test.name)
+		if _, err := cli.Put(name, []byte(test.content)); err != nil {
+			t.Fatal(err)
+		}
+		u := ts.URL + "/" + string(name) + "?token=" + url.QueryEscape(xsrftoken.Generate(s.key, s.xsrfUser(), string(name)))
+		if test.download {
+			u += "&download=1"
+		}
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != test.content {
+			t.Errorf("%s: status %q and body %q, want 200 and %q", test.name, resp.Status, body, test.content)
+			continue
+		}
+		h := resp.Header
+		if got := h.Get("Content-Type"); !strings.HasPrefix(got, test.ctype) {
+			t.Errorf("%s: Content-Type %q, want %q", test.name, got, test.ctype)
+		}
+		if got, want := h.Get("Content-Disposition"), test.disposition+"; filename="+test.name; got != want {
+			t.Errorf("%s: Content-Disposition %q, want %q", test.name, got, want)
+		}
+		if got := h.Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("%s: X-Content-Type-Options %q, want nosniff", test.name, got)
+		}
+		want := ""
+		if test.sandbox {
+			want = "sandbox"
+		}
+		if got := h.Get("Content-Security-Policy"); got != want {
+			t.Errorf("%s: Content-Security-Policy %q, want %q", test.name, got, want)
+		}
+	}
+}
@@ -17,8 +17,10 @@ import (
 // It uses Client.PutDuplicate to copy files, so file content is not copied;
 // the underlying DirBlocks do not change.
 func (s *server) copy(dst upspin.PathName, srcs []upspin.PathName) error {
+	_, cli := s.client()
+
 	// Check that the destination exists and is a directory.
-	dstEntry, err := s.cli.Lookup(dst, true)
+	dstEntry, err := cli.Lookup(dst, true)
 	if err != nil {
 		return err
 	}
@@ -30,22 +32,23 @@ func (s *server) copy(dst upspin.PathName, srcs []upspin.PathName) error {
 	for _, src := range srcs {
 		// Lookup src, but don't follow links.
 		// We will make a copy of those links, not traverse them.
-		srcEntry, err := s.cli.Lookup(src, false)
+		srcEntry, err := cli.Lookup(src, false)
 		if err != nil {
 			return err
 		}
-		if err := s.copyEntry(dst, srcEntry); err != nil {
+		if err := copyEntry(cli, dst, srcEntry); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// copyEntry copies the given entry to the given destination directory.
+// copyEntry copies the given entry to the given destination directory
+// using the given client.
 // If the entry is a directory then the directory is copied recursively.
 // If the entry is a link then an equivalent link is created in dstDir.
 // This function assuems that dstDir exists and is a directory.
-func (s *server) copyEntry(dstDir upspin.PathName, srcEntry *upspin.DirEntry) error {
+func copyEntry(cli upspin.Client, dstDir upspin.PathName, srcEntry *upspin.DirEntry) error {
 	srcPath, err := path.Parse(srcEntry.Name)
 	if err != nil {
 		return err
@@ -65,10 +68,10 @@ func (s *server) copyEntry(dstDir upspin.PathName, srcEntry *upspin.DirEntry) er
 	switch {
 	case srcEntry.IsDir():
 		// Recur into directories.
-		if _, err := s.cli.MakeDirectory(dst); err != nil {
+		if _, err := cli.MakeDirectory(dst); err != nil {
 			return err
 		}
-		dir, err := s.cli.DirServer(srcEntry.Name)
+		dir, err := cli.DirServer(srcEntry.Name)
 		if err != nil {
 			return err
 		}
@@ -77,16 +80,16 @@ func (s *server) copyEntry(dstDir upspin.PathName, srcEntry *upspin.DirEntry) er
 			return err
 		}
 		for _, de := range des {
-			if err := s.copyEntry(dst, de); err != nil {
+			if err := copyEntry(cli, dst, de); err != nil {
 				return err
 			}
 		}
 	case srcEntry.IsLink():
-		if _, err := s.cli.PutLink(srcEntry.Link, dst); err != nil {
+		if _, err := cli.PutLink(srcEntry.Link, dst); err != nil {
 			return err
 		}
 	default:
-		if _, err := s.cli.PutDuplicate(srcEntry.Name, dst); err != nil {
+		if _, err := cli.PutDuplicate(srcEntry.Name, dst); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,362 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"upspin.io/errors"
+	"upspin.io/flags"
+	"upspin.io/upspin"
+)
+
+// deploySpec describes a user's Upspin setup, to be performed without
+// prompting by "upspin-ui -headless deploy". It is read from a YAML or JSON
+// file. At most one of DirServer, NoServers, and GCP should be set.
+type deploySpec struct {
+	UserName upspin.UserName `yaml:"username"`
+
+	// A self-hosted key server at which to sign up, and the directory of
+	// TLS root certificates with which to reach it. They default to the
+	// -keyserver and -tlscerts flags. A relative TLSCerts is relative to
+	// the spec file.
+	KeyServer string `yaml:"keyserver"`
+	TLSCerts  string `yaml:"tlscerts"`
+
+	// Existing servers. StoreServer defaults to DirServer.
+	DirServer   string `yaml:"dirserver"`
+	StoreServer string `yaml:"storeserver"`
+
+	// No servers; read-only access.
+	NoServers bool `yaml:"noservers"`
+
+	// A new upspinserver deployed to Google Cloud Platform.
+	GCP *struct {
+		// KeyFile is the service account JSON private key file.
+		// A relative path is relative to the spec file.
+		KeyFile        string            `yaml:"keyfile"`
+		Bucket         string            `yaml:"bucket"`         // Default derived from the project ID.
+		BucketLocation string            `yaml:"bucketlocation"` // Default "us".
+		Zone           string            `yaml:"zone"`           // Region and zone, such as "us-central1/us-central1-c".
+		ServerSuffix   string            `yaml:"serversuffix"`   // Default suggested by startup.
+		HostName       string            `yaml:"hostname"`       // Default is a name under upspin.services.
+		Writers        []upspin.UserName `yaml:"writers"`
+	} `yaml:"gcp"`
+
+	// Timeout limits the time taken by the whole process, most of which
+	// is spent waiting for the user to verify their email address and for
+	// the server's host name to resolve.
+	// It is a duration such as "30m" and defaults to one hour.
+	Timeout string `yaml:"timeout"`
+}
+
+// Intervals between polls of steps that wait on external events.
+const (
+	verifyPollInterval   = 10 * time.Second
+	hostNamePollInterval = 30 * time.Second
+)
+
+// readDeploySpec reads and validates the deploySpec in the named file.
+func readDeploySpec(file string) (*deploySpec, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var spec deploySpec
+	// JSON is a subset of YAML, so this reads either.
+	if err := yaml.UnmarshalStrict(b, &spec); err != nil {
+		return nil, errors.E(errors.Invalid, errors.Errorf("reading %s: %v", file, err))
+	}
+	n := 0
+	if spec.DirServer != "" {
+		n++
+	}
+	if spec.NoServers {
+		n++
+	}
+	if spec.GCP != nil {
+		n++
+		if spec.GCP.KeyFile == "" {
+			return nil, errors.E(errors.Invalid, errors.Errorf("%s: gcp.keyfile must be set", file))
+		}
+		if !filepath.IsAbs(spec.GCP.KeyFile) {
+			spec.GCP.KeyFile = filepath.Join(filepath.Dir(file), spec.GCP.KeyFile)
+		}
+	}
+	if n > 1 {
+		return nil, errors.E(errors.Invalid, errors.Errorf("%s: only one of dirserver, noservers, and gcp may be set", file))
+	}
+	if spec.StoreServer != "" && spec.DirServer == "" {
+		return nil, errors.E(errors.Invalid, errors.Errorf("%s: storeserver requires dirserver", file))
+	}
+	if spec.TLSCerts != "" && !filepath.IsAbs(spec.TLSCerts) {
+		spec.TLSCerts = filepath.Join(filepath.Dir(file), spec.TLSCerts)
+	}
+	if spec.Timeout == "" {
+		spec.Timeout = "1h"
+	}
+	if _, err := time.ParseDuration(spec.Timeout); err != nil {
+		return nil, errors.E(errors.Invalid, errors.Errorf("%s: invalid timeout: %v", file, err))
+	}
+	return &spec, nil
+}
+
+// deployStep records the outcome of answering a step of the startup process.
+type deployStep struct {
+	step   string
+	action string
+	err    error
+}
+
+// deployer answers the steps of the startup process from a deploySpec,
+// recording each step that it answers.
+type deployer struct {
+	spec     *deploySpec
+	out      io.Writer
+	deadline time.Time
+
+	steps   []deployStep
+	waiting string // Step that is being polled, if any.
+	lastErr error  // Most recent error while polling.
+}
+
+// headlessDeploy performs the setup described by the spec file named by
+// args[0] without prompting.
+func headlessDeploy(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.Str("usage: upspin-ui -headless deploy <spec file>")
+	}
+	spec, err := readDeploySpec(args[0])
+	if err != nil {
+		return err
+	}
+	s, err := newServer()
+	if err != nil {
+		return err
+	}
+	return deploy(s, spec, out)
+}
+
+// deploy performs the setup described by spec using s, printing its
+// progress and a report of the outcome of each step to out.
+func deploy(s *server, spec *deploySpec, out io.Writer) error {
+	timeout, _ := time.ParseDuration(spec.Timeout)
+	d := &deployer{
+		spec:     spec,
+		out:      out,
+		deadline: time.Now().Add(timeout),
+	}
+	cfg, err := runStartup(s, d.answer)
+	if err != nil {
+		if len(d.steps) == 0 {
+			d.steps = append(d.steps, deployStep{step: "load config", err: err})
+		}
+		d.report()
+		return errors.Errorf("deployment failed: %v", err)
+	}
+	d.report()
+	fmt.Fprintf(out, "\nSetup is complete. You are %s.\n", cfg.UserName())
+	fmt.Fprintf(out, "Your config file is %s.\n", flags.Config)
+	return nil
+}
+
+// report prints the outcome of each step.
+func (d *deployer) report() {
+	fmt.Fprintln(d.out, "\nSteps:")
+	for _, s := range d.steps {
+		desc := s.step
+		if s.action != "" {
+			desc += " (" + s.action + ")"
+		}
+		if s.err != nil {
+			fmt.Fprintf(d.out, "  FAILED %s: %v\n", desc, s.err)
+		} else {
+			fmt.Fprintf(d.out, "  ok     %s\n", desc)
+		}
+	}
+}
+
+func (d *deployer) answer(resp *startupResponse, err error) (*startupRequest, error) {
+	if err != nil {
+		if resp.Step == "waitServerHostName" && d.waiting == resp.Step {
+			// The host name doesn't resolve yet; keep waiting.
+			d.lastErr = err
+			return d.poll(resp, hostNamePollInterval, &startupRequest{Action: "checkServerHostName"})
+		}
+		// The previous answer to this step failed.
+		d.steps[len(d.steps)-1].err = err
+		return nil, errors.Errorf("step %q failed: %v", resp.Step, err)
+	}
+	if d.waiting != "" && d.waiting != resp.Step {
+		// Finished waiting.
+		d.waiting = ""
+	}
+	for _, s := range d.steps {
+		if s.step == resp.Step && d.waiting == "" {
+			err := errors.Errorf("step %q did not complete", resp.Step)
+			d.steps = append(d.steps, deployStep{step: resp.Step, err: err})
+			return nil, err
+		}
+	}
+
+	spec, gcp := d.spec, d.spec.GCP
+	var req *startupRequest
+	switch resp.Step {
+	case "signup":
+		if spec.UserName == "" {
+			return nil, d.fail(resp.Step, errors.Str("no config file exists and the spec has no username"))
+		}
+		req = &startupRequest{
+			Action:    "signup",
+			UserName:  spec.UserName,
+			KeyServer: spec.KeyServer,
+			TLSCerts:  spec.TLSCerts,
+		}
+
+	case "secretSeed", "serverSecretSeed":
+		fmt.Fprintf(d.out, "Keys for the %s have been written to %s.\n", seedOwner(resp.Step), resp.KeyDir)
+		fmt.Fprintf(d.out, "The secret seed is %s\n", resp.SecretSeed)
+		fmt.Fprintln(d.out, "Record it somewhere safe; it can be used to recover the keys if they are lost.")
+		req = &startupRequest{}
+
+	case "confirmSeed", "confirmServerSeed":
+		// Nobody is at hand to type back the seed that was printed,
+		// so answer from the key files.
+		seed, err := readSecretSeed(resp.KeyDir)
+		if err != nil {
+			return nil, d.fail(resp.Step, err)
+		}
+		words := seedWords(seed)
+		var answer []string
+		for _, pos := range resp.SeedWords {
+			if pos < 1 || pos > len(words) {
+				return nil, d.fail(resp.Step, errors.Errorf("the secret seed has no word %d", pos))
+			}
+			answer = append(answer, words[pos-1])
+		}
+		req = &startupRequest{Action: "confirmSeed", SeedWords: answer}
+
+	case "verify":
+		if d.waiting == "" {
+			fmt.Fprintf(d.out, "Waiting for %s to click the verification link sent by email.\n", resp.UserName)
+		}
+		return d.poll(resp, verifyPollInterval, &startupRequest{})
+
+	case "serverSelect":
+		switch {
+		case spec.DirServer != "":
+			store := spec.StoreServer
+			if store == "" {
+				store = spec.DirServer
+			}
+			req = &startupRequest{Action: "specifyEndpoints", DirServer: spec.DirServer, StoreServer: store}
+		case spec.NoServers:
+			req = &startupRequest{Action: "specifyNoEndpoints"}
+		case gcp != nil:
+			b, err := ioutil.ReadFile(gcp.KeyFile)
+			if err != nil {
+				return nil, d.fail(resp.Step, err)
+			}
+			req = &startupRequest{Action: "specifyGCP", PrivateKeyData: string(b)}
+		default:
+			return nil, d.fail(resp.Step, errors.Str("the spec must set one of dirserver, noservers, or gcp"))
+		}
+
+	case "gcpDetails":
+		if gcp == nil {
+			return nil, d.fail(resp.Step, errors.Str("a GCP deployment is in progress but the spec has no gcp section"))
+		}
+		bucket, loc := gcp.Bucket, gcp.BucketLocation
+		if bucket == "" {
+			bucket = resp.BucketName
+		}
+		if loc == "" {
+			loc = "us"
+		}
+		if gcp.Zone == "" {
+			return nil, d.fail(resp.Step, errors.Errorf("gcp.zone must be set; zones are: %s", strings.Join(resp.Zones, " ")))
+		}
+		req = &startupRequest{Action: "createGCP", BucketName: bucket, BucketLoc: loc, RegionZone: gcp.Zone}
+
+	case "serverUserName":
+		suffix := resp.UserNameSuffix
+		if gcp != nil && gcp.ServerSuffix != "" {
+			suffix = gcp.ServerSuffix
+		}
+		req = &startupRequest{Action: "configureServerUserName", UserNameSuffix: suffix}
+
+	case "serverHostName":
+		var host string
+		if gcp != nil {
+			host = gcp.HostName
+		}
+		req = &startupRequest{Action: "configureServerHostName", HostName: host}
+
+	case "waitServerHostName":
+		if d.waiting == "" {
+			fmt.Fprintf(d.out, "Waiting for %s to resolve to %s.\n", resp.HostName, resp.IPAddr)
+			// Check straight away, in case it already does.
+			d.waiting = resp.Step
+			d.steps = append(d.steps, deployStep{step: resp.Step, action: "checkServerHostName"})
+			return &startupRequest{Action: "checkServerHostName"}, nil
+		}
+		return d.poll(resp, hostNamePollInterval, &startupRequest{Action: "checkServerHostName"})
+
+	case "serverWriters":
+		var writers []string
+		if gcp != nil {
+			for _, w := range gcp.Writers {
+				writers = append(writers, string(w))
+			}
+		}
+		req = &startupRequest{Action: "configureServer", Writers: strings.Join(writers, " ")}
+
+	default:
+		return nil, d.fail(resp.Step, errors.Errorf("unknown startup step %q", resp.Step))
+	}
+	d.steps = append(d.steps, deployStep{step: resp.Step, action: req.Action})
+	return req, nil
+}
+
+// poll waits for the given interval and returns req, to ask again whether
+// the external event awaited by the given step has happened. It fails once
+// the deadline has passed.
+func (d *deployer) poll(resp *startupResponse, interval time.Duration, req *startupRequest) (*startupRequest, error) {
+	if d.waiting == "" {
+		d.waiting = resp.Step
+		d.steps = append(d.steps, deployStep{step: resp.Step, action: req.Action})
+	}
+	if time.Now().Add(interval).After(d.deadline) {
+		err := errors.Errorf("timed out after %s", d.spec.Timeout)
+		if d.lastErr != nil {
+			err = errors.Errorf("%v; last error: %v", err, d.lastErr)
+		}
+		d.steps[len(d.steps)-1].err = err
+		return nil, errors.Errorf("step %q failed: %v", resp.Step, err)
+	}
+	time.Sleep(interval)
+	return req, nil
+}
+
+// fail records that the given step could not be answered.
+func (d *deployer) fail(step string, err error) error {
+	d.steps = append(d.steps, deployStep{step: step, err: err})
+	return errors.Errorf("step %q failed: %v", step, err)
+}
+
+// seedOwner describes whose keys are shown in the given step.
+func seedOwner(step string) string {
+	if step == "serverSecretSeed" {
+		return "server user"
+	}
+	return "user"
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"augie.upspin.io/fakegcp"
+
+	"upspin.io/errors"
+	"upspin.io/flags"
+	"upspin.io/upspin"
+)
+
+// TestDeploy runs deployments from specs against the in-process servers
+// and a fake Google Cloud API server, checking that each reports the step
+// that failed, if any.
+func TestDeploy(t *testing.T) {
+	fake, err := fakegcp.NewServer("deploy-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	defer func() { gcpAPIBase = "" }()
+	gcpAPIBase = fake.URL
+
+	tests := []struct {
+		name       string
+		user       upspin.UserName
+		registered bool // Whether the user has verified their email address.
+		spec       string
+		err        string   // Substring of the error; empty for success.
+		out        []string // Substrings of the output.
+	}{{
+		name:       "no servers",
+		user:       "deploy-none@example.com",
+		registered: true,
+		spec:       "noservers: true\n",
+		out: []string{
+			"  ok     serverSelect (specifyNoEndpoints)\n",
+			"Setup is complete. You are deploy-none@example.com.",
+		},
+	}, {
+		name:       "unreachable dirserver",
+		user:       "deploy-dir@example.com",
+		registered: true,
+		spec:       "dirserver: 127.0.0.1:1\n",
+		err:        `step "serverSelect" failed`,
+		out:        []string{"  FAILED serverSelect (specifyEndpoints): "},
+	}, {
+		name:       "gcp without zone",
+		user:       "deploy-nozone@example.com",
+		registered: true,
+		spec:       "gcp:\n  keyfile: key.json\n",
+		err:        `step "gcpDetails" failed`,
+		out: []string{
+			"  ok     serverSelect (specifyGCP)\n",
+			"  FAILED gcpDetails: gcp.zone must be set; zones are: europe-west1/europe-west1-b",
+		},
+	}, {
+		name:       "gcp bad zone",
+		user:       "deploy-badzone@example.com",
+		registered: true,
+		spec:       "gcp:\n  keyfile: key.json\n  zone: us-central1/us-central1-z\n",
+		err:        `step "gcpDetails" failed`,
+		out: []string{
+			"  ok     serverSelect (specifyGCP)\n",
+			"  FAILED gcpDetails (createGCP): ",
+		},
+	}, {
+		name: "verification timeout",
+		user: "deploy-verify@example.com",
+		spec: "noservers: true\ntimeout: 1s\n",
+		err:  `step "verify" failed: timed out after 1s`,
+		out: []string{
+			"Waiting for deploy-verify@example.com to click the verification link",
+			"  FAILED verify: timed out after 1s\n",
+		},
+	}}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			home, restore := tempHome(t)
+			defer restore()
+			flags.Config = filepath.Join(home, "upspin", "config")
+			if err := makeDeployUser(test.user, test.registered); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(home, "key.json"), fake.ServiceAccountKey(), 0600); err != nil {
+				t.Fatal(err)
+			}
+			specFile := filepath.Join(home, "spec.yaml")
+			if err := ioutil.WriteFile(specFile, []byte(test.spec), 0600); err != nil {
+				t.Fatal(err)
+			}
+			spec, err := readDeploySpec(specFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := newServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				s.mu.Lock()
+				s.stopVerifier()
+				s.mu.Unlock()
+			}()
+
+			var out bytes.Buffer
+			err = deploy(s, spec, &out)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("got error %v, want success", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+			for _, want := range test.out {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+// makeDeployUser writes a config file for the given user of the in-process
+// key server that names no directory or store server, as signup does. If
+// registered is set, the user is registered with the key server, as if
+// they had verified their email address.
+func makeDeployUser(user upspin.UserName, registered bool) error {
+	if registered {
+		if err := makeProfile(flags.Config, user); err != nil {
+			return err
+		}
+	} else {
+		if _, err := inProcessConfig("nobody@example.com"); err != nil {
+			return err
+		}
+		if _, _, err := genkey(user); err != nil {
+			return err
+		}
+	}
+	ks := keyServerSettings{Endpoint: inProcess}
+	return writeConfig(flags.Config, user, ks, upspin.Endpoint{}, upspin.Endpoint{}, true)
+}
+
+// TestDeployerRepeatedStep checks that a step that is presented again after
+// it was answered successfully is reported as not having completed, rather
+// than answered forever.
+func TestDeployerRepeatedStep(t *testing.T) {
+	var out bytes.Buffer
+	d := &deployer{
+		spec:     &deploySpec{NoServers: true, Timeout: "1h"},
+		out:      &out,
+		deadline: time.Now().Add(time.Hour),
+	}
+	resp := &startupResponse{Step: "serverSelect"}
+	req, err := d.answer(resp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Action != "specifyNoEndpoints" {
+		t.Fatalf("got action %q, want specifyNoEndpoints", req.Action)
+	}
+	if _, err := d.answer(resp, nil); err == nil || !strings.Contains(err.Error(), "did not complete") {
+		t.Fatalf("repeated step: got error %v, want step that did not complete", err)
+	}
+	d.report()
+	want := "  ok     serverSelect (specifyNoEndpoints)\n  FAILED serverSelect: step \"serverSelect\" did not complete\n"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("report is\n%s\nwant it to contain\n%s", out.String(), want)
+	}
+}
+
+// TestDeployerHostNameTimeout checks that waiting for the server's host name
+// to resolve gives up at the deadline, reporting the last error seen.
+func TestDeployerHostNameTimeout(t *testing.T) {
+	var out bytes.Buffer
+	d := &deployer{
+		spec:     &deploySpec{Timeout: "1s"},
+		out:      &out,
+		deadline: time.Now().Add(time.Second),
+	}
+	resp := &startupResponse{Step: "waitServerHostName", HostName: "upspin.example.com", IPAddr: "192.0.2.1"}
+	req, err := d.answer(resp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Action != "checkServerHostName" {
+		t.Fatalf("got action %q, want checkServerHostName", req.Action)
+	}
+	_, err = d.answer(resp, errors.Str("upspin.example.com does not resolve"))
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1s; last error: upspin.example.com does not resolve") {
+		t.Fatalf("got error %v, want a timeout with the last error", err)
+	}
+	d.report()
+	for _, want := range []string{
+		"Waiting for upspin.example.com to resolve to 192.0.2.1.\n",
+		"  FAILED waitServerHostName (checkServerHostName): timed out after 1s",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
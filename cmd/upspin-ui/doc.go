@@ -24,6 +24,10 @@ directory.
 
 Clicking the name of an entry will attempt to download the entry with your web
 browser or, if the entry is a directory, will navigate to that directory.
+Markdown files, images, PDFs, and common text files are instead opened in a
+preview: Markdown is rendered as HTML and text is syntax highlighted.
+Images are shown in the directory listing as thumbnails, which are cached in
+$HOME/upspin/upspin-ui/thumbnails.
 
 At startup, the left pane displays the current user's root and the right pane
 displays the path augie@upspin.io.
@@ -43,19 +47,223 @@ directory.
 The "Refresh" button reloads the contents of the directory and displays it.
 
 The info buttons (a little "i" in a circle, to the right of each file) display
-extended information for a given directory entry.
+extended information for a given directory entry, and a button to download it.
+Files that a web browser might execute, such as HTML and SVG, are always
+downloaded rather than displayed.
+
+The edit buttons (a little pencil, to the right of each file) open small text
+files, such as Access and Group files, in an editor.
+Saving an edited file fails if the file has been changed since it was opened.
+
+Confirming the secret seed
+
+The secret seed shown at signup, and that of the server user of a GCP
+deployment, can regenerate lost keys. Before signup moves on, the user must
+type back three randomly chosen words of the seed to show that they wrote it
+down. Wrong answers are logged without the words typed. The seed may be shown
+again, read from the key files, from the confirmation dialog or, until the
+email address is verified, from the verification dialog.
+
+Verifying the email address
+
+After signup the key server sends the user an email with a link that completes
+their registration. While waiting for the link to be clicked, upspin-ui polls
+the key server, at first every two seconds and backing off to once a minute,
+and the signup dialog moves on by itself once the user appears. Another email
+may be requested at most once a minute.
+
+Recovering an existing user
+
+A user who has already signed up but has lost their keys or config file may
+recover them by choosing "I already have an Upspin user" in the signup dialog
+and entering their user name and the secret seed shown to them at signup.
+The keys are regenerated from the seed and checked against the public key
+registered with the key server, then saved in the usual place. The new config
+file names the directory and store servers registered with the key server.
+Recovery never replaces existing key or config files.
+
+Read-only mode
+
+A user who chooses not to nominate directory and store servers during signup
+is in read-only mode: they may browse the trees of others, but have no tree
+of their own. The header then shows a "read-only" label, and upspin-ui refuses
+requests that would modify a tree, including WebDAV writes, with the error
+kind "ReadOnly". The "servers" link beside the label names directory and
+store servers later, without repeating signup: upspin-ui checks that the
+store server is up, creates the user's root, updates the key server records
+of the user and their snapshot user, and sets the servers in the config file.
+
+The same dialog, under the "servers" link, moves a user who has a tree to new
+directory and store servers, such as when their directory server moves to a
+new host. The tree must be copied to the new servers first: upspin-ui checks
+that the new store server is up and that the new directory server holds the
+user's root before it changes the key server records and the config file.
+If the config file cannot be updated, the key server record is restored.
+
+Key rotation
+
+The "rotate keys" link at the top of the page replaces the current user's
+keys with a new key pair and registers the new public key with the key server
+(and for the user's snapshot user, if there is one). The old secret key is
+appended to secret2.upspinkey in the key directory, so that files encrypted
+for it can still be read. Optionally, upspin-ui then walks the user's tree
+and re-wraps the key of each encrypted file for its readers' current keys,
+as "upspin share -fix" does, showing its progress as it goes. The new secret
+seed is displayed and should be recorded in place of the old one.
+
+Profiles
+
+A user with several Upspin identities, such as a personal user, a team user,
+and the server users created by the deployment process, may switch between
+them without restarting upspin-ui. Each config file in the same directory as
+the -config file, and named after it with a dot and a suffix, is a profile
+named by that suffix; the -config file itself is the profile "default". For
+example, $HOME/upspin/config.team is the profile "team". When there is more
+than one, a menu beside the user name switches between them. Download links
+are issued for the active profile only, so switching reloads the page.
+
+A browser pane may also act as a server user of the current user, such as
+the user created to run an upspinserver deployed to Google Cloud Platform,
+whose config is written alongside the -config file. When such a config
+exists, an "Act as" menu at the top of each pane selects the user; the pane
+then lists, edits, and modifies files as that user. This lets the owner of a
+server inspect and repair the files that the server user owns, such as its
+root Access file.
+
+Settings
+
+The "settings" link at the top of the page edits the active profile's config
+file: its key, directory, and store servers, packing, cache, TLS certificate
+directory, and key directory. The user name cannot be changed; sign up or
+switch profiles instead. The edited config is checked before it is saved,
+and takes effect at once. Comments and settings that upspin-ui does not edit
+are kept as they are, and the previous file is kept with the suffix ".bak".
+If the file has changed since it was read, the edit is refused.
+
+Cache
+
+The "cache" link at the top of the page turns the cache on or off for the
+active profile by setting "cache: yes" in its config file. When the cache is
+on and no cacheserver is listening at its endpoint, upspin-ui starts one,
+with the -cachedir and -cachesize flags of upspin-ui, and starts or stops it
+as profiles are switched so that it always serves the active profile's user.
+The panel shows whether the cacheserver is running and the number of files
+and bytes in its cache directory; the cacheserver does not report its hit
+rate. A cacheserver started by upspin-ui may be restarted, or flushed, which
+removes the contents of the cache while it is stopped. A cacheserver started
+by other means is left alone.
+
+Self-hosted key servers
+
+A private Upspin deployment may run its own key server, whose certificate may
+be signed by a root that is not among the system's. The signup dialog offers
+to name the key server and a directory of PEM files holding the roots that
+sign its certificate; they default to the -keyserver and -tlscerts flags.
+They are written to the config file as the keyserver and tlscerts settings,
+and kept there when upspin-ui later rewrites the file, such as when servers
+are chosen. Once the config file exists, every key server request made
+during startup uses the settings in the file rather than the flags, as do the
+config files written for server users. Recovering a user looks them up on
+the key server chosen in the signup dialog.
+
+Headless signup
+
+The command
+
+	upspin-ui -headless signup
+
+runs the signup and deployment process in the terminal instead of the web
+browser, prompting for each answer in turn. It is useful on machines without
+a browser, such as those accessed over SSH. Answers may instead be given
+with flags following the word "signup":
+
+	-user name          Upspin user name to sign up
+	-secretseedfile f   recover the keys of an existing user from the seed
+	                    in this file, or from standard input if f is "-"
+	-dirserver host     directory server host name
+	-storeserver host   store server host name (default is -dirserver)
+	-noservers          do not use a directory or store server
+	-gcpkey file        deploy an upspinserver to GCP using this private key
+	-bucket name        GCP storage bucket name
+	-bucketloc location GCP storage bucket location
+	-zone region/zone   GCP region and zone for the upspinserver
+	-serversuffix s     user name suffix for the upspinserver user
+	-hostname host      host name for the upspinserver
+	-writers users      users that may write to the upspinserver
+
+If an answer given by a flag is rejected, the user is prompted for another.
+The secret seed is read from a file rather than given as a flag so that it
+does not appear in the process list, where other users of the machine could
+see it.
+
+Deployment from a spec file
+
+The command
+
+	upspin-ui -headless deploy spec.yaml
+
+performs the whole signup and deployment process without prompting, taking
+its answers from the given YAML or JSON file. For example:
+
+	username: ann@example.com
+	gcp:
+	  keyfile: ann-project-key.json
+	  zone: us-central1/us-central1-c
+	  serversuffix: upspinserver
+	  writers: [bob@example.com]
+	timeout: 2h
+
+Instead of the gcp section, a spec may name existing servers with dirserver
+and storeserver, or choose no servers with "noservers: true". The gcp section
+may also set bucket, bucketlocation, and hostname; the defaults are those
+suggested by the browser interface. The key file is relative to the spec file.
+A spec may also set keyserver and tlscerts; see "Self-hosted key servers".
+
+While the user's email address is unverified, or the server's host name does
+not yet resolve, the command waits, until the timeout (default one hour) has
+passed since it started. On completion or failure it prints each step and its
+outcome, identifying the step that failed. As with the browser interface, an
+interrupted GCP deployment resumes where it left off when run again.
+The secret seeds are printed; as nobody is at hand to type them back, the
+command confirms them from the key files.
+
+HTTP API
+
+The browser user interface is implemented using a JSON API served beneath
+/api/v1/. Requests must carry the request key in the X-Upspin-Key header.
+File operations may be performed as a server user by naming that user in the
+X-Upspin-As header.
+Failed requests return an HTTP error status and a JSON object describing the
+error, including its kind (such as "NotExist" or "Permission") and, where
+available, the Upspin path name and operation involved.
+An OpenAPI specification of the API is served at /api/v1/spec.
+The package augie.upspin.io/uiclient provides a Go client for the API.
+
+WebDAV
+
+The Upspin name space is also served over WebDAV at the path /_webdav/,
+so that it may be mounted by file managers and other applications.
+For example, with the default -http flag, the user's root is available at
+http://localhost:8000/_webdav/user@example.com/.
+WebDAV clients must authenticate using HTTP Basic authentication,
+with any user name and the request key (printed at startup) as the password.
 
 Files created by upspin-ui
 
 The signup process creates a config file at the location provided by the
 -config flag. The flag's default value is $HOME/upspin/config.
-Signup also generates key files and puts them in the directory
-$HOME/.ssh/$USER, where $USER is the Upspin user name.
+Signup also generates key files, or recovers them from a secret seed, and
+puts them in the directory $HOME/.ssh/$USER, where $USER is the Upspin user
+name.
 
 The upspinserver deployment process records its state in a file with the same
 name as the config file with the additional suffix ".gcpState".
 This state file is used to resume the deployment process should the upspin-ui
 program crash or be terminated by the user.
+It holds the private keys of GCP service accounts, so it is readable only by
+its owner and is encrypted with a key derived from the Upspin keys of the user
+in the config file. A plaintext state file written by an earlier version of
+upspin-ui is encrypted when it is next read.
 Once deployment is complete this file may be removed.
 Deployment also generates key files which it puts in $HOME/.ssh/$USER,
 where $USER is the Upspin user name of the server being deployed.
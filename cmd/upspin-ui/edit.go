@@ -0,0 +1,62 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"unicode/utf8"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// maxTextSize is the largest file that may be viewed or edited as text.
+const maxTextSize = 1 << 20
+
+// getText returns the contents of the named file and its sequence number,
+// which should be passed to putText when saving an edited version.
+// It returns an error if the file is too large or is not valid UTF-8.
+func (s *server) getText(name upspin.PathName) (string, int64, error) {
+	// Look up the entry before reading the file, so that if the file
+	// changes between the two calls the returned sequence number is
+	// stale and a subsequent putText will fail rather than clobber it.
+	_, cli := s.client()
+	de, err := cli.Lookup(name, true)
+	if err != nil {
+		return "", 0, err
+	}
+	if de.IsDir() {
+		return "", 0, errors.E(name, errors.IsDir)
+	}
+	size, err := de.Size()
+	if err != nil {
+		return "", 0, err
+	}
+	if size > maxTextSize {
+		return "", 0, errors.E(name, errors.Invalid, errors.Errorf("file is too large to edit (%d bytes)", size))
+	}
+	b, err := cli.Get(de.Name)
+	if err != nil {
+		return "", 0, err
+	}
+	if !utf8.Valid(b) {
+		return "", 0, errors.E(name, errors.Invalid, "not a text file")
+	}
+	return string(b), de.Sequence, nil
+}
+
+// putText writes data to the named file if and only if the file's sequence
+// number is seq. To create a new file, seq should be upspin.SeqNotExist.
+// It returns the sequence number of the newly written file.
+func (s *server) putText(name upspin.PathName, seq int64, data string) (int64, error) {
+	if len(data) > maxTextSize {
+		return 0, errors.E(name, errors.Invalid, "file is too large to save")
+	}
+	_, cli := s.client()
+	de, err := cli.PutSequenced(name, seq, []byte(data))
+	if err != nil {
+		return 0, err
+	}
+	return de.Sequence, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"upspin.io/bind"
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// readOnlyError is the error reported for requests that would modify the
+// tree when the current config is read-only.
+var readOnlyError = errors.Str("read-only mode: the config names no directory or store server")
+
+// readOnly reports whether the current config is read-only; see isReadOnly.
+func (s *server) readOnly() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg != nil && isReadOnly(s.cfg)
+}
+
+// endpointsRequest names the directory and store servers to use.
+type endpointsRequest struct {
+	DirServer   string
+	StoreServer string
+}
+
+// setEndpoints gives the current user the named directory and store servers,
+// as the "specifyEndpoints" startup action does: it checks that the store
+// server is up, updates the user's key server records, and sets the servers
+// in the active profile's config file.
+//
+// A read-only user has no tree, so their root is created on the new
+// directory server. Otherwise the user is moving their tree, and the new
+// directory server must already hold their root; nothing is changed if it
+// does not.
+func (s *server) setEndpoints(req endpointsRequest) (*configFile, error) {
+	oldCfg, _ := s.client()
+
+	dirEndpoint, err := s.hostEndpoint(req.DirServer)
+	if err != nil {
+		return nil, errors.E(errors.Invalid, errors.Errorf("invalid hostname %q: %v", req.DirServer, err))
+	}
+	storeEndpoint, err := s.hostEndpoint(req.StoreServer)
+	if err != nil {
+		return nil, errors.E(errors.Invalid, errors.Errorf("invalid hostname %q: %v", req.StoreServer, err))
+	}
+	cfg := config.SetDirEndpoint(oldCfg, dirEndpoint)
+	cfg = config.SetStoreEndpoint(cfg, storeEndpoint)
+
+	if err := checkStoreServer(cfg, req.StoreServer); err != nil {
+		return nil, err
+	}
+	if isReadOnly(oldCfg) {
+		if err := makeRoot(cfg); err != nil {
+			return nil, err
+		}
+	} else if err := checkRoot(cfg); err != nil {
+		return nil, err
+	}
+
+	// Read the config file before changing anything,
+	// so that a problem with it leaves everything as it was.
+	cf, err := s.getConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Update the key server records before the config file,
+	// so that if either fails the change can be undone.
+	if err := putUser(cfg, nil); err != nil {
+		return nil, errors.Errorf("error updating key server:\n%v", err)
+	}
+	if err := putSnapshotUser(cfg); err != nil {
+		restoreEndpoints(oldCfg, false)
+		return nil, errors.Errorf("error updating key server for snapshot user:\n%v", err)
+	}
+
+	// Set the servers in the config file, keeping its other settings.
+	fields := cf.Fields
+	fields.DirServer = dirEndpoint.String()
+	fields.StoreServer = storeEndpoint.String()
+	cf, err = s.putConfig(fields, cf.Checksum)
+	if err != nil {
+		restoreEndpoints(oldCfg, true)
+		return nil, err
+	}
+	logf("endpoints: %s now uses directory server %s and store server %s", cfg.UserName(), dirEndpoint, storeEndpoint)
+	return cf, nil
+}
+
+// restoreEndpoints points the key server records of the user in oldCfg,
+// and if snapshot is set of their snapshot user, back at the servers in
+// oldCfg. Errors are logged, as the caller is already reporting one.
+func restoreEndpoints(oldCfg upspin.Config, snapshot bool) {
+	if err := putUser(oldCfg, nil); err != nil {
+		logf("endpoints: restoring key server record: %v", err)
+	}
+	if !snapshot {
+		return
+	}
+	if err := putSnapshotUser(oldCfg); err != nil {
+		logf("endpoints: restoring key server record of snapshot user: %v", err)
+	}
+}
+
+// checkRoot checks that the directory server in cfg holds the root of the
+// user in cfg.
+func checkRoot(cfg upspin.Config) error {
+	addr := cfg.DirEndpoint().NetAddr
+	root := upspin.PathName(cfg.UserName() + "/")
+	dir, err := bind.DirServer(cfg, cfg.DirEndpoint())
+	if err != nil {
+		return errors.Errorf("could not find %q:\n%v", addr, err)
+	}
+	de, err := dir.Lookup(root)
+	if errors.Match(errors.E(errors.NotExist), err) {
+		return errors.E(root, errors.NotExist, errors.Errorf("%q does not hold your root; copy your tree there before moving to it", addr))
+	}
+	if err != nil {
+		return errors.Errorf("error communicating with %q:\n%v", addr, err)
+	}
+	if !de.IsDir() {
+		return errors.E(root, errors.NotDir, errors.Errorf("%q holds a root that is not a directory", addr))
+	}
+	return nil
+}
@@ -33,9 +33,18 @@ import (
 	storage "google.golang.org/api/storage/v1"
 )
 
+// gcpAPIBase is the base URL of the Google Cloud APIs. It is set by tests to
+// that of a fake; if empty, Google's own servers are used.
+var gcpAPIBase string
+
 // gcpState represents the state of a GCP deployment. As the process proceeds,
 // the fields are populated with nonzero values from top to bottom.
 type gcpState struct {
+	// BaseURL, if non-empty, is the base URL of a server that stands in
+	// for the Google Cloud APIs, such as that provided by package
+	// augie.upspin.io/fakegcp. It is taken from gcpAPIBase.
+	BaseURL string `json:",omitempty"`
+
 	JWTConfig *jwt.Config
 	ProjectID string
 
@@ -58,6 +67,8 @@ type gcpState struct {
 		KeyDir   string
 		UserName upspin.UserName
 
+		SeedConfirmed bool // The user typed back words of the server user's seed.
+
 		HostName string
 
 		Configured bool
@@ -84,30 +95,45 @@ func (s *gcpState) storeConfig() []string {
 	}
 }
 
-// gcpStateFromFile loads the JSON-encoded GCP deployment state file from
-// flags.Config+".gcpstate".
+// gcpStateFromFile loads the GCP deployment state file, which is encrypted as
+// described in gcpstate.go. A plaintext state file written by an earlier
+// version of upspin-ui is encrypted in place.
 func gcpStateFromFile() (*gcpState, error) {
-	name := flags.Config + ".gcpState"
+	name := gcpStateFile()
 	b, err := ioutil.ReadFile(name)
 	if err != nil {
 		return nil, err
 	}
+	plaintext := !isSealedGCPState(b)
+	if !plaintext {
+		if b, err = openGCPState(b); err != nil {
+			return nil, err
+		}
+	}
 	var s gcpState
 	if err := json.Unmarshal(b, &s); err != nil {
 		return nil, err
 	}
+	if plaintext {
+		if err := s.save(); err != nil {
+			return nil, fmt.Errorf("encrypting %s: %v", name, err)
+		}
+		logf("gcp: encrypted plaintext deployment state in %s", name)
+	}
 	return &s, nil
 }
 
-// save writes the JSON-encoded GCP deployment state to
-// flags.Config+".gcpstate".
+// save encrypts the JSON-encoded GCP deployment state and writes it to the
+// state file, readable only by its owner.
 func (s *gcpState) save() error {
-	name := flags.Config + ".gcpState"
 	b, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(name, b, 0644)
+	if b, err = sealGCPState(b); err != nil {
+		return err
+	}
+	return writeFileAtomic(gcpStateFile(), b, 0600, false)
 }
 
 // gcpStateFromPrivateKeyJSON instantiates a new gcpState from the given
@@ -122,9 +148,13 @@ func gcpStateFromPrivateKeyJSON(b []byte) (*gcpState, error) {
 		return nil, err
 	}
 	s := &gcpState{
+		BaseURL:   strings.TrimSuffix(gcpAPIBase, "/"),
 		JWTConfig: cfg,
 		ProjectID: projectID,
 	}
+	if s.BaseURL != "" {
+		cfg.TokenURL = s.BaseURL + "/token"
+	}
 	if !s.APIsEnabled {
 		if err := s.enableAPIs(); err != nil {
 			return nil, err
@@ -151,11 +181,51 @@ func serviceAccountEmailToProjectID(email string) (string, error) {
 	return email[i+1 : len(email)-len(domain)], nil
 }
 
+// httpClient returns an HTTP client that authenticates as the service account.
+func (s *gcpState) httpClient() *http.Client {
+	return s.JWTConfig.Client(context.Background())
+}
+
+// computeService returns a Compute API client.
+func (s *gcpState) computeService() (*compute.Service, error) {
+	svc, err := compute.New(s.httpClient())
+	if err == nil && s.BaseURL != "" {
+		svc.BasePath = s.BaseURL + "/compute/v1/projects/"
+	}
+	return svc, err
+}
+
+// iamService returns an IAM API client.
+func (s *gcpState) iamService() (*iam.Service, error) {
+	svc, err := iam.New(s.httpClient())
+	if err == nil && s.BaseURL != "" {
+		svc.BasePath = s.BaseURL + "/iam/"
+	}
+	return svc, err
+}
+
+// serviceManagementService returns a Service Management API client.
+func (s *gcpState) serviceManagementService() (*servicemanagement.APIService, error) {
+	svc, err := servicemanagement.New(s.httpClient())
+	if err == nil && s.BaseURL != "" {
+		svc.BasePath = s.BaseURL + "/servicemanagement/"
+	}
+	return svc, err
+}
+
+// storageService returns a Storage API client.
+func (s *gcpState) storageService() (*storage.Service, error) {
+	svc, err := storage.New(s.httpClient())
+	if err == nil && s.BaseURL != "" {
+		svc.BasePath = s.BaseURL + "/storage/v1/"
+	}
+	return svc, err
+}
+
 // enableAPIs enables the Compute, Storage, and IAM APIs required to deploy
 // upspinserver to GCP.
 func (s *gcpState) enableAPIs() error {
-	client := s.JWTConfig.Client(context.Background())
-	svc, err := servicemanagement.New(client)
+	svc, err := s.serviceManagementService()
 	if err != nil {
 		return err
 	}
@@ -192,8 +262,7 @@ func (s *gcpState) enableAPI(name string, svc *servicemanagement.APIService) err
 }
 
 func (s *gcpState) listZones() ([]string, error) {
-	client := s.JWTConfig.Client(context.Background())
-	svc, err := compute.New(client)
+	svc, err := s.computeService()
 	if err != nil {
 		return nil, err
 	}
@@ -294,8 +363,7 @@ func (s *gcpState) create(region, zone, bucketName, bucketLoc string) error {
 
 // createAddress reserves a static IP address with the name "upspinserver".
 func (s *gcpState) createAddress() (ip string, err error) {
-	client := s.JWTConfig.Client(context.Background())
-	svc, err := compute.New(client)
+	svc, err := s.computeService()
 	if err != nil {
 		return "", err
 	}
@@ -321,8 +389,7 @@ func (s *gcpState) createAddress() (ip string, err error) {
 // that instance. If a firewall rule of the name "allow-http-https" exists it
 // is re-used.
 func (s *gcpState) createInstance() error {
-	client := s.JWTConfig.Client(context.Background())
-	svc, err := compute.New(client)
+	svc, err := s.computeService()
 	if err != nil {
 		return err
 	}
@@ -385,8 +452,7 @@ func (s *gcpState) createInstance() error {
 // createServiceAccount creates a service account named "upspinstorage" and
 // generates a JSON Private Key for authenticating as that account.
 func (s *gcpState) createServiceAccount() (email, privateKeyData string, err error) {
-	client := s.JWTConfig.Client(context.Background())
-	svc, err := iam.New(client)
+	svc, err := s.iamService()
 	if err != nil {
 		return "", "", err
 	}
@@ -421,8 +487,7 @@ func (s *gcpState) createServiceAccount() (email, privateKeyData string, err err
 // createBucket creates the named Storage bucket, giving "owner" access to
 // Storage.ServiceAccount in gcpState.
 func (s *gcpState) createBucket(name, loc string) error {
-	client := s.JWTConfig.Client(context.Background())
-	svc, err := storage.New(client)
+	svc, err := s.storageService()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,197 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"augie.upspin.io/fakegcp"
+
+	"upspin.io/errors"
+	"upspin.io/flags"
+)
+
+// TestGCPDeploy drives the GCP deployment process against a fake Google
+// Cloud API server, checking that a deployment interrupted by a failure is
+// resumed from the saved state without repeating completed steps, and that
+// the saved state is encrypted.
+func TestGCPDeploy(t *testing.T) {
+	fake, err := fakegcp.NewServer("test-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	dir, restore := tempHome(t)
+	defer restore()
+	defer func() { gcpAPIBase = "" }()
+	gcpAPIBase = fake.URL
+
+	// The state file is written alongside the config file,
+	// and is encrypted with a key derived from the user's keys.
+	flags.Config = filepath.Join(dir, "config")
+	if err := makeProfile(flags.Config, "gcp@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		region = "us-central1"
+		zone   = "us-central1-a"
+		bucket = "test-bucket"
+	)
+	var st *gcpState
+	runSteps(t, []testStep{
+		{"enable APIs", func() error {
+			st, err = gcpStateFromPrivateKeyJSON(fake.ServiceAccountKey())
+			if err != nil {
+				return err
+			}
+			for _, api := range []string{"compute_component", "storage_api", "iam.googleapis.com"} {
+				if !fake.Enabled(api) {
+					return errors.Errorf("API %q not enabled", api)
+				}
+			}
+			return nil
+		}},
+		{"list zones", func() error {
+			zones, err := st.listZones()
+			if err != nil {
+				return err
+			}
+			want := "europe-west1/europe-west1-b europe-west1/europe-west1-c us-central1/us-central1-a us-central1/us-central1-b"
+			if got := strings.Join(zones, " "); got != want {
+				return errors.Errorf("got zones %q, want %q", got, want)
+			}
+			return nil
+		}},
+		{"create fails", func() error {
+			fake.Fail("storage.buckets.insert", http.StatusInternalServerError, "backendError")
+			if err := st.create(region, zone, bucket, "us"); err == nil {
+				return errors.Str("create succeeded, want error")
+			}
+			st, err = gcpStateFromFile()
+			if err != nil {
+				return err
+			}
+			if st.Storage.ServiceAccount == "" || st.Storage.Bucket != "" {
+				return errors.Errorf("saved state has service account %q and bucket %q, want service account only", st.Storage.ServiceAccount, st.Storage.Bucket)
+			}
+			return nil
+		}},
+		{"resume create", func() error {
+			if err := st.create(region, zone, bucket, "us"); err != nil {
+				return err
+			}
+			if n := fake.Calls("iam.projects.serviceAccounts.create"); n != 1 {
+				return errors.Errorf("service account created %d times, want 1", n)
+			}
+			if n := fake.ServiceAccountKeys(st.Storage.ServiceAccount); n != 1 {
+				return errors.Errorf("service account has %d keys, want 1", n)
+			}
+			if fake.Bucket(bucket) == nil {
+				return errors.Errorf("bucket %q not created", bucket)
+			}
+			addr := fake.Address(region, "upspinserver")
+			if addr == nil || addr.Address != st.Server.IPAddr {
+				return errors.Errorf("saved IP address %q does not match reserved address", st.Server.IPAddr)
+			}
+			if fake.Instance(zone, "upspinserver") == nil || !st.Server.Created {
+				return errors.Str("instance not created")
+			}
+			if fake.Firewall("allow-http-https") == nil {
+				return errors.Str("firewall rule not created")
+			}
+			return nil
+		}},
+		{"create with existing resources", func() error {
+			// Start afresh, as if the state file had been lost,
+			// but stop short of creating the instance again.
+			st, err = gcpStateFromPrivateKeyJSON(fake.ServiceAccountKey())
+			if err != nil {
+				return err
+			}
+			st.Server.Created = true
+			if err := st.create(region, zone, bucket, "us"); err != nil {
+				return err
+			}
+			if addr := fake.Address(region, "upspinserver"); st.Server.IPAddr != addr.Address {
+				return errors.Errorf("saved IP address %q does not match reserved address", st.Server.IPAddr)
+			}
+			return nil
+		}},
+		{"state is encrypted", func() error {
+			return expectSealedState(st)
+		}},
+		{"migrate plaintext state", func() error {
+			b, err := json.Marshal(st)
+			if err != nil {
+				return err
+			}
+			os.Remove(gcpStateFile())
+			if err := ioutil.WriteFile(gcpStateFile(), b, 0644); err != nil {
+				return err
+			}
+			got, err := gcpStateFromFile()
+			if err != nil {
+				return err
+			}
+			if got.ProjectID != st.ProjectID || got.Server.IPAddr != st.Server.IPAddr {
+				return errors.Errorf("migrated state has project %q and IP address %q, want %q and %q", got.ProjectID, got.Server.IPAddr, st.ProjectID, st.Server.IPAddr)
+			}
+			return expectSealedState(st)
+		}},
+		{"state of another user", func() error {
+			b, err := ioutil.ReadFile(gcpStateFile())
+			if err != nil {
+				return err
+			}
+			oldConfig := flags.Config
+			defer func() { flags.Config = oldConfig }()
+			flags.Config = filepath.Join(dir, "other")
+			if err := makeProfile(flags.Config, "gcpother@example.com"); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(gcpStateFile(), b, 0600); err != nil {
+				return err
+			}
+			_, err = gcpStateFromFile()
+			if !errors.Match(errors.E(errors.CannotDecrypt), err) {
+				return errors.Errorf("got error %v, want CannotDecrypt", err)
+			}
+			return nil
+		}},
+	})
+}
+
+// expectSealedState checks that the GCP deployment state file is encrypted,
+// does not reveal the private keys in st, and is readable only by its owner.
+func expectSealedState(st *gcpState) error {
+	fi, err := os.Stat(gcpStateFile())
+	if err != nil {
+		return err
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		return errors.Errorf("state file has mode %v, want 0600", perm)
+	}
+	b, err := ioutil.ReadFile(gcpStateFile())
+	if err != nil {
+		return err
+	}
+	if !isSealedGCPState(b) {
+		return errors.Str("state file is not encrypted")
+	}
+	for _, key := range []string{string(st.JWTConfig.PrivateKey), st.Storage.PrivateKeyData} {
+		if key != "" && bytes.Contains(b, []byte(key)) {
+			return errors.Str("state file holds a private key in the clear")
+		}
+	}
+	return nil
+}
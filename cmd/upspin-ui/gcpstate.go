@@ -0,0 +1,124 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/flags"
+	"upspin.io/upspin"
+)
+
+// The GCP deployment state holds the private keys of GCP service accounts,
+// so it is encrypted at rest. The state file holds gcpStateMagic, a random
+// salt, a nonce, and the JSON-encoded state sealed with AES-256-GCM. The key
+// is derived, using the salt, from the factotum of the user in flags.Config,
+// and the user name is authenticated along with the state.
+
+// gcpStateMagic begins an encrypted state file. Files without it are
+// plaintext state files written by earlier versions of upspin-ui.
+const gcpStateMagic = "upspin-ui encrypted gcpState v1\n"
+
+// gcpStateInfo distinguishes the keys derived for the state file from
+// other keys derived from the same factotum.
+const gcpStateInfo = "upspin-ui gcpState"
+
+const gcpStateSaltLen = 32
+
+// gcpStateFile returns the name of the GCP deployment state file.
+func gcpStateFile() string {
+	return flags.Config + ".gcpState"
+}
+
+// isSealedGCPState reports whether b is an encrypted state file.
+func isSealedGCPState(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(gcpStateMagic))
+}
+
+// sealGCPState encrypts the given JSON-encoded state.
+func sealGCPState(state []byte) ([]byte, error) {
+	cfg, err := config.FromFile(flags.Config)
+	if err != nil {
+		return nil, err
+	}
+	f := cfg.Factotum()
+	if f == nil {
+		return nil, noGCPStateKeys(cfg.UserName())
+	}
+	salt := make([]byte, gcpStateSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := gcpStateCipher(f, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	b := append([]byte(gcpStateMagic), salt...)
+	b = append(b, nonce...)
+	return aead.Seal(b, nonce, state, []byte(cfg.UserName())), nil
+}
+
+// openGCPState decrypts the given encrypted state file. If the current key
+// of the user in flags.Config cannot decrypt it, as after a key rotation,
+// the previous key is tried.
+func openGCPState(b []byte) ([]byte, error) {
+	cfg, err := config.FromFile(flags.Config)
+	if err != nil {
+		return nil, err
+	}
+	b = b[len(gcpStateMagic):]
+	if len(b) < gcpStateSaltLen {
+		return nil, errors.E(errors.Invalid, errors.Errorf("%s is truncated", gcpStateFile()))
+	}
+	salt, b := b[:gcpStateSaltLen], b[gcpStateSaltLen:]
+	f := cfg.Factotum()
+	if f == nil {
+		return nil, noGCPStateKeys(cfg.UserName())
+	}
+	for _, f := range []upspin.Factotum{f, f.Pop()} {
+		aead, err := gcpStateCipher(f, salt)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < aead.NonceSize() {
+			return nil, errors.E(errors.Invalid, errors.Errorf("%s is truncated", gcpStateFile()))
+		}
+		nonce, sealed := b[:aead.NonceSize()], b[aead.NonceSize():]
+		state, err := aead.Open(nil, nonce, sealed, []byte(cfg.UserName()))
+		if err == nil {
+			return state, nil
+		}
+	}
+	return nil, errors.E(cfg.UserName(), errors.CannotDecrypt, errors.Errorf("cannot decrypt %s with the keys of %s", gcpStateFile(), cfg.UserName()))
+}
+
+// noGCPStateKeys returns the error reported when the given user has no
+// keys from which to derive the key of the state file.
+func noGCPStateKeys(user upspin.UserName) error {
+	return errors.E(user, errors.NotExist, errors.Str("config has no keys with which to encrypt the GCP deployment state"))
+}
+
+// gcpStateCipher returns the AEAD that seals the state file with the key
+// derived from the given factotum and salt.
+func gcpStateCipher(f upspin.Factotum, salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if err := f.HKDF(salt, []byte(gcpStateInfo), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
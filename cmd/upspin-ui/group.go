@@ -0,0 +1,268 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"upspin.io/access"
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// groupDir returns the name of the Group directory of the user in cfg.
+func groupDir(cfg upspin.Config) upspin.PathName {
+	return upspin.PathName(cfg.UserName()) + "/" + access.GroupDir
+}
+
+// groups returns the names of the Group files beneath the current user's
+// Group directory, including those in its sub-directories.
+func (s *server) groups() ([]upspin.PathName, error) {
+	cfg, cli := s.client()
+	var names []upspin.PathName
+	dir := groupDir(cfg)
+	_, err := cli.Lookup(dir, true)
+	if errors.Match(errors.E(errors.NotExist), err) {
+		// No Group directory means no groups.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := groupsIn(cli, dir, &names); err != nil {
+		return nil, err
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names, nil
+}
+
+// groupsIn appends the names of the Group files in dir and its
+// sub-directories to names, using the given client.
+func groupsIn(cli upspin.Client, dir upspin.PathName, names *[]upspin.PathName) error {
+	des, err := cli.Glob(upspin.AllFilesGlob(dir))
+	if err != nil {
+		return err
+	}
+	for _, de := range des {
+		switch {
+		case de.IsDir():
+			if err := groupsIn(cli, de.Name, names); err != nil {
+				return err
+			}
+		case de.IsLink():
+			// Don't follow links out of the Group directory.
+		default:
+			*names = append(*names, de.Name)
+		}
+	}
+	return nil
+}
+
+// groupMembers returns the members of the named Group file as written in the
+// file, and the set of users that the group expands to once any nested groups
+// have been resolved. Nested groups that cannot be read are reported in
+// unreadable, and the users they hold are missing from users.
+func (s *server) groupMembers(name upspin.PathName) (members []string, users []upspin.UserName, unreadable []unreadableGroup, err error) {
+	if !access.IsGroupFile(name) {
+		return nil, nil, nil, errors.E(name, errors.Invalid, "not a Group file")
+	}
+	_, cli := s.client()
+	data, err := cli.Get(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	members = groupTokens(data)
+
+	g := &groupExpansion{
+		cli:   cli,
+		seen:  map[upspin.PathName]bool{},
+		users: map[upspin.UserName]bool{},
+	}
+	if err := g.expand(name, data); err != nil {
+		return nil, nil, nil, err
+	}
+	for u := range g.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	return members, users, g.unreadable, nil
+}
+
+// unreadableGroup describes a nested group that could not be expanded.
+type unreadableGroup struct {
+	Group upspin.PathName
+	Error string
+}
+
+// groupExpansion holds the state of the expansion of a Group file into
+// the set of users it contains.
+type groupExpansion struct {
+	cli upspin.Client
+
+	// seen records the groups already visited,
+	// so that cyclic definitions terminate.
+	seen map[upspin.PathName]bool
+
+	users      map[upspin.UserName]bool
+	unreadable []unreadableGroup
+}
+
+// expand adds the users in the given Group file contents to g.users,
+// recursively loading any groups it refers to. Nested groups that cannot
+// be read or parsed are recorded in g.unreadable and otherwise skipped.
+func (g *groupExpansion) expand(name upspin.PathName, data []byte) error {
+	g.seen[name] = true
+	parsed, err := path.Parse(name)
+	if err != nil {
+		return err
+	}
+	elems, err := access.ParseGroup(parsed, data)
+	if err != nil {
+		return err
+	}
+	for _, p := range elems {
+		if p.IsRoot() {
+			// A user name (or wildcard) rather than a group.
+			g.users[p.User()] = true
+			continue
+		}
+		group := p.Path()
+		if g.seen[group] {
+			continue
+		}
+		b, err := g.cli.Get(group)
+		if err == nil {
+			err = g.expand(group, b)
+		}
+		if err != nil {
+			g.seen[group] = true
+			g.unreadable = append(g.unreadable, unreadableGroup{Group: group, Error: err.Error()})
+		}
+	}
+	return nil
+}
+
+// groupAdd adds the given members to the named Group file,
+// creating the file if it does not exist.
+// Members already present in the file are not added again.
+func (s *server) groupAdd(name upspin.PathName, members []string) error {
+	if !access.IsGroupFile(name) {
+		return errors.E(name, errors.Invalid, "not a Group file")
+	}
+	_, cli := s.client()
+	data, err := cli.Get(name)
+	if err != nil && !errors.Match(errors.E(errors.NotExist), err) {
+		return err
+	}
+	have := map[string]bool{}
+	for _, m := range groupTokens(data) {
+		have[m] = true
+	}
+	var buf bytes.Buffer
+	buf.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	for _, m := range members {
+		m = strings.TrimSpace(m)
+		if m == "" || have[m] {
+			continue
+		}
+		have[m] = true
+		buf.WriteString(m)
+		buf.WriteByte('\n')
+	}
+	return putGroup(cli, name, buf.Bytes())
+}
+
+// groupRemove removes the given members from the named Group file.
+// Comments and the layout of the remaining members are preserved.
+func (s *server) groupRemove(name upspin.PathName, members []string) error {
+	if !access.IsGroupFile(name) {
+		return errors.E(name, errors.Invalid, "not a Group file")
+	}
+	_, cli := s.client()
+	data, err := cli.Get(name)
+	if err != nil {
+		return err
+	}
+	remove := map[string]bool{}
+	for _, m := range members {
+		remove[strings.TrimSpace(m)] = true
+	}
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		text, comment := line, ""
+		if i := strings.Index(line, "#"); i >= 0 {
+			text, comment = line[:i], strings.TrimSpace(line[i:])
+		}
+		var kept []string
+		removed := false
+		for _, m := range splitGroupLine(text) {
+			if remove[m] {
+				removed = true
+				continue
+			}
+			kept = append(kept, m)
+		}
+		if !removed {
+			buf.WriteString(line)
+			continue
+		}
+		out := strings.Join(kept, ", ")
+		if comment != "" {
+			if out != "" {
+				out += " "
+			}
+			out += comment
+		}
+		if out != "" {
+			buf.WriteString(out)
+			buf.WriteByte('\n')
+		}
+	}
+	return putGroup(cli, name, buf.Bytes())
+}
+
+// putGroup checks that data is a valid Group file and writes it to name
+// using the given client.
+func putGroup(cli upspin.Client, name upspin.PathName, data []byte) error {
+	parsed, err := path.Parse(name)
+	if err != nil {
+		return err
+	}
+	if _, err := access.ParseGroup(parsed, data); err != nil {
+		return err
+	}
+	_, err = cli.Put(name, data)
+	return err
+}
+
+// groupTokens returns the members listed in the given Group file contents,
+// in the order they appear and without comments.
+func groupTokens(data []byte) []string {
+	var members []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		members = append(members, splitGroupLine(line)...)
+	}
+	return members
+}
+
+// splitGroupLine splits a line of a Group file into its members,
+// which may be separated by commas or white space.
+func splitGroupLine(line string) []string {
+	return strings.FieldsFunc(line, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+}
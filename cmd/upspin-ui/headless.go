@@ -0,0 +1,424 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"upspin.io/errors"
+	"upspin.io/flags"
+	"upspin.io/upspin"
+)
+
+// runHeadless runs the headless command named by args[0], with the
+// remaining arguments as its flags.
+func runHeadless(args []string) error {
+	if len(args) == 0 {
+		return errors.Str("usage: upspin-ui -headless <command> [arguments]\ncommands: signup, deploy")
+	}
+	switch args[0] {
+	case "signup":
+		return headlessSignup(args[1:], os.Stdin, os.Stdout)
+	case "deploy":
+		return headlessDeploy(args[1:], os.Stdout)
+	}
+	return errors.Errorf("unknown headless command %q", args[0])
+}
+
+// answerFunc returns the request that answers the given step of the startup
+// process. If the previous answer to the step failed, err is that failure.
+// If answerFunc returns an error the startup process is abandoned.
+type answerFunc func(resp *startupResponse, err error) (*startupRequest, error)
+
+// runStartup drives the startup process to completion, obtaining the answer
+// to each step from answer, and returns the resulting config.
+func runStartup(s *server, answer answerFunc) (upspin.Config, error) {
+	req := &startupRequest{}
+	var last *startupResponse
+	for {
+		resp, cfg, err := s.startup(req)
+		if cfg != nil {
+			return cfg, nil
+		}
+		if err != nil {
+			if last == nil {
+				// Nothing to answer; we can't make progress.
+				return nil, err
+			}
+			resp = last
+		}
+		req, err = answer(resp, err)
+		if err != nil {
+			return nil, err
+		}
+		last = resp
+	}
+}
+
+// prompter answers the steps of the startup process by prompting the user
+// in the terminal. Answers may be supplied in advance by flags, each of
+// which is used only once so that a rejected value is then prompted for.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	user        string
+	secretSeed  string
+	dirServer   string
+	storeServer string
+	noServers   bool
+	gcpKeyFile  string
+	bucket      string
+	bucketLoc   string
+	zone        string
+	suffix      string
+	hostName    string
+	writers     string
+}
+
+// headlessSignup runs the signup process in the terminal.
+func headlessSignup(args []string, in io.Reader, out io.Writer) error {
+	p := &prompter{in: bufio.NewReader(in), out: out}
+	fs := flag.NewFlagSet("signup", flag.ContinueOnError)
+	fs.StringVar(&p.user, "user", "", "Upspin user `name` to sign up")
+	seedFile := fs.String("secretseedfile", "", "recover the keys of an existing user from the secret seed in this `file` (- for standard input)")
+	fs.StringVar(&p.dirServer, "dirserver", "", "directory server `host` name")
+	fs.StringVar(&p.storeServer, "storeserver", "", "store server `host` name (default is -dirserver)")
+	fs.BoolVar(&p.noServers, "noservers", false, "do not use a directory or store server")
+	fs.StringVar(&p.gcpKeyFile, "gcpkey", "", "deploy an upspinserver to Google Cloud Platform using this JSON private key `file`")
+	fs.StringVar(&p.bucket, "bucket", "", "GCP storage bucket `name`")
+	fs.StringVar(&p.bucketLoc, "bucketloc", "", "GCP storage bucket `location`")
+	fs.StringVar(&p.zone, "zone", "", "GCP `region/zone` for the upspinserver")
+	fs.StringVar(&p.suffix, "serversuffix", "", "user name `suffix` for the upspinserver user")
+	fs.StringVar(&p.hostName, "hostname", "", "host `name` for the upspinserver")
+	fs.StringVar(&p.writers, "writers", "", "space-separated list of `users` that may write to the upspinserver")
+	fs.SetOutput(out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if *seedFile != "" {
+		// The seed is not accepted as a flag, which would expose it
+		// to other users of the machine in the process list.
+		seed, err := p.readSeed(*seedFile)
+		if err != nil {
+			return err
+		}
+		p.secretSeed = seed
+	}
+
+	s, err := newServer()
+	if err != nil {
+		return err
+	}
+	cfg, err := runStartup(s, p.answer)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "\nSetup is complete. You are %s.\n", cfg.UserName())
+	fmt.Fprintf(p.out, "Your config file is %s.\n", flags.Config)
+	return nil
+}
+
+// take returns the value of the given flag and clears it,
+// so that it is used only once.
+func take(v *string) string {
+	s := *v
+	*v = ""
+	return s
+}
+
+// readSeed returns the secret seed held in the first line of the named
+// file, or of the prompter's input if the name is "-".
+func (p *prompter) readSeed(file string) (string, error) {
+	var line string
+	if file == "-" {
+		var err error
+		line, err = p.in.ReadString('\n')
+		if err != nil && !(err == io.EOF && line != "") {
+			return "", errors.E(errors.IO, errors.Errorf("reading secret seed: %v", err))
+		}
+	} else {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.E(errors.IO, err)
+		}
+		line = strings.SplitN(string(b), "\n", 2)[0]
+	}
+	seed := strings.TrimSpace(line)
+	if seed == "" {
+		return "", errors.E(errors.Invalid, errors.Errorf("no secret seed in %s", file))
+	}
+	return seed, nil
+}
+
+// prompt prints the question, with the default answer def (if non-empty),
+// and returns the line the user enters, or def if the line is empty.
+func (p *prompter) prompt(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", errors.E(errors.IO, errors.Errorf("reading answer to %q: %v", question, err))
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = def
+	}
+	return line, nil
+}
+
+// choose prompts the user to choose one of the given options, and returns
+// the index of the chosen option.
+func (p *prompter) choose(question string, options []string) (int, error) {
+	fmt.Fprintf(p.out, "%s\n", question)
+	for i, o := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, o)
+	}
+	for {
+		a, err := p.prompt("Choice", "")
+		if err != nil {
+			return 0, err
+		}
+		var n int
+		if _, err := fmt.Sscan(a, &n); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		fmt.Fprintf(p.out, "Please enter a number between 1 and %d.\n", len(options))
+	}
+}
+
+func (p *prompter) answer(resp *startupResponse, err error) (*startupRequest, error) {
+	if err != nil {
+		fmt.Fprintf(p.out, "\nError: %v\n\n", err)
+	}
+	switch resp.Step {
+	case "signup":
+		name, seed := take(&p.user), take(&p.secretSeed)
+		recover := seed != ""
+		if name == "" && seed == "" {
+			n, err := p.choose("\nDo you already have an Upspin user name?", []string{
+				"No, sign up a new user",
+				"Yes, recover my keys and config from my secret seed",
+			})
+			if err != nil {
+				return nil, err
+			}
+			recover = n == 1
+		}
+		if !recover {
+			fmt.Fprintln(p.out, "You need an Upspin user name, which is an email address you control.")
+		}
+		if name == "" {
+			if name, err = p.prompt("User name", ""); err != nil {
+				return nil, err
+			}
+		}
+		if !recover {
+			return &startupRequest{Action: "signup", UserName: upspin.UserName(name)}, nil
+		}
+		if seed == "" {
+			if seed, err = p.prompt("Secret seed", ""); err != nil {
+				return nil, err
+			}
+		}
+		return &startupRequest{Action: "recover", UserName: upspin.UserName(name), SecretSeed: seed}, nil
+
+	case "secretSeed", "serverSecretSeed":
+		fmt.Fprintf(p.out, "\nKeys have been written to %s.\n", resp.KeyDir)
+		fmt.Fprintf(p.out, "Your secret seed is:\n\n\t%s\n\n", resp.SecretSeed)
+		fmt.Fprintln(p.out, "Write it down and keep it safe; it can be used to recover the keys if they are lost.")
+		if _, err := p.prompt("Press Enter to continue", ""); err != nil {
+			return nil, err
+		}
+		return &startupRequest{}, nil
+
+	case "confirmSeed", "confirmServerSeed":
+		fmt.Fprintln(p.out, "\nTo check that you wrote down the secret seed, type back some of its words.")
+		var words []string
+		for _, pos := range resp.SeedWords {
+			a, err := p.prompt(fmt.Sprintf(`Word %d, or "show" to see the seed again`, pos), "")
+			if err != nil {
+				return nil, err
+			}
+			if a == "show" {
+				return &startupRequest{Action: "showSeed"}, nil
+			}
+			words = append(words, a)
+		}
+		return &startupRequest{Action: "confirmSeed", SeedWords: words}, nil
+
+	case "verify":
+		fmt.Fprintf(p.out, "\nThe key server has sent an email to %s.\n", resp.UserName)
+		fmt.Fprintln(p.out, "Click the link in that email to complete your registration.")
+		a, err := p.prompt(`Press Enter once you have done so, type "resend" to send another email, or "show" to see your secret seed again`, "")
+		if err != nil {
+			return nil, err
+		}
+		switch a {
+		case "resend":
+			return &startupRequest{Action: "register"}, nil
+		case "show":
+			return &startupRequest{Action: "showSeed"}, nil
+		}
+		return &startupRequest{}, nil
+
+	case "serverSelect":
+		return p.serverSelect()
+
+	case "gcpDetails":
+		bucket := take(&p.bucket)
+		if bucket == "" {
+			if bucket, err = p.prompt("Storage bucket name", resp.BucketName); err != nil {
+				return nil, err
+			}
+		}
+		loc := take(&p.bucketLoc)
+		if loc == "" {
+			fmt.Fprintf(p.out, "Storage locations: %s\n", strings.Join(resp.Locations, " "))
+			if loc, err = p.prompt("Storage bucket location", "us"); err != nil {
+				return nil, err
+			}
+		}
+		zone := take(&p.zone)
+		if zone == "" {
+			def := ""
+			for _, z := range resp.Zones {
+				if def == "" || z == "us-central1/us-central1-c" {
+					def = z
+				}
+			}
+			fmt.Fprintf(p.out, "Zones: %s\n", strings.Join(resp.Zones, " "))
+			if zone, err = p.prompt("Region/zone for the server", def); err != nil {
+				return nil, err
+			}
+		}
+		return &startupRequest{
+			Action:     "createGCP",
+			BucketName: bucket,
+			BucketLoc:  loc,
+			RegionZone: zone,
+		}, nil
+
+	case "serverUserName":
+		suffix := take(&p.suffix)
+		if suffix == "" {
+			fmt.Fprintf(p.out, "The server runs as the user %s<suffix>%s.\n", resp.UserNamePrefix, resp.UserNameDomain)
+			if suffix, err = p.prompt("Suffix", resp.UserNameSuffix); err != nil {
+				return nil, err
+			}
+		}
+		return &startupRequest{Action: "configureServerUserName", UserNameSuffix: suffix}, nil
+
+	case "serverHostName":
+		host := take(&p.hostName)
+		if host == "" {
+			fmt.Fprintf(p.out, "The server's IP address is %s.\n", resp.IPAddr)
+			fmt.Fprintln(p.out, "Enter a host name that resolves to that address, or leave it empty to be assigned a name under upspin.services.")
+			if host, err = p.prompt("Host name", ""); err != nil {
+				return nil, err
+			}
+		}
+		return &startupRequest{Action: "configureServerHostName", HostName: host}, nil
+
+	case "waitServerHostName":
+		fmt.Fprintf(p.out, "\nWaiting for %s to resolve to %s, which may take a few minutes.\n", resp.HostName, resp.IPAddr)
+		a, err := p.prompt(`Press Enter to check, or type "reset" to choose another host name`, "")
+		if err != nil {
+			return nil, err
+		}
+		return &startupRequest{Action: "checkServerHostName", Reset: a == "reset"}, nil
+
+	case "serverWriters":
+		writers := take(&p.writers)
+		if writers == "" {
+			var def []string
+			for _, w := range resp.Writers {
+				def = append(def, string(w))
+			}
+			fmt.Fprintln(p.out, "Enter the users that may write to the server, separated by spaces.")
+			if writers, err = p.prompt("Writers", strings.Join(def, " ")); err != nil {
+				return nil, err
+			}
+		}
+		return &startupRequest{Action: "configureServer", Writers: writers}, nil
+	}
+	return nil, errors.Errorf("unknown startup step %q", resp.Step)
+}
+
+// serverSelect answers the serverSelect step, in which the user chooses
+// existing servers, a new GCP deployment, or no servers.
+func (p *prompter) serverSelect() (*startupRequest, error) {
+	dir, store, keyFile := take(&p.dirServer), take(&p.storeServer), take(&p.gcpKeyFile)
+	switch {
+	case dir != "":
+		if store == "" {
+			store = dir
+		}
+		return &startupRequest{Action: "specifyEndpoints", DirServer: dir, StoreServer: store}, nil
+	case keyFile != "":
+		return p.specifyGCP(keyFile)
+	case p.noServers:
+		p.noServers = false
+		return &startupRequest{Action: "specifyNoEndpoints"}, nil
+	}
+
+	n, err := p.choose("\nWhich Upspin servers will you use?", []string{
+		"Existing directory and store servers",
+		"Deploy a new upspinserver to Google Cloud Platform",
+		"None (read-only access to others' files)",
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch n {
+	case 0:
+		if dir, err = p.prompt("Directory server host name", ""); err != nil {
+			return nil, err
+		}
+		if store, err = p.prompt("Store server host name", dir); err != nil {
+			return nil, err
+		}
+		return &startupRequest{Action: "specifyEndpoints", DirServer: dir, StoreServer: store}, nil
+	case 1:
+		fmt.Fprintln(p.out, "Create a Google Cloud project and a service account with the Owner role,")
+		fmt.Fprintln(p.out, "and download a JSON private key for that account.")
+		for {
+			if keyFile, err = p.prompt("JSON private key file", ""); err != nil {
+				return nil, err
+			}
+			req, err := p.specifyGCP(keyFile)
+			if err == nil {
+				return req, nil
+			}
+			fmt.Fprintf(p.out, "Error: %v\n", err)
+		}
+	}
+	return &startupRequest{Action: "specifyNoEndpoints"}, nil
+}
+
+// specifyGCP returns a request to begin a GCP deployment using the JSON
+// private key in the named file.
+func (p *prompter) specifyGCP(keyFile string) (*startupRequest, error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &startupRequest{Action: "specifyGCP", PrivateKeyData: string(b)}, nil
+}
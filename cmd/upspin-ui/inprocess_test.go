@@ -0,0 +1,100 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+
+	"upspin.io/bind"
+	"upspin.io/client"
+	"upspin.io/config"
+	"upspin.io/factotum"
+	"upspin.io/key/keygen"
+	"upspin.io/upspin"
+
+	dirserver "upspin.io/dir/inprocess"
+	keyserver "upspin.io/key/inprocess"
+	storeserver "upspin.io/store/inprocess"
+)
+
+// inProcess is the endpoint of the in-process Key, Directory, and Store
+// servers registered by registerInProcess.
+var inProcess = upspin.Endpoint{
+	Transport: upspin.InProcess,
+	NetAddr:   "",
+}
+
+var (
+	inProcessOnce sync.Once
+	inProcessErr  error
+)
+
+// registerInProcess registers in-process Key, Directory, and Store servers
+// with bind. The servers are shared by all users of the process; the
+// Directory server runs as the user in cfg. Only the first call has any
+// effect.
+func registerInProcess(cfg upspin.Config) error {
+	inProcessOnce.Do(func() {
+		if err := bind.RegisterKeyServer(upspin.InProcess, keyserver.New()); err != nil {
+			inProcessErr = err
+			return
+		}
+		if err := bind.RegisterStoreServer(upspin.InProcess, storeserver.New()); err != nil {
+			inProcessErr = err
+			return
+		}
+		inProcessErr = bind.RegisterDirServer(upspin.InProcess, dirserver.New(cfg))
+	})
+	return inProcessErr
+}
+
+// inProcessConfig returns a config for the given user, with a fresh key pair,
+// that uses the in-process servers, registering them if necessary.
+// The user is not registered with the key server.
+func inProcessConfig(user upspin.UserName) (upspin.Config, error) {
+	pub, priv, _, err := keygen.Generate("p256")
+	if err != nil {
+		return nil, err
+	}
+	f, err := factotum.NewFromKeys([]byte(pub), []byte(priv), nil)
+	if err != nil {
+		return nil, err
+	}
+	cfg := config.New()
+	cfg = config.SetUserName(cfg, user)
+	cfg = config.SetPacking(cfg, upspin.EEPack)
+	cfg = config.SetKeyEndpoint(cfg, inProcess)
+	cfg = config.SetDirEndpoint(cfg, inProcess)
+	cfg = config.SetStoreEndpoint(cfg, inProcess)
+	cfg = config.SetFactotum(cfg, f)
+	if err := registerInProcess(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// newInProcessServer returns a server whose config is that of the given user
+// on in-process Key, Directory, and Store servers, bypassing the signup
+// process. The user is given a fresh key pair and an empty root.
+func newInProcessServer(user upspin.UserName) (*server, error) {
+	cfg, err := inProcessConfig(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := putUser(cfg, nil); err != nil {
+		return nil, err
+	}
+	if err := makeRoot(cfg); err != nil {
+		return nil, err
+	}
+
+	s, err := newServer()
+	if err != nil {
+		return nil, err
+	}
+	s.cfg = cfg
+	s.cli = client.New(cfg)
+	return s, nil
+}
@@ -13,7 +13,6 @@ package main // import "augie.upspin.io/cmd/upspin-ui"
 
 import (
 	"crypto/rand"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
@@ -43,12 +42,19 @@ const defaultPath = "augie@upspin.io/" // Show this tree on startup.
 func main() {
 	httpAddr := flag.String("http", "localhost:8000", "HTTP listen `address` (must be loopback)")
 	versionFlag := flag.Bool("version", false, "print version string and exit")
+	headless := flag.Bool("headless", false, "run the command given as an argument in the terminal instead of serving the web interface")
 	flags.Parse(flags.Client)
 
 	if *versionFlag {
 		fmt.Print(version.Version())
 		return
 	}
+	if *headless {
+		if err := runHeadless(flag.Args()); err != nil {
+			exit(err)
+		}
+		return
+	}
 
 	// Disallow listening on non-loopback addresses until we have a better
 	// security model. (Even this is not really secure enough.)
@@ -72,6 +78,7 @@ func main() {
 	} else {
 		fmt.Printf("Serving at %s\n", url)
 	}
+	fmt.Printf("WebDAV at http://%s%s/ (use the key in the URL above as the password)\n", *httpAddr, davPrefix)
 	exit(http.Serve(l, nil))
 }
 
@@ -86,9 +93,26 @@ type server struct {
 	// key to prevent request forgery; static for server's lifetime.
 	key string
 
+	dav http.Handler // Serves WebDAV requests under davPrefix.
+
 	mu  sync.Mutex
 	cfg upspin.Config // Non-nil if signup flow has been completed.
 	cli upspin.Client
+
+	profile  string    // Name of the active profile; see profile.go.
+	rotation *rotation // The most recent key rotation, if any.
+
+	cache cacheManager // The cacheserver started by upspin-ui; see cache.go.
+
+	verifier  *verifier      // Polls for the user's registration; see verify.go.
+	seedCheck *seedChallenge // Unconfirmed secret seed, if any; see seedcheck.go.
+
+	// asServers holds the servers returned by as, by user name.
+	asServers map[upspin.UserName]*server
+
+	// Set by tests to run against in-process servers; nil otherwise.
+	signupFunc   func(upspin.Config) error                      // Replaces signup.MakeRequest.
+	endpointFunc func(hostname string) (upspin.Endpoint, error) // Replaces hostnameToEndpoint.
 }
 
 func newServer() (*server, error) {
@@ -97,9 +121,11 @@ func newServer() (*server, error) {
 		return nil, err
 	}
 
-	return &server{
+	s := &server{
 		key: key,
-	}, nil
+	}
+	s.dav = newDAVHandler(s)
+	return s, nil
 }
 
 func (s *server) hasConfig() bool {
@@ -108,12 +134,40 @@ func (s *server) hasConfig() bool {
 	return s.cfg != nil && s.cli != nil
 }
 
+// client returns the current config and client, which are nil until the
+// signup flow has been completed. As switching profiles replaces them,
+// a request should call client once and use only the values it returns.
+func (s *server) client() (upspin.Config, upspin.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg, s.cli
+}
+
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	p := r.URL.Path
-	if p == "/_upspin" {
+	if strings.HasPrefix(p, apiPrefix) {
 		s.serveAPI(w, r)
 		return
 	}
+	if p == davPrefix || strings.HasPrefix(p, davPrefix+"/") {
+		s.serveDAV(w, r)
+		return
+	}
+	// Content requests may be made as a server user.
+	if strings.HasPrefix(p, previewPrefix) || strings.HasPrefix(p, thumbnailPrefix) || strings.Contains(p, "@") {
+		var ok bool
+		if s, ok = s.asRequest(w, r); !ok {
+			return
+		}
+	}
+	if strings.HasPrefix(p, previewPrefix) {
+		s.servePreview(w, r)
+		return
+	}
+	if strings.HasPrefix(p, thumbnailPrefix) {
+		s.serveThumbnail(w, r)
+		return
+	}
 	if strings.Contains(p, "@") {
 		s.serveContent(w, r)
 		return
@@ -139,177 +193,43 @@ func (s *server) serveStatic(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) serveContent(w http.ResponseWriter, r *http.Request) {
-	if !s.hasConfig() {
+	cfg, cli := s.client()
+	if cli == nil {
 		http.Error(w, "No configuration", http.StatusServiceUnavailable)
 		return
 	}
 
 	p := r.URL.Path[1:]
-	if !xsrftoken.Valid(r.FormValue("token"), s.key, string(s.cfg.UserName()), p) {
+	if !xsrftoken.Valid(r.FormValue("token"), s.key, s.xsrfUser(), p) {
 		http.Error(w, "Invalid XSRF token", http.StatusForbidden)
 		return
 	}
 
 	name := upspin.PathName(p)
-	de, err := s.cli.Lookup(name, true)
+	de, err := cli.Lookup(name, true)
 	if err != nil {
 		httpError(w, err)
 		return
 	}
-	f, err := s.cli.Open(name)
+	// Read the file block by block, so that range requests
+	// fetch only the blocks that cover the requested range.
+	f, err := newBlockReader(cfg, de)
 	if err != nil {
 		httpError(w, err)
 		return
 	}
-	http.ServeContent(w, r, path.Base(p), de.Time.Go(), f)
-	f.Close()
-}
-
-func (s *server) serveAPI(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Expected POST request", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Require a valid key.
-	if r.FormValue("key") != s.key {
-		http.Error(w, "Invalid key", http.StatusForbidden)
-		return
-	}
-
-	method := r.FormValue("method")
-
-	// Don't permit accesses of non-startup methods if there is no config
-	// nor client; those methods need them.
-	if method != "startup" && !s.hasConfig() {
-		http.Error(w, "No configuration", http.StatusServiceUnavailable)
-		return
-	}
-
-	var resp interface{}
-	switch method {
-	case "startup":
-		sResp, cfg, err := s.startup(r)
-		var errString string
-		if err != nil {
-			errString = err.Error()
-		}
-		var (
-			user        upspin.UserName
-			left, right upspin.PathName
-		)
-		if cfg != nil {
-			user = cfg.UserName()
-			right = defaultPath
-			// If the user has a directory endpoint then open to
-			// their tree. Otherwise, open both panels to augie.
-			if cfg.DirEndpoint().Transport == upspin.Remote {
-				left = upspin.PathName(user + "/")
-			} else {
-				left = right
-			}
-		}
-		ver := "upspin-ui"
-		if sha := version.GitSHA; sha != "" {
-			ver = fmt.Sprintf("%s commit %.7s built %s", ver, sha, version.BuildTime.Format("2 Jan 06"))
-		}
-		resp = struct {
-			Startup   *startupResponse
-			UserName  upspin.UserName
-			LeftPath  upspin.PathName
-			RightPath upspin.PathName
-			Version   string
-			Error     string
-		}{sResp, user, left, right, ver, errString}
-	case "list":
-		path := upspin.PathName(r.FormValue("path"))
-		des, err := s.cli.Glob(upspin.AllFilesGlob(path))
-		var errString string
-		if err != nil {
-			errString = err.Error()
-		}
-		var entries []entryWithToken
-		for _, de := range des {
-			tok := xsrftoken.Generate(s.key, string(s.cfg.UserName()), string(de.Name))
-			entries = append(entries, entryWithToken{
-				DirEntry:  de,
-				FileToken: tok,
-			})
-		}
-		resp = struct {
-			Entries []entryWithToken
-			Error   string
-		}{entries, errString}
-	case "mkdir":
-		_, err := s.cli.MakeDirectory(upspin.PathName(r.FormValue("path")))
-		var errString string
-		if err != nil {
-			errString = err.Error()
-		}
-		resp = struct {
-			Error string
-		}{errString}
-	case "rm":
-		var errString string
-		for _, p := range r.Form["paths[]"] {
-			if err := s.rm(upspin.PathName(p)); err != nil {
-				errString = err.Error()
-				break
-			}
-		}
-		resp = struct {
-			Error string
-		}{errString}
-	case "copy":
-		dst := upspin.PathName(r.FormValue("dest"))
-		var paths []upspin.PathName
-		for _, p := range r.Form["paths[]"] {
-			paths = append(paths, upspin.PathName(p))
-		}
-		var errString string
-		if err := s.copy(dst, paths); err != nil {
-			errString = err.Error()
-		}
-		resp = struct {
-			Error string
-		}{errString}
-	case "put":
-		const maxMultipartSize = 500e6
-		if err := r.ParseMultipartForm(maxMultipartSize); err != nil {
-			http.Error(w, "Parse error: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		if len(r.MultipartForm.File) == 0 {
-			http.Error(w, "missing file", http.StatusBadRequest)
-			return
-		}
-		var errString string
-		for _, fhs := range r.MultipartForm.File {
-			if len(fhs) == 0 {
-				http.Error(w, "missing file handle", http.StatusBadRequest)
-				return
-			}
-			err := s.put(upspin.PathName(r.FormValue("dir")), fhs[0])
-			if err != nil {
-				errString = err.Error()
-				break
-			}
-		}
-		resp = struct {
-			Error string
-		}{errString}
-	}
-	b, err := json.Marshal(resp)
+	defer f.Close()
+	ctype, err := contentType(p, f)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, err)
 		return
 	}
-	w.Write(b)
-}
-
-type entryWithToken struct {
-	*upspin.DirEntry
-	FileToken string
+	setContentHeaders(w, p, ctype, r.FormValue("download") == "1")
+	// The ETag lets http.ServeContent answer conditional requests.
+	// Ask the browser to revalidate each time, as the file may change.
+	w.Header().Set("ETag", entryETag(de))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, path.Base(p), de.Time.Go(), f)
 }
 
 func generateKey() (string, error) {
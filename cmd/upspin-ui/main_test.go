@@ -0,0 +1,101 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"augie.upspin.io/uiclient"
+
+	"upspin.io/errors"
+	"upspin.io/path"
+	"upspin.io/upspin"
+)
+
+// testStep is a named step of a test whose steps depend on those before it.
+type testStep struct {
+	name string
+	fn   func() error
+}
+
+// runSteps runs the given steps in order as subtests,
+// stopping at the first that fails.
+func runSteps(t *testing.T, steps []testStep) {
+	for _, step := range steps {
+		ok := t.Run(step.name, func(t *testing.T) {
+			if err := step.fn(); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if !ok {
+			t.FailNow()
+		}
+	}
+}
+
+// expectList checks that the directory dir holds exactly the given entries,
+// named relative to the user's root. Directory names have a trailing slash.
+func expectList(c *uiclient.Client, dir upspin.PathName, want ...string) error {
+	entries, err := c.List(dir)
+	if err != nil {
+		return err
+	}
+	var got []string
+	for _, e := range entries {
+		name := string(e.Name)
+		name = name[strings.Index(name, "/")+1:]
+		if e.IsDir() {
+			name += "/"
+		}
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		return errors.Errorf("listing %s: got %q, want %q", dir, got, want)
+	}
+	return nil
+}
+
+// expectContent checks that the named file holds the given content,
+// fetching it through the same URL the browser would use.
+func expectContent(c *uiclient.Client, name upspin.PathName, want []byte) error {
+	entries, err := c.List(path.DropPath(name, 1))
+	if err != nil {
+		return err
+	}
+	var entry *uiclient.Entry
+	for _, e := range entries {
+		if e.Name == name {
+			entry = e
+		}
+	}
+	if entry == nil {
+		return errors.E(name, errors.NotExist)
+	}
+	got, err := c.Get(entry)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return errors.Errorf("content of %s: got %q, want %q", name, got, want)
+	}
+	return nil
+}
+
+// expectError checks that err is an API error of the given kind.
+func expectError(err error, kind string) error {
+	if err == nil {
+		return errors.Errorf("got no error, want %s", kind)
+	}
+	e, ok := err.(*uiclient.Error)
+	if !ok || e.Kind != kind {
+		return errors.Errorf("got error %v, want %s", err, kind)
+	}
+	return nil
+}
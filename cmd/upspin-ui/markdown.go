@@ -0,0 +1,342 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// renderMarkdown renders a subset of Markdown as HTML.
+// It supports headings, paragraphs, block quotes, lists, horizontal rules,
+// fenced and indented code blocks, and the inline emphasis, code and link
+// syntaxes. It never passes raw HTML from the source through to its output,
+// and it only emits links with the http, https and mailto schemes,
+// so the result is safe to display on the upspin-ui origin.
+func renderMarkdown(src string) string {
+	var (
+		buf   strings.Builder
+		para  []string // Lines of the current paragraph.
+		list  string   // "ul" or "ol" if inside a list.
+		quote []string // Lines of the current block quote.
+	)
+	flushPara := func() {
+		if len(para) > 0 {
+			buf.WriteString("<p>")
+			buf.WriteString(renderInline(strings.Join(para, "\n")))
+			buf.WriteString("</p>\n")
+			para = nil
+		}
+	}
+	flushList := func() {
+		if list != "" {
+			buf.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	flushQuote := func() {
+		if len(quote) > 0 {
+			buf.WriteString("<blockquote>\n")
+			buf.WriteString(renderMarkdown(strings.Join(quote, "\n")))
+			buf.WriteString("</blockquote>\n")
+			quote = nil
+		}
+	}
+	flush := func() {
+		flushPara()
+		flushList()
+		flushQuote()
+	}
+
+	lines := strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		// Block quotes gather lines until the next non-quote line.
+		if strings.HasPrefix(trimmed, ">") {
+			flushPara()
+			flushList()
+			quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(trimmed, ">"), " "))
+			continue
+		}
+		flushQuote()
+
+		switch {
+		case trimmed == "":
+			flushPara()
+			flushList()
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			// Fenced code block.
+			flush()
+			fence := trimmed[:3]
+			lang := strings.TrimSpace(trimmed[3:])
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			buf.WriteString("<pre><code>")
+			buf.WriteString(highlight(strings.Join(code, "\n"), lang))
+			buf.WriteString("</code></pre>\n")
+
+		case len(para) == 0 && strings.HasPrefix(line, "    ") && list == "":
+			// Indented code block.
+			flush()
+			var code []string
+			for ; i < len(lines); i++ {
+				l := lines[i]
+				if strings.TrimSpace(l) != "" && !strings.HasPrefix(l, "    ") {
+					i--
+					break
+				}
+				code = append(code, strings.TrimPrefix(l, "    "))
+			}
+			buf.WriteString("<pre><code>")
+			buf.WriteString(html.EscapeString(strings.TrimRight(strings.Join(code, "\n"), "\n")))
+			buf.WriteString("</code></pre>\n")
+
+		case isRule(trimmed):
+			flush()
+			buf.WriteString("<hr>\n")
+
+		case strings.HasPrefix(trimmed, "#"):
+			level := 0
+			for level < len(trimmed) && trimmed[level] == '#' {
+				level++
+			}
+			if level > 6 || (level < len(trimmed) && trimmed[level] != ' ') {
+				para = append(para, trimmed)
+				break
+			}
+			flush()
+			text := strings.TrimSpace(strings.TrimRight(trimmed[level:], "#"))
+			tag := strconv.Itoa(level)
+			buf.WriteString("<h" + tag + ">")
+			buf.WriteString(renderInline(text))
+			buf.WriteString("</h" + tag + ">\n")
+
+		default:
+			if kind, item, ok := listItem(trimmed); ok {
+				flushPara()
+				if list != kind {
+					flushList()
+					list = kind
+					buf.WriteString("<" + list + ">\n")
+				}
+				buf.WriteString("<li>")
+				buf.WriteString(renderInline(item))
+				buf.WriteString("</li>\n")
+				break
+			}
+			if list != "" && strings.HasPrefix(line, " ") {
+				// Continuation of a list item; render it as
+				// a separate line within the list.
+				buf.WriteString("<li style=\"list-style: none\">")
+				buf.WriteString(renderInline(trimmed))
+				buf.WriteString("</li>\n")
+				break
+			}
+			flushList()
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+	return buf.String()
+}
+
+// isRule reports whether the given line is a horizontal rule:
+// three or more '-', '*' or '_' characters, optionally separated by spaces.
+func isRule(line string) bool {
+	s := strings.Replace(line, " ", "", -1)
+	if len(s) < 3 {
+		return false
+	}
+	c := s[0]
+	if c != '-' && c != '*' && c != '_' {
+		return false
+	}
+	return strings.Count(s, string(c)) == len(s)
+}
+
+// listItem reports whether line is a list item and, if so,
+// the kind of list ("ul" or "ol") and the text of the item.
+func listItem(line string) (kind, item string, ok bool) {
+	if len(line) >= 2 && (line[0] == '-' || line[0] == '*' || line[0] == '+') && line[1] == ' ' {
+		return "ul", strings.TrimSpace(line[2:]), true
+	}
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	if i > 0 && i+1 < len(line) && (line[i] == '.' || line[i] == ')') && line[i+1] == ' ' {
+		return "ol", strings.TrimSpace(line[i+2:]), true
+	}
+	return "", "", false
+}
+
+// renderInline renders the inline Markdown elements in s as HTML,
+// escaping all other text.
+func renderInline(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!<>", s[i+1]) >= 0:
+			i++
+			buf.WriteString(html.EscapeString(s[i : i+1]))
+			continue
+
+		case c == '`':
+			if j := strings.IndexByte(s[i+1:], '`'); j >= 0 {
+				buf.WriteString("<code>")
+				buf.WriteString(html.EscapeString(s[i+1 : i+1+j]))
+				buf.WriteString("</code>")
+				i += j + 1
+				continue
+			}
+
+		case (c == '*' || c == '_') && i+1 < len(s) && s[i+1] == c:
+			delim := s[i : i+2]
+			if j := strings.Index(s[i+2:], delim); j > 0 {
+				buf.WriteString("<strong>")
+				buf.WriteString(renderInline(s[i+2 : i+2+j]))
+				buf.WriteString("</strong>")
+				i += j + 3
+				continue
+			}
+
+		case c == '*' || (c == '_' && (i == 0 || !isWordByte(s[i-1]))):
+			if j := closingDelim(s[i+1:], c); j > 0 {
+				buf.WriteString("<em>")
+				buf.WriteString(renderInline(s[i+1 : i+1+j]))
+				buf.WriteString("</em>")
+				i += j + 1
+				continue
+			}
+
+		case c == '[' || (c == '!' && i+1 < len(s) && s[i+1] == '['):
+			// Links and images. Images are rendered as links,
+			// so that previews never fetch remote content.
+			start := i
+			if c == '!' {
+				start++
+			}
+			if text, u, n, ok := parseLink(s[start:]); ok {
+				buf.WriteString(`<a href="`)
+				buf.WriteString(html.EscapeString(safeURL(u)))
+				buf.WriteString(`" target="_blank" rel="noopener noreferrer">`)
+				buf.WriteString(renderInline(text))
+				buf.WriteString("</a>")
+				i = start + n - 1
+				continue
+			}
+
+		case c == '<':
+			// Autolinks, such as <https://upspin.io>.
+			if j := strings.IndexByte(s[i+1:], '>'); j > 0 {
+				u := s[i+1 : i+1+j]
+				if safe := safeURL(u); safe == u && strings.Contains(u, ":") {
+					buf.WriteString(`<a href="`)
+					buf.WriteString(html.EscapeString(u))
+					buf.WriteString(`" target="_blank" rel="noopener noreferrer">`)
+					buf.WriteString(html.EscapeString(u))
+					buf.WriteString("</a>")
+					i += j + 1
+					continue
+				}
+			}
+
+		case c == '\n':
+			buf.WriteString("\n")
+			continue
+		}
+		buf.WriteString(html.EscapeString(s[i : i+1]))
+	}
+	return buf.String()
+}
+
+// parseLink parses a link of the form [text](url) at the start of s,
+// returning the text, the URL, and the number of bytes consumed.
+func parseLink(s string) (text, u string, n int, ok bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if i+1 >= len(s) || s[i+1] != '(' {
+				return "", "", 0, false
+			}
+			// URLs may contain balanced parentheses.
+			j, parens := -1, 0
+			for k := i + 2; k < len(s) && j < 0; k++ {
+				switch s[k] {
+				case '(':
+					parens++
+				case ')':
+					if parens == 0 {
+						j = k - (i + 2)
+					}
+					parens--
+				}
+			}
+			if j < 0 {
+				return "", "", 0, false
+			}
+			u = strings.TrimSpace(s[i+2 : i+2+j])
+			// Drop any link title.
+			if k := strings.IndexAny(u, " \t"); k >= 0 {
+				u = u[:k]
+			}
+			return s[1:i], u, i + 3 + j, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// safeURL returns u if it is a relative URL or uses the http, https, or
+// mailto schemes. Otherwise it returns "#".
+func safeURL(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return "#"
+	}
+	switch strings.ToLower(p.Scheme) {
+	case "", "http", "https", "mailto":
+		return u
+	}
+	return "#"
+}
+
+// closingDelim returns the index in s of the single delimiter c that closes
+// an emphasis, skipping any doubled delimiters of strong emphasis within it,
+// or -1 if there is none.
+func closingDelim(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] != c {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == c {
+			i++
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"html"
+	"strings"
+	"testing"
+)
+
+// link is the HTML of a link to the given (escaped) URL with the given text,
+// as rendered by renderInline.
+func link(href, text string) string {
+	return `<a href="` + href + `" target="_blank" rel="noopener noreferrer">` + text + `</a>`
+}
+
+var markdownTests = []struct {
+	name, in, want string
+}{
+	// Raw HTML is escaped wherever it appears.
+	{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+	{"img onerror", "a <img src=x onerror=alert(1)> b", "<p>a &lt;img src=x onerror=alert(1)&gt; b</p>\n"},
+	{"escaped angle", `\<script>`, "<p>&lt;script&gt;</p>\n"},
+	{"code span", "`<script>alert(1)</script>`", "<p><code>&lt;script&gt;alert(1)&lt;/script&gt;</code></p>\n"},
+	{"fenced code", "```\n<script>alert(1)</script>\n<img src=x onerror=alert(1)>\n```",
+		`<pre><code>&lt;script&gt;alert(<span class="hl-number">1</span>)&lt;/script&gt;` + "\n" +
+			`&lt;img src=x onerror=alert(<span class="hl-number">1</span>)&gt;</code></pre>` + "\n"},
+	{"fenced go", "```go\n// <script>\ns := \"<img onerror=x>\"\n```",
+		`<pre><code><span class="hl-comment">// &lt;script&gt;</span>` + "\n" +
+			`s := <span class="hl-string">&#34;&lt;img onerror=x&gt;&#34;</span></code></pre>` + "\n"},
+	{"indented code", "    <script>alert(1)</script>", "<pre><code>&lt;script&gt;alert(1)&lt;/script&gt;</code></pre>\n"},
+	{"blocks", "# <h>\n> <q>\n\n- <li>",
+		"<h1>&lt;h&gt;</h1>\n<blockquote>\n<p>&lt;q&gt;</p>\n</blockquote>\n<ul>\n<li>&lt;li&gt;</li>\n</ul>\n"},
+
+	// Only http, https, mailto and relative URLs are linked.
+	{"https link", "[x](https://upspin.io)", "<p>" + link("https://upspin.io", "x") + "</p>\n"},
+	{"relative link", "[x](/doc/a.md)", "<p>" + link("/doc/a.md", "x") + "</p>\n"},
+	{"javascript link", "[x](javascript:alert(1))", "<p>" + link("#", "x") + "</p>\n"},
+	{"mixed case javascript link", "[x](JaVaScRiPt:alert(1))", "<p>" + link("#", "x") + "</p>\n"},
+	{"data link", "[x](data:text/html,<script>alert(1)</script>)", "<p>" + link("#", "x") + "</p>\n"},
+	{"entity javascript link", "[x](&#106;avascript:alert(1))", "<p>" + link("&amp;#106;avascript:alert(1)", "x") + "</p>\n"},
+	{"autolink", "<https://upspin.io>", "<p>" + link("https://upspin.io", "https://upspin.io") + "</p>\n"},
+	{"javascript autolink", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+	{"mixed case javascript autolink", "<JaVaScRiPt:alert(1)>", "<p>&lt;JaVaScRiPt:alert(1)&gt;</p>\n"},
+	{"image", "![img](https://example.com/a.png)", "<p>" + link("https://example.com/a.png", "img") + "</p>\n"},
+	{"parentheses in link", "[x](https://example.com/a_(b))", "<p>" + link("https://example.com/a_(b)", "x") + "</p>\n"},
+
+	// Quotes cannot end the href attribute.
+	{"quote in link", `[x](http://a.com/"onmouseover="alert(1))`,
+		"<p>" + link("http://a.com/&#34;onmouseover=&#34;alert(1)", "x") + "</p>\n"},
+	{"quote in relative link", `[x]("onmouseover="alert(1))`,
+		"<p>" + link("&#34;onmouseover=&#34;alert(1)", "x") + "</p>\n"},
+	{"quote in link text", `["><script>](/x)`, "<p>" + link("/x", "&#34;&gt;&lt;script&gt;") + "</p>\n"},
+
+	// Nesting.
+	{"em in strong", "**bold *em* [link](https://upspin.io)**",
+		"<p><strong>bold <em>em</em> " + link("https://upspin.io", "link") + "</strong></p>\n"},
+	{"strong in em", "*em **bold** em*", "<p><em>em <strong>bold</strong> em</em></p>\n"},
+	{"markup in link", "[**bold** `code`](https://upspin.io)",
+		"<p>" + link("https://upspin.io", "<strong>bold</strong> <code>code</code>") + "</p>\n"},
+	{"brackets in link", "[a [b] c](/x)", "<p>" + link("/x", "a [b] c") + "</p>\n"},
+	{"underscores in words", "snake_case_name", "<p>snake_case_name</p>\n"},
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, test := range markdownTests {
+		if got := renderMarkdown(test.in); got != test.want {
+			t.Errorf("%s: renderMarkdown(%q)\n got %q\nwant %q", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestHighlightEscapes(t *testing.T) {
+	inputs := []string{
+		"<script>alert(1)</script>",
+		`"<img onerror=x>" // </code><script>`,
+		"'</span><script>'",
+		"/* </pre><script> */ `<img onerror=x>`",
+		"# <script>alert(1)</script>",
+	}
+	for _, lang := range []string{"go", "js", "py", "sh", "c", ""} {
+		for _, in := range inputs {
+			got := highlight(in, lang)
+			// Removing the spans must leave exactly the escaped source.
+			text := got
+			for _, class := range []string{"hl-comment", "hl-string", "hl-number", "hl-keyword"} {
+				text = strings.Replace(text, `<span class="`+class+`">`, "", -1)
+			}
+			text = strings.Replace(text, "</span>", "", -1)
+			if want := html.EscapeString(in); text != want {
+				t.Errorf("highlight(%q, %q) = %q\nwithout spans %q, want %q", in, lang, got, text, want)
+			}
+		}
+	}
+}
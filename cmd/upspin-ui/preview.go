@@ -0,0 +1,492 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	_ "image/gif" // Register the GIF decoder for image.Decode.
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/xsrftoken"
+
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+const (
+	// previewPrefix and thumbnailPrefix are the URL path prefixes for
+	// the preview and thumbnail handlers. The remainder of the path is
+	// the Upspin path name of the file, as for serveContent.
+	previewPrefix   = "/_preview/"
+	thumbnailPrefix = "/_thumbnail/"
+
+	// defaultThumbnailSize and maxThumbnailSize are the default and
+	// maximum bounds, in pixels, of a generated thumbnail.
+	defaultThumbnailSize = 128
+	maxThumbnailSize     = 512
+
+	// maxImageSize and maxImagePixels limit the images we will decode
+	// to generate a thumbnail.
+	maxImageSize   = 50 << 20
+	maxImagePixels = 50e6
+)
+
+// previewKind returns the kind of preview to generate for the named file,
+// based on its extension: "markdown", "image", "pdf", or "text".
+// Files whose extensions are not recognized are previewed as text,
+// if their contents are valid UTF-8.
+func previewKind(name upspin.PathName) string {
+	switch strings.ToLower(path.Ext(string(name))) {
+	case ".md", ".markdown":
+		return "markdown"
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return "image"
+	case ".pdf":
+		return "pdf"
+	}
+	return "text"
+}
+
+// servePreview serves an HTML rendering of Markdown files, a syntax
+// highlighted version of text files, or the raw bytes of images and PDFs
+// for display inline in the browser.
+func (s *server) servePreview(w http.ResponseWriter, r *http.Request) {
+	cfg, cli := s.client()
+	name, de, ok := s.contentEntry(w, r, cli, previewPrefix)
+	if !ok {
+		return
+	}
+
+	kind := previewKind(name)
+	if kind == "image" || kind == "pdf" {
+		f, err := newBlockReader(cfg, de)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		defer f.Close()
+		ctype, err := contentType(string(name), f)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		setContentHeaders(w, string(name), ctype, false)
+		w.Header().Set("ETag", entryETag(de))
+		w.Header().Set("Cache-Control", "private, no-cache")
+		http.ServeContent(w, r, path.Base(string(name)), de.Time.Go(), f)
+		return
+	}
+
+	size, err := de.Size()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if size > maxTextSize {
+		http.Error(w, "File too large to preview", http.StatusUnsupportedMediaType)
+		return
+	}
+	b, err := cli.Get(name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if !utf8.Valid(b) {
+		http.Error(w, "Cannot preview binary file", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body string
+	if kind == "markdown" {
+		body = renderMarkdown(string(b))
+	} else {
+		body = "<pre><code>" + highlight(string(b), strings.TrimPrefix(path.Ext(string(name)), ".")) + "</code></pre>"
+	}
+	// The body is generated by us and contains no scripts,
+	// but forbid them anyway in case of bugs in the renderers.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, previewHTML, html.EscapeString(string(name)), body)
+}
+
+// serveThumbnail serves a downscaled version of a JPEG, PNG, or GIF image.
+// The "size" form value specifies the thumbnail's maximum width and height.
+// Thumbnails are cached on local disk, keyed by the file's sequence number.
+func (s *server) serveThumbnail(w http.ResponseWriter, r *http.Request) {
+	cfg, cli := s.client()
+	name, de, ok := s.contentEntry(w, r, cli, thumbnailPrefix)
+	if !ok {
+		return
+	}
+	if previewKind(name) != "image" {
+		http.Error(w, "Not an image", http.StatusUnsupportedMediaType)
+		return
+	}
+	bound := defaultThumbnailSize
+	if v := r.FormValue("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxThumbnailSize {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+		bound = n
+	}
+
+	b, err := thumbnail(cfg, cli, de, bound)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(b))
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, de.Sequence, bound))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", de.Time.Go(), bytes.NewReader(b))
+}
+
+// contentEntry checks the XSRF token for a content request whose URL path
+// is prefix followed by an Upspin path name, and looks up that name using
+// the given client. If it returns false it has already written an HTTP error.
+func (s *server) contentEntry(w http.ResponseWriter, r *http.Request, cli upspin.Client, prefix string) (upspin.PathName, *upspin.DirEntry, bool) {
+	if cli == nil {
+		http.Error(w, "No configuration", http.StatusServiceUnavailable)
+		return "", nil, false
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, prefix)
+	if !xsrftoken.Valid(r.FormValue("token"), s.key, s.xsrfUser(), p) {
+		http.Error(w, "Invalid XSRF token", http.StatusForbidden)
+		return "", nil, false
+	}
+
+	name := upspin.PathName(p)
+	de, err := cli.Lookup(name, true)
+	if err != nil {
+		httpError(w, err)
+		return "", nil, false
+	}
+	if de.IsDir() {
+		http.Error(w, "Cannot preview a directory", http.StatusBadRequest)
+		return "", nil, false
+	}
+	return name, de, true
+}
+
+// thumbnail returns an encoded thumbnail for the given image entry that fits
+// within bound pixels, either from the local cache or by generating it with
+// the given client. Cached thumbnails are kept per user, as the user in cfg
+// may not be able to read another user's copy of the same file.
+func thumbnail(cfg upspin.Config, cli upspin.Client, de *upspin.DirEntry, bound int) ([]byte, error) {
+	file, err := thumbnailFile(cfg.UserName(), de, bound)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := ioutil.ReadFile(file); err == nil {
+		return b, nil
+	}
+
+	size, err := de.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size > maxImageSize {
+		return nil, errors.E(de.Name, errors.Invalid, "image too large for thumbnail")
+	}
+	data, err := cli.Get(de.Name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := makeThumbnail(data, bound)
+	if err != nil {
+		return nil, errors.E(de.Name, err)
+	}
+
+	// Remove thumbnails of earlier versions of this file,
+	// then cache this one. Errors here are not fatal.
+	if old, err := filepath.Glob(thumbnailGlob(file)); err == nil {
+		for _, f := range old {
+			os.Remove(f)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		logf("thumbnail: %v", err)
+	} else if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		logf("thumbnail: %v", err)
+	}
+	return b, nil
+}
+
+// thumbnailFile returns the name of the local file that caches, for the
+// given user, the thumbnail of the given entry at the given size. The name
+// is derived from the user name, the entry's path name and sequence number,
+// and the thumbnail size.
+func thumbnailFile(user upspin.UserName, de *upspin.DirEntry, bound int) (string, error) {
+	home, err := config.Homedir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(string(user) + "\x00" + string(de.Name)))
+	base := fmt.Sprintf("%x-%d-%d", sum[:16], bound, de.Sequence)
+	return filepath.Join(home, "upspin", "upspin-ui", "thumbnails", base), nil
+}
+
+// thumbnailGlob returns a pattern that matches all cached thumbnails
+// for the same file and size as the given thumbnail file.
+func thumbnailGlob(file string) string {
+	return file[:strings.LastIndex(file, "-")] + "-*"
+}
+
+// makeThumbnail decodes the given JPEG, PNG, or GIF image and returns
+// a version of it, encoded as a JPEG or PNG, that fits within bound pixels.
+func makeThumbnail(data []byte, bound int) ([]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if float64(cfg.Width)*float64(cfg.Height) > maxImagePixels {
+		return nil, errors.E(errors.Invalid, errors.Errorf("image too large for thumbnail (%dx%d)", cfg.Width, cfg.Height))
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	dst := scale(src, bound)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleSamples is the largest number of source pixels, in each dimension,
+// that scale averages for each pixel of the thumbnail.
+const scaleSamples = 4
+
+// scale returns a copy of src, downscaled so that it fits within bound pixels
+// in each dimension, preserving its aspect ratio. Each thumbnail pixel is the
+// average of at most scaleSamples×scaleSamples evenly spaced pixels of the
+// area of src that it covers, so large images are subsampled rather than
+// read in full. Images that already fit are returned unchanged.
+func scale(src image.Image, bound int) image.Image {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	if sw <= bound && sh <= bound {
+		return src
+	}
+	dw, dh := bound, bound
+	if sw > sh {
+		dh = sh * bound / sw
+	} else {
+		dw = sw * bound / sh
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := sb.Min.Y+y*sh/dh, sb.Min.Y+(y+1)*sh/dh
+		ystep := scaleStep(y0, y1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := sb.Min.X+x*sw/dw, sb.Min.X+(x+1)*sw/dw
+			xstep := scaleStep(x0, x1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy += ystep {
+				for sx := x0; sx < x1; sx += xstep {
+					// RGBA returns alpha-premultiplied values,
+					// which average correctly.
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// scaleStep returns the distance between the pixels of the source interval
+// [v0, v1) that scale samples.
+func scaleStep(v0, v1 int) int {
+	if step := (v1 - v0) / scaleSamples; step > 1 {
+		return step
+	}
+	return 1
+}
+
+// highlightKeywords maps file extensions to the keywords that highlight
+// should emphasize in files of that type.
+var highlightKeywords = map[string][]string{
+	"go": {"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select", "struct", "switch", "type", "var"},
+	"js": {"break", "case", "catch", "const", "continue", "default", "delete", "do", "else", "for", "function", "if", "in", "instanceof", "let", "new", "return", "switch", "this", "throw", "try", "typeof", "var", "while"},
+	"py": {"and", "as", "class", "def", "elif", "else", "except", "for", "from", "if", "import", "in", "is", "lambda", "not", "or", "pass", "raise", "return", "try", "while", "with", "yield"},
+	"sh": {"case", "do", "done", "elif", "else", "esac", "fi", "for", "function", "if", "in", "then", "until", "while"},
+	"c":  {"break", "case", "char", "const", "continue", "default", "do", "double", "else", "enum", "float", "for", "if", "int", "long", "return", "sizeof", "static", "struct", "switch", "typedef", "unsigned", "void", "while"},
+}
+
+// highlightAliases maps file extensions to the keys of highlightKeywords
+// whose syntax they share.
+var highlightAliases = map[string]string{
+	"golang": "go",
+	"json":   "js",
+	"ts":     "js",
+	"python": "py",
+	"bash":   "sh",
+	"yaml":   "sh",
+	"yml":    "sh",
+	"h":      "c",
+	"cc":     "c",
+	"cpp":    "c",
+	"java":   "c",
+	"rs":     "c",
+}
+
+// highlight returns src as HTML with comments, strings, numbers and keywords
+// wrapped in span elements, using the conventions of the language associated
+// with the file extension lang. Text in an unknown language is highlighted
+// using '#' comments only, which suits Access, Group and config files.
+func highlight(src, lang string) string {
+	lang = strings.ToLower(lang)
+	if a, ok := highlightAliases[lang]; ok {
+		lang = a
+	}
+	keywords := map[string]bool{}
+	for _, k := range highlightKeywords[lang] {
+		keywords[k] = true
+	}
+	slashComments := lang == "go" || lang == "js" || lang == "c"
+	hashComments := !slashComments
+	rawStrings := lang == "go" || lang == "js"
+
+	var buf strings.Builder
+	span := func(class, text string) {
+		buf.WriteString(`<span class="` + class + `">`)
+		buf.WriteString(html.EscapeString(text))
+		buf.WriteString("</span>")
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		rest := src[i:]
+		switch {
+		case hashComments && c == '#', slashComments && strings.HasPrefix(rest, "//"):
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			span("hl-comment", rest[:n])
+			i += n
+
+		case slashComments && strings.HasPrefix(rest, "/*"):
+			n := strings.Index(rest[2:], "*/")
+			if n < 0 {
+				n = len(rest)
+			} else {
+				n += 4
+			}
+			span("hl-comment", rest[:n])
+			i += n
+
+		case c == '"' || c == '\'' || (c == '`' && rawStrings):
+			// Strings run to the closing quote or, except for
+			// raw strings, the end of the line.
+			raw := c == '`'
+			n := 1
+			for n < len(rest) && rest[n] != c {
+				if !raw && rest[n] == '\n' {
+					break
+				}
+				if !raw && rest[n] == '\\' && n+1 < len(rest) {
+					n++
+				}
+				n++
+			}
+			if n < len(rest) && rest[n] == c {
+				n++
+			}
+			span("hl-string", rest[:n])
+			i += n
+
+		case isWordByte(c) && (i == 0 || !isWordByte(src[i-1])):
+			n := 1
+			for n < len(rest) && isWordByte(rest[n]) {
+				n++
+			}
+			word := rest[:n]
+			switch {
+			case '0' <= c && c <= '9':
+				span("hl-number", word)
+			case keywords[word]:
+				span("hl-keyword", word)
+			default:
+				buf.WriteString(html.EscapeString(word))
+			}
+			i += n
+
+		default:
+			buf.WriteString(html.EscapeString(src[i : i+1]))
+			i++
+		}
+	}
+	return buf.String()
+}
+
+// previewHTML is the page template for Markdown and text previews.
+// Its arguments are the escaped file name and the rendered body.
+const previewHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: -apple-system, "Helvetica Neue", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 50em; padding: 0 1em; line-height: 1.5; color: #333; }
+pre { background: #f7f7f7; padding: 1em; overflow: auto; line-height: 1.3; }
+code { font-family: Menlo, Monaco, Consolas, monospace; font-size: 90%%; }
+blockquote { border-left: 4px solid #ddd; margin-left: 0; padding-left: 1em; color: #666; }
+.hl-comment { color: #998; font-style: italic; }
+.hl-string { color: #d14; }
+.hl-number { color: #099; }
+.hl-keyword { color: #333; font-weight: bold; }
+</style>
+</head>
+<body>
+%s
+</body>
+</html>
+`
@@ -0,0 +1,137 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"upspin.io/client"
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/flags"
+	"upspin.io/upspin"
+)
+
+// defaultProfile is the name of the profile whose config is flags.Config.
+const defaultProfile = "default"
+
+// profile describes a config file that the server may switch to.
+type profile struct {
+	Name     string
+	File     string
+	UserName upspin.UserName `json:",omitempty"`
+	Active   bool
+
+	// Error is set if the config file cannot be loaded.
+	Error string `json:",omitempty"`
+}
+
+// profiles returns the profiles available to the server: the default
+// profile, whose config is flags.Config, and one for each file in the same
+// directory whose name is that of flags.Config followed by a dot and the
+// profile name, such as the server configs written by startup.
+func (s *server) profiles() ([]profile, error) {
+	s.mu.Lock()
+	active := s.profile
+	s.mu.Unlock()
+
+	prefix := filepath.Base(flags.Config) + "."
+	fis, err := ioutil.ReadDir(filepath.Dir(flags.Config))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{defaultProfile}
+	var others []string
+	for _, fi := range fis {
+		name := strings.TrimPrefix(fi.Name(), prefix)
+		if fi.IsDir() || name == fi.Name() || !validProfileName(name) {
+			continue
+		}
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	names = append(names, others...)
+
+	var ps []profile
+	for _, name := range names {
+		p := profile{
+			Name:   name,
+			File:   profileFile(name),
+			Active: name == active,
+		}
+		cfg, err := config.FromFile(p.File)
+		if err != nil {
+			p.Error = err.Error()
+		} else {
+			p.UserName = cfg.UserName()
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// validProfileName reports whether name may name a profile. It excludes
+// the files that startup writes alongside the config file that are not
+// themselves configs, and the backups kept by putConfig.
+func validProfileName(name string) bool {
+	return name != "" && name != defaultProfile &&
+		!strings.ContainsAny(name, `/\`) &&
+		!strings.HasPrefix(name, "gcpState") &&
+		"."+name != configBackupSuffix &&
+		!strings.HasSuffix(name, configBackupSuffix)
+}
+
+// profileFile returns the name of the config file for the named profile.
+func profileFile(name string) string {
+	if name == defaultProfile {
+		return flags.Config
+	}
+	return flags.Config + "." + name
+}
+
+// switchProfile makes the named profile the active one, so that subsequent
+// requests are made as its user.
+func (s *server) switchProfile(name string) error {
+	if name != defaultProfile && !validProfileName(name) {
+		return errors.E(errors.Invalid, errors.Errorf("invalid profile name %q", name))
+	}
+	file := profileFile(name)
+	if !exists(file) {
+		return errors.E(errors.NotExist, errors.Errorf("no profile %q", name))
+	}
+	cfg, err := config.FromFile(file)
+	if err != nil {
+		return errors.E(errors.Invalid, errors.Errorf("loading profile %q: %v", name, err))
+	}
+	if cfg.Factotum() == nil {
+		return errors.E(cfg.UserName(), errors.Invalid, errors.Errorf("profile %q has no keys", name))
+	}
+	cli := client.New(cfg)
+
+	s.mu.Lock()
+	s.cfg = cfg
+	s.cli = cli
+	s.profile = name
+	s.asServers = nil // Server users depend on the current user.
+	s.stopVerifier()
+	s.mu.Unlock()
+	logf("switched to profile %q (%s)", name, cfg.UserName())
+	if err := s.syncCache(cfg, file); err != nil {
+		logf("cache: %v", err)
+	}
+	return nil
+}
+
+// xsrfUser returns the user identifier with which XSRF tokens are generated
+// and checked. It names the active profile as well as its user, so that
+// tokens issued under one profile are not accepted under another.
+func (s *server) xsrfUser() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profile + ":" + string(s.cfg.UserName())
+}
@@ -0,0 +1,423 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"augie.upspin.io/uiclient"
+
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/flags"
+	"upspin.io/upspin"
+)
+
+// TestProfiles writes config files for three users of the in-process
+// servers, one a server user of another, alongside some files that are not
+// usable profiles. It checks that the API lists the profiles and switches
+// between them, that requests may be made as the server user, that file
+// tokens issued under one profile or user are rejected under another, that
+// the active config file may be edited, and that the cache may be turned on
+// and off and flushed.
+func TestProfiles(t *testing.T) {
+	home, restore := tempHome(t)
+	defer restore()
+	oldCacheDir := flags.CacheDir
+	defer func() { flags.CacheDir = oldCacheDir }()
+	flags.Config = filepath.Join(home, "upspin", "config")
+	flags.CacheDir = filepath.Join(home, "cache")
+	if err := os.MkdirAll(filepath.Dir(flags.Config), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	users := map[string]upspin.UserName{
+		defaultProfile: "pat@example.com",
+		"server":       "pat+server@example.com",
+		"team":         "team@example.com",
+	}
+	for name, user := range users {
+		if err := makeProfile(profileFile(name), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Neither of these is a usable profile.
+	if err := ioutil.WriteFile(flags.Config+".gcpState", []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(flags.Config+".broken", []byte("username: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Don't start a real cacheserver.
+	s.cache.command = filepath.Join(home, "no-cacheserver")
+	if _, _, err := s.startup(&startupRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := uiclient.New(ts.URL, s.key)
+
+	// Lines that the config editor must keep.
+	extra := "# A comment.\neditor: vi\n"
+	if err := appendFile(flags.Config, extra); err != nil {
+		t.Fatal(err)
+	}
+
+	root := upspin.PathName(users[defaultProfile] + "/")
+	serverRoot := upspin.PathName(users["server"] + "/")
+	content := []byte("profile content\n")
+	var file, serverFile *uiclient.Entry
+	var cf *uiclient.ConfigFile
+	runSteps(t, []testStep{
+		{"list", func() error {
+			ps, err := c.Profiles()
+			if err != nil {
+				return err
+			}
+			// Mark the active profile with * and broken ones with !.
+			var got []string
+			for _, p := range ps {
+				desc := p.Name
+				if p.Active {
+					desc += "*"
+				}
+				if p.Error != "" {
+					desc += "!"
+				}
+				got = append(got, desc)
+			}
+			if want := "default* broken! server team"; strings.Join(got, " ") != want {
+				return errors.Errorf("got profiles %q, want %q", strings.Join(got, " "), want)
+			}
+			return nil
+		}},
+		{"upload to default", func() error {
+			if err := c.Put(root, "file.txt", bytes.NewReader(content)); err != nil {
+				return err
+			}
+			entries, err := c.List(root)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if e.Name == root+"file.txt" {
+					file = e
+				}
+			}
+			if file == nil {
+				return errors.E(root+"file.txt", errors.NotExist)
+			}
+			return nil
+		}},
+		{"server users", func() error {
+			r, err := c.Startup(nil)
+			if err != nil {
+				return err
+			}
+			if len(r.ServerUsers) != 1 || r.ServerUsers[0] != users["server"] {
+				return errors.Errorf("got server users %q, want %q", r.ServerUsers, users["server"])
+			}
+			return nil
+		}},
+		{"upload as server user", func() error {
+			sc := c.As(users["server"])
+			if err := sc.Put(serverRoot, "Access", strings.NewReader("*: pat+server@example.com\n")); err != nil {
+				return err
+			}
+			entries, err := sc.List(serverRoot)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if e.Name == serverRoot+"Access" {
+					serverFile = e
+				}
+			}
+			if serverFile == nil {
+				return errors.E(serverRoot+"Access", errors.NotExist)
+			}
+			if serverFile.Writer != users["server"] {
+				return errors.Errorf("got writer %q, want %q", serverFile.Writer, users["server"])
+			}
+			return expectContent(sc, serverRoot+"Access", []byte("*: pat+server@example.com\n"))
+		}},
+		{"server user token as current user", func() error {
+			_, err := c.Get(serverFile)
+			if e, ok := err.(*uiclient.Error); !ok || e.StatusCode != http.StatusForbidden {
+				return errors.Errorf("got error %v, want status %d", err, http.StatusForbidden)
+			}
+			return nil
+		}},
+		{"act as other user", func() error {
+			_, err := c.As(users["team"]).List(upspin.PathName(users["team"] + "/"))
+			return expectError(err, "Permission")
+		}},
+		{"act as server user on other route", func() error {
+			_, err := c.As(users["server"]).Profiles()
+			return expectError(err, "Invalid")
+		}},
+		{"config read", func() error {
+			var err error
+			cf, err = c.Config()
+			if err != nil {
+				return err
+			}
+			if cf.File != flags.Config {
+				return errors.Errorf("got file %q, want %q", cf.File, flags.Config)
+			}
+			if cf.Fields.UserName != users[defaultProfile] || cf.Fields.Packing != "ee" {
+				return errors.Errorf("got fields %+v", cf.Fields)
+			}
+			if strings.Join(cf.Other, " ") != "editor" {
+				return errors.Errorf("got other keys %q, want %q", cf.Other, "editor")
+			}
+			return nil
+		}},
+		{"config invalid packing", func() error {
+			b, err := ioutil.ReadFile(flags.Config)
+			if err != nil {
+				return err
+			}
+			fields := cf.Fields
+			fields.Packing = "bogus"
+			if err := expectError(updateConfig(c, fields, cf.Checksum), "Invalid"); err != nil {
+				return err
+			}
+			return expectFile(flags.Config, string(b))
+		}},
+		{"config change user name", func() error {
+			fields := cf.Fields
+			fields.UserName = users["team"]
+			return expectError(updateConfig(c, fields, cf.Checksum), "Invalid")
+		}},
+		{"config update", func() error {
+			old, err := ioutil.ReadFile(flags.Config)
+			if err != nil {
+				return err
+			}
+			fields := cf.Fields
+			fields.Packing = "eeintegrity"
+			next, err := c.UpdateConfig(fields, cf.Checksum)
+			if err != nil {
+				return err
+			}
+			if next.Fields.Packing != "eeintegrity" || next.Checksum == cf.Checksum {
+				return errors.Errorf("got %+v after update", next)
+			}
+			b, err := ioutil.ReadFile(flags.Config)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(string(b), extra) || !strings.Contains(string(b), "packing: eeintegrity\n") {
+				return errors.Errorf("got config file:\n%s", b)
+			}
+			if err := expectFile(flags.Config+configBackupSuffix, string(old)); err != nil {
+				return err
+			}
+			r, err := c.Startup(nil)
+			if err != nil {
+				return err
+			}
+			if r.UserName != users[defaultProfile] {
+				return errors.Errorf("got user %q, want %q", r.UserName, users[defaultProfile])
+			}
+			return nil
+		}},
+		{"config stale checksum", func() error {
+			return expectError(updateConfig(c, cf.Fields, cf.Checksum), "Exist")
+		}},
+		{"config backup is not a profile", func() error {
+			ps, err := c.Profiles()
+			if err != nil {
+				return err
+			}
+			for _, p := range ps {
+				if strings.HasSuffix(p.File, configBackupSuffix) {
+					return errors.Errorf("backup %s listed as profile %q", p.File, p.Name)
+				}
+			}
+			return nil
+		}},
+		{"cache status", func() error {
+			st, err := c.CacheStatus()
+			if err != nil {
+				return err
+			}
+			if st.Enabled || st.Managed || st.Files != 0 {
+				return errors.Errorf("got cache status %+v, want disabled and empty", st)
+			}
+			return nil
+		}},
+		{"cache enable", func() error {
+			st, err := c.SetCache(true)
+			if err != nil {
+				return err
+			}
+			if !st.Enabled {
+				return errors.Errorf("got cache status %+v, want enabled", st)
+			}
+			if !st.Running && st.Error == "" {
+				return errors.Errorf("got cache status %+v, want an error starting the cacheserver", st)
+			}
+			b, err := ioutil.ReadFile(flags.Config)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(string(b), "cache: yes\n") {
+				return errors.Errorf("got config file:\n%s", b)
+			}
+			return nil
+		}},
+		{"cache size", func() error {
+			dir := filepath.Join(cacheDir(users[defaultProfile]), "storecache")
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return err
+			}
+			for _, name := range []string{"a", "b"} {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+					return err
+				}
+			}
+			st, err := c.CacheStatus()
+			if err != nil {
+				return err
+			}
+			if st.Files != 2 || st.Size != int64(2*len(content)) {
+				return errors.Errorf("got %d files of %d bytes, want 2 of %d", st.Files, st.Size, 2*len(content))
+			}
+			return nil
+		}},
+		{"cache disable", func() error {
+			st, err := c.SetCache(false)
+			if err != nil {
+				return err
+			}
+			if st.Enabled {
+				return errors.Errorf("got cache status %+v, want disabled", st)
+			}
+			b, err := ioutil.ReadFile(flags.Config)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(string(b), "cache:") {
+				return errors.Errorf("got config file:\n%s", b)
+			}
+			return nil
+		}},
+		{"cache restart when disabled", func() error {
+			_, err := c.RestartCache()
+			return expectError(err, "Invalid")
+		}},
+		{"cache flush", func() error {
+			st, err := c.FlushCache()
+			if err != nil {
+				return err
+			}
+			if st.Files != 0 || exists(st.Dir) {
+				return errors.Errorf("got cache status %+v after flush", st)
+			}
+			return nil
+		}},
+		{"switch", func() error {
+			if err := c.SwitchProfile("team"); err != nil {
+				return err
+			}
+			r, err := c.Startup(nil)
+			if err != nil {
+				return err
+			}
+			if r.UserName != users["team"] {
+				return errors.Errorf("got user %q, want %q", r.UserName, users["team"])
+			}
+			return nil
+		}},
+		{"token from other profile", func() error {
+			_, err := c.Get(file)
+			if e, ok := err.(*uiclient.Error); !ok || e.StatusCode != http.StatusForbidden {
+				return errors.Errorf("got error %v, want status %d", err, http.StatusForbidden)
+			}
+			return nil
+		}},
+		{"switch to broken profile", func() error {
+			return expectError(c.SwitchProfile("broken"), "Invalid")
+		}},
+		{"switch to missing profile", func() error {
+			return expectError(c.SwitchProfile("missing"), "NotExist")
+		}},
+		{"switch back", func() error {
+			if err := c.SwitchProfile(defaultProfile); err != nil {
+				return err
+			}
+			return expectContent(c, root+"file.txt", content)
+		}},
+	})
+}
+
+// updateConfig calls c.UpdateConfig, returning only its error.
+func updateConfig(c *uiclient.Client, fields uiclient.ConfigFields, checksum string) error {
+	_, err := c.UpdateConfig(fields, checksum)
+	return err
+}
+
+// appendFile appends s to the named file.
+func appendFile(file, s string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(s)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// expectFile checks that the named file holds the given contents.
+func expectFile(file, want string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if string(b) != want {
+		return errors.Errorf("%s holds %q, want %q", file, b, want)
+	}
+	return nil
+}
+
+// makeProfile writes a config file for the given user of the in-process
+// servers, generates keys for the user in their default key directory,
+// and registers the user and creates their root.
+func makeProfile(file string, user upspin.UserName) error {
+	ks := keyServerSettings{Endpoint: inProcess}
+	if err := writeConfig(file, user, ks, inProcess, inProcess, false); err != nil {
+		return err
+	}
+	if _, _, err := genkey(user); err != nil {
+		return err
+	}
+	cfg, err := config.FromFile(file)
+	if err != nil {
+		return err
+	}
+	if err := registerInProcess(cfg); err != nil {
+		return err
+	}
+	if err := putUser(cfg, nil); err != nil {
+		return err
+	}
+	return makeRoot(cfg)
+}
@@ -19,7 +19,8 @@ func (s *server) put(dir upspin.PathName, fh *multipart.FileHeader) error {
 	if err != nil {
 		return err
 	}
-	dst, err := s.cli.Create(path.Join(dir, fh.Filename))
+	_, cli := s.client()
+	dst, err := cli.Create(path.Join(dir, fh.Filename))
 	if err != nil {
 		return err
 	}
@@ -6,20 +6,20 @@ package main
 
 import "upspin.io/upspin"
 
-// rm recursively removes the given path.
-func (s *server) rm(name upspin.PathName) error {
-	de, err := s.cli.Lookup(name, false)
+// rm recursively removes the given path using the given client.
+func rm(cli upspin.Client, name upspin.PathName) error {
+	de, err := cli.Lookup(name, false)
 	if err != nil {
 		return err
 	}
-	return s.rmEntry(de)
+	return rmEntry(cli, de)
 }
 
-// rmEntry removes the given entry. If the entry is a directory it removes its
-// contents before removing the directory itself.
-func (s *server) rmEntry(de *upspin.DirEntry) error {
+// rmEntry removes the given entry using the given client. If the entry is a
+// directory it removes its contents before removing the directory itself.
+func rmEntry(cli upspin.Client, de *upspin.DirEntry) error {
 	if de.IsDir() {
-		dir, err := s.cli.DirServer(de.Name)
+		dir, err := cli.DirServer(de.Name)
 		if err != nil {
 			return err
 		}
@@ -28,10 +28,10 @@ func (s *server) rmEntry(de *upspin.DirEntry) error {
 			return err
 		}
 		for _, de := range des {
-			if err := s.rmEntry(de); err != nil {
+			if err := rmEntry(cli, de); err != nil {
 				return err
 			}
 		}
 	}
-	return s.cli.Delete(de.Name)
+	return cli.Delete(de.Name)
 }
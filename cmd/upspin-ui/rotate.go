@@ -0,0 +1,405 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"upspin.io/access"
+	"upspin.io/bind"
+	"upspin.io/client"
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/factotum"
+	"upspin.io/key/keygen"
+	"upspin.io/key/usercache"
+	"upspin.io/pack"
+	"upspin.io/upspin"
+	"upspin.io/user"
+)
+
+// rotateStatus describes the progress of a key rotation.
+type rotateStatus struct {
+	// KeyDir and SecretSeed describe the new keys. SecretSeed is
+	// returned only by the request that rotates the keys.
+	KeyDir     string
+	SecretSeed string `json:",omitempty"`
+
+	// Rewrap reports whether the user's files are being re-wrapped
+	// for the new key.
+	Rewrap bool
+
+	// Done is set once re-wrapping is complete, or has failed.
+	Done bool
+
+	// Files is the number of encrypted files found so far,
+	// and Rewrapped the number of those that have been re-wrapped.
+	Files     int
+	Rewrapped int
+
+	// Failed lists the files that could not be re-wrapped.
+	Failed []upspin.PathName `json:",omitempty"`
+
+	// Error is set if re-wrapping was abandoned.
+	Error string `json:",omitempty"`
+
+	// Warning describes a step of the rotation that failed after the new
+	// key was registered, and which the user should complete by hand.
+	Warning string `json:",omitempty"`
+}
+
+// rotation tracks a key rotation started by rotateKeys.
+type rotation struct {
+	mu     sync.Mutex
+	status rotateStatus
+}
+
+func (r *rotation) get() rotateStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.status
+	st.Failed = append([]upspin.PathName(nil), st.Failed...)
+	return st
+}
+
+func (r *rotation) update(fn func(st *rotateStatus)) {
+	r.mu.Lock()
+	fn(&r.status)
+	r.mu.Unlock()
+}
+
+// rotationStatus returns the status of the most recent key rotation.
+func (s *server) rotationStatus() (rotateStatus, error) {
+	s.mu.Lock()
+	r := s.rotation
+	s.mu.Unlock()
+	if r == nil {
+		return rotateStatus{}, errors.E(errors.NotExist, errors.Str("no key rotation has been started"))
+	}
+	return r.get(), nil
+}
+
+// rotateKeys generates a new key pair for the current user, archiving the old
+// secret key in the key directory so that existing files may still be read,
+// and registers the new public key with the key server. If rewrap is set,
+// it then re-wraps the user's encrypted files for the new key in the
+// background; the progress may be followed with rotationStatus.
+func (s *server) rotateKeys(rewrap bool) (rotateStatus, error) {
+	// Reserve the rotation before touching the key files,
+	// so that concurrent requests cannot rotate the keys twice.
+	r := &rotation{status: rotateStatus{Rewrap: rewrap}}
+	s.mu.Lock()
+	cfg, prev := s.cfg, s.rotation
+	if prev != nil && !prev.get().Done {
+		s.mu.Unlock()
+		return rotateStatus{}, errors.E(errors.Exist, errors.Str("a key rotation is already in progress"))
+	}
+	s.rotation = r
+	s.mu.Unlock()
+
+	seed, err := s.registerNewKeys(cfg, r)
+	if err != nil {
+		s.mu.Lock()
+		if s.rotation == r {
+			s.rotation = prev
+		}
+		s.mu.Unlock()
+		return rotateStatus{}, err
+	}
+	st := r.get()
+	st.SecretSeed = seed
+	return st, nil
+}
+
+// registerNewKeys does the work of rotateKeys for the user in cfg, recording
+// its progress in r, and returns the secret seed of the new keys.
+func (s *server) registerNewKeys(cfg upspin.Config, r *rotation) (string, error) {
+	keyDir := cfg.Value("secrets")
+	if keyDir == "none" {
+		return "", errors.E(cfg.UserName(), errors.Invalid, errors.Str("config has no keys to rotate"))
+	}
+	if keyDir == "" {
+		var err error
+		keyDir, err = config.DefaultSecretsDir(cfg.UserName())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Keep the existing key files so that they can be restored
+	// if the key server does not accept the new key.
+	restore, err := saveKeyFiles(keyDir)
+	if err != nil {
+		return "", err
+	}
+	pub, priv, seed, err := keygen.Generate("p256")
+	if err != nil {
+		return "", err
+	}
+	// Passing rotate=true appends the old secret key to secret2.upspinkey.
+	if err := keygen.SaveKeys(keyDir, true, pub, priv, seed); err != nil {
+		restore()
+		return "", err
+	}
+	f, err := factotum.NewFromDir(keyDir)
+	if err != nil {
+		restore()
+		return "", err
+	}
+	newCfg := config.SetFactotum(cfg, f)
+
+	// Authenticate with the old key to register the new one.
+	if err := putUser(cfg, newCfg); err != nil {
+		restore()
+		return "", err
+	}
+	usercache.ResetGlobal() // Forget the old key.
+
+	// The key server now accepts only the new key,
+	// so use it from here on whatever else happens.
+	rewrap := r.get().Rewrap
+	r.update(func(st *rotateStatus) {
+		st.KeyDir = keyDir
+		st.Done = !rewrap
+	})
+	cli := client.New(newCfg)
+	s.mu.Lock()
+	if s.cfg == cfg {
+		s.cfg = newCfg
+		s.cli = cli
+	}
+	s.mu.Unlock()
+
+	if err := rotateSnapshotKey(newCfg); err != nil {
+		logf("rotate: registering the new key for the snapshot user: %v", err)
+		r.update(func(st *rotateStatus) {
+			st.Warning = "Your new key was registered, but not for your snapshot user: " + err.Error()
+		})
+	}
+
+	if rewrap {
+		go rewrapTree(newCfg, cli, r)
+	}
+	return seed, nil
+}
+
+// rotateSnapshotKey registers the new key for the user's snapshot user,
+// if one is registered.
+func rotateSnapshotKey(cfg upspin.Config) error {
+	name, _, domain, err := user.Parse(cfg.UserName())
+	if err != nil {
+		return err
+	}
+	_, err = lookupUserWith(cfg, upspin.UserName(name+"+snapshot@"+domain))
+	if errors.Match(errors.E(errors.NotExist), err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return putSnapshotUser(cfg)
+}
+
+// lookupUserWith returns the KeyServer record for the given user,
+// using the key server in cfg.
+func lookupUserWith(cfg upspin.Config, name upspin.UserName) (*upspin.User, error) {
+	key, err := bind.KeyServer(cfg, cfg.KeyEndpoint())
+	if err != nil {
+		return nil, err
+	}
+	return key.Lookup(name)
+}
+
+// saveKeyFiles reads the key files in keyDir and returns a function that
+// restores them to their current state.
+func saveKeyFiles(keyDir string) (restore func(), err error) {
+	names := []string{"public.upspinkey", "secret.upspinkey", "secret2.upspinkey"}
+	saved := make(map[string][]byte)
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(keyDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		saved[name] = b
+	}
+	if saved["secret.upspinkey"] == nil {
+		return nil, errors.E(errors.NotExist, errors.Errorf("no secret key in %s", keyDir))
+	}
+	return func() {
+		for _, name := range names {
+			file := filepath.Join(keyDir, name)
+			b, ok := saved[name]
+			if !ok {
+				os.Remove(file)
+				continue
+			}
+			mode := os.FileMode(0600)
+			if name == "public.upspinkey" {
+				mode = 0644
+			}
+			ioutil.WriteFile(file, b, mode)
+		}
+	}, nil
+}
+
+// rewrapper re-wraps the keys of encrypted files for their readers' current
+// public keys, as "upspin share -fix" does.
+type rewrapper struct {
+	cfg upspin.Config
+	cli upspin.Client
+	r   *rotation
+
+	keys    map[upspin.UserName]upspin.PublicKey
+	readers map[upspin.PathName][]upspin.PublicKey // Keyed by Access file.
+}
+
+// rewrapTree re-wraps the encrypted files in the tree of the user in cfg,
+// recording its progress in r.
+func rewrapTree(cfg upspin.Config, cli upspin.Client, r *rotation) {
+	w := &rewrapper{
+		cfg:     cfg,
+		cli:     cli,
+		r:       r,
+		keys:    make(map[upspin.UserName]upspin.PublicKey),
+		readers: make(map[upspin.PathName][]upspin.PublicKey),
+	}
+	err := w.rewrapDir(upspin.PathName(cfg.UserName()))
+	r.update(func(st *rotateStatus) {
+		st.Done = true
+		if err != nil {
+			st.Error = err.Error()
+		}
+	})
+}
+
+// rewrapDir re-wraps the encrypted files in the given directory and its
+// sub-directories. Links are not followed.
+func (w *rewrapper) rewrapDir(name upspin.PathName) error {
+	dir, err := w.cli.DirServer(name)
+	if err != nil {
+		return err
+	}
+	des, err := dir.Glob(upspin.AllFilesGlob(name))
+	if err != nil && err != upspin.ErrFollowLink {
+		return err
+	}
+	for _, de := range des {
+		switch {
+		case de.IsDir():
+			if err := w.rewrapDir(de.Name); err != nil {
+				return err
+			}
+		case de.IsLink(), de.Packing != upspin.EEPack:
+			// Only EE-packed files have keys wrapped for readers.
+		default:
+			w.r.update(func(st *rotateStatus) { st.Files++ })
+			err := w.rewrapFile(dir, de)
+			w.r.update(func(st *rotateStatus) {
+				if err != nil {
+					st.Failed = append(st.Failed, de.Name)
+				} else {
+					st.Rewrapped++
+				}
+			})
+		}
+	}
+	return nil
+}
+
+// rewrapFile re-wraps the file key of the given entry for its readers.
+func (w *rewrapper) rewrapFile(dir upspin.DirServer, de *upspin.DirEntry) error {
+	packer := pack.Lookup(de.Packing)
+	if packer == nil {
+		return errors.E(de.Name, errors.Invalid, errors.Errorf("unknown packing %s", de.Packing))
+	}
+	readers, err := w.readersOf(dir, de.Name)
+	if err != nil {
+		return err
+	}
+	packdata := []*[]byte{&de.Packdata}
+	packer.Share(w.cfg, readers, packdata)
+	if packdata[0] == nil {
+		return errors.E(de.Name, errors.CannotDecrypt, errors.Str("could not re-wrap file key"))
+	}
+	_, err = dir.Put(de)
+	return err
+}
+
+// readersOf returns the public keys of the users who may read the named
+// file, always including the current user.
+func (w *rewrapper) readersOf(dir upspin.DirServer, name upspin.PathName) ([]upspin.PublicKey, error) {
+	acc, err := dir.WhichAccess(name)
+	if err != nil {
+		return nil, err
+	}
+	var accName upspin.PathName
+	if acc != nil {
+		accName = acc.Name
+	}
+	if readers, ok := w.readers[accName]; ok {
+		return readers, nil
+	}
+
+	users := []upspin.UserName{w.cfg.UserName()}
+	if acc != nil {
+		data, err := w.cli.Get(accName)
+		if err != nil {
+			return nil, err
+		}
+		a, err := access.Parse(accName, data)
+		if err != nil {
+			return nil, err
+		}
+		more, err := a.Users(access.Read, w.cli.Get)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, more...)
+	}
+
+	var readers []upspin.PublicKey
+	seen := make(map[upspin.UserName]bool)
+	for _, u := range users {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		if u == access.AllUsers {
+			// The client wraps keys for "all" with this well-known key.
+			readers = append(readers, upspin.AllUsersKey)
+			continue
+		}
+		k, err := w.publicKey(u)
+		if err != nil {
+			// The user may not exist; they can't read the file anyway.
+			continue
+		}
+		readers = append(readers, k)
+	}
+	w.readers[accName] = readers
+	return readers, nil
+}
+
+// publicKey returns the public key of the given user.
+func (w *rewrapper) publicKey(name upspin.UserName) (upspin.PublicKey, error) {
+	if name == w.cfg.UserName() {
+		return w.cfg.Factotum().PublicKey(), nil
+	}
+	if k, ok := w.keys[name]; ok {
+		return k, nil
+	}
+	u, err := lookupUserWith(w.cfg, name)
+	if err != nil {
+		return "", err
+	}
+	w.keys[name] = u.PublicKey
+	return u.PublicKey, nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"upspin.io/client"
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/factotum"
+	"upspin.io/flags"
+	"upspin.io/key/usercache"
+	"upspin.io/upspin"
+)
+
+// TestRotate rotates the keys of a user of the in-process servers whose
+// files are shared with another user and with all users. It checks that
+// the old key files are restored if the key server cannot be reached, that
+// the old key is archived so that files wrapped for it can still be read,
+// and that re-wrapped files can be read with the new key alone, by the
+// other reader, and by all users.
+func TestRotate(t *testing.T) {
+	home, restore := tempHome(t)
+	defer restore()
+	flags.Config = filepath.Join(home, "upspin", "config")
+	if err := os.MkdirAll(filepath.Dir(flags.Config), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		user   = "rotate@example.com"
+		reader = "rotate-reader@example.com"
+		root   = upspin.PathName(user + "/")
+	)
+	if err := makeProfile(flags.Config, user); err != nil {
+		t.Fatal(err)
+	}
+	readerCfg, err := inProcessConfig(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := putUser(readerCfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	readerCli := client.New(readerCfg)
+
+	s, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.startup(&startupRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	oldCfg, cli := s.client()
+	keyDir, err := keyDirOf(oldCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := putSnapshotUser(oldCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[upspin.PathName][]byte{
+		root + "private.txt":       []byte("for my eyes only\n"),
+		root + "shared/file.txt":   []byte("for the reader too\n"),
+		root + "public/anyone.txt": []byte("for everyone\n"),
+	}
+	puts := []struct {
+		name upspin.PathName
+		data []byte
+	}{
+		{root + "shared/Access", []byte("*: " + user + "\nr: " + reader + "\n")},
+		{root + "public/Access", []byte("*: " + user + "\nr: all\n")},
+	}
+	for _, dir := range []upspin.PathName{root + "shared", root + "public"} {
+		if _, err := cli.MakeDirectory(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range puts {
+		if _, err := cli.Put(p.name, p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range files {
+		if _, err := cli.Put(name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var st rotateStatus
+	runSteps(t, []testStep{
+		{"key server failure", func() error {
+			before, err := readKeyFiles(keyDir)
+			if err != nil {
+				return err
+			}
+			bad := upspin.Endpoint{Transport: upspin.Remote, NetAddr: "127.0.0.1:1"}
+			s.mu.Lock()
+			s.cfg = config.SetKeyEndpoint(oldCfg, bad)
+			s.mu.Unlock()
+			_, err = s.rotateKeys(false)
+			s.mu.Lock()
+			s.cfg = oldCfg
+			s.mu.Unlock()
+			if err == nil {
+				return errors.Str("rotation succeeded with an unreachable key server")
+			}
+			after, err := readKeyFiles(keyDir)
+			if err != nil {
+				return err
+			}
+			if after != before {
+				return errors.Errorf("key files changed by failed rotation:\n%s\nwant:\n%s", after, before)
+			}
+			if _, err := s.rotationStatus(); !errors.Match(errors.E(errors.NotExist), err) {
+				return errors.Errorf("status after failed rotation: got error %v, want NotExist", err)
+			}
+			return expectRegisteredKey(oldCfg, user, oldCfg.Factotum().PublicKey())
+		}},
+		{"rotation in progress", func() error {
+			s.mu.Lock()
+			s.rotation = &rotation{}
+			s.mu.Unlock()
+			_, err := s.rotateKeys(false)
+			s.mu.Lock()
+			s.rotation = nil
+			s.mu.Unlock()
+			if !errors.Match(errors.E(errors.Exist), err) {
+				return errors.Errorf("got error %v, want Exist", err)
+			}
+			return nil
+		}},
+		{"rotate", func() error {
+			oldSecret, err := ioutil.ReadFile(filepath.Join(keyDir, "secret.upspinkey"))
+			if err != nil {
+				return err
+			}
+			st, err = s.rotateKeys(false)
+			if err != nil {
+				return err
+			}
+			if !st.Done {
+				return errors.Errorf("got status %+v, want done", st)
+			}
+			if st.SecretSeed == "" || st.KeyDir != keyDir {
+				return errors.Errorf("got status %+v, want a secret seed and key directory %s", st, keyDir)
+			}
+			if st.Warning != "" {
+				return errors.Errorf("got warning %q", st.Warning)
+			}
+			archive, err := ioutil.ReadFile(filepath.Join(keyDir, "secret2.upspinkey"))
+			if err != nil {
+				return err
+			}
+			old := strings.TrimSpace(strings.SplitN(string(oldSecret), "#", 2)[0])
+			if !bytes.Contains(archive, []byte(old)) {
+				return errors.Errorf("secret2.upspinkey does not hold the old secret key")
+			}
+			newCfg, _ := s.client()
+			if newCfg.Factotum().PublicKey() == oldCfg.Factotum().PublicKey() {
+				return errors.Str("the active config still has the old key")
+			}
+			if err := expectRegisteredKey(newCfg, user, newCfg.Factotum().PublicKey()); err != nil {
+				return err
+			}
+			return expectRegisteredKey(newCfg, "rotate+snapshot@example.com", newCfg.Factotum().PublicKey())
+		}},
+		{"read with archived key", func() error {
+			_, cli := s.client()
+			return expectFiles(cli, files)
+		}},
+		{"rotate and rewrap", func() error {
+			var err error
+			st, err = s.rotateKeys(true)
+			if err != nil {
+				return err
+			}
+			if !st.Rewrap || st.SecretSeed == "" {
+				return errors.Errorf("got status %+v, want re-wrapping and a secret seed", st)
+			}
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				var err error
+				st, err = s.rotationStatus()
+				if err != nil {
+					return err
+				}
+				if st.Done {
+					break
+				}
+				if time.Now().After(deadline) {
+					return errors.Errorf("re-wrapping did not finish: %+v", st)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if st.SecretSeed != "" {
+				return errors.Str("status reports the secret seed")
+			}
+			// Only the EE-packed files are counted; Access files are not.
+			if st.Error != "" || len(st.Failed) > 0 || st.Rewrapped != st.Files || st.Rewrapped < len(files) {
+				return errors.Errorf("got status %+v, want at least %d files re-wrapped", st, len(files))
+			}
+			return nil
+		}},
+		{"read with new key only", func() error {
+			pub, err := ioutil.ReadFile(filepath.Join(keyDir, "public.upspinkey"))
+			if err != nil {
+				return err
+			}
+			priv, err := ioutil.ReadFile(filepath.Join(keyDir, "secret.upspinkey"))
+			if err != nil {
+				return err
+			}
+			f, err := factotum.NewFromKeys(pub, priv, nil)
+			if err != nil {
+				return err
+			}
+			newCfg, _ := s.client()
+			return expectFiles(client.New(config.SetFactotum(newCfg, f)), files)
+		}},
+		{"read as other reader", func() error {
+			return expectFiles(readerCli, map[upspin.PathName][]byte{
+				root + "shared/file.txt":   files[root+"shared/file.txt"],
+				root + "public/anyone.txt": files[root+"public/anyone.txt"],
+			})
+		}},
+		{"other reader denied private file", func() error {
+			if _, err := readerCli.Get(root + "private.txt"); err == nil {
+				return errors.Str("other reader read the private file")
+			}
+			return nil
+		}},
+	})
+}
+
+// readKeyFiles returns the contents of the key files in keyDir,
+// for comparison.
+func readKeyFiles(keyDir string) (string, error) {
+	var b strings.Builder
+	for _, name := range []string{"public.upspinkey", "secret.upspinkey", "secret2.upspinkey"} {
+		data, err := ioutil.ReadFile(filepath.Join(keyDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(name + ":\n" + string(data))
+	}
+	return b.String(), nil
+}
+
+// expectRegisteredKey checks that the key server in cfg has the given
+// public key for the named user.
+func expectRegisteredKey(cfg upspin.Config, name upspin.UserName, want upspin.PublicKey) error {
+	usercache.ResetGlobal()
+	u, err := lookupUserWith(cfg, name)
+	if err != nil {
+		return err
+	}
+	if u.PublicKey != want {
+		return errors.Errorf("key server has key %q for %s, want %q", u.PublicKey, name, want)
+	}
+	return nil
+}
+
+// expectFiles checks that each of the named files, read with cli, holds
+// the given data.
+func expectFiles(cli upspin.Client, files map[upspin.PathName][]byte) error {
+	for name, want := range files {
+		got, err := cli.Get(name)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want) {
+			return errors.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+	return nil
+}
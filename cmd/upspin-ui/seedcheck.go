@@ -0,0 +1,209 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/key/keygen"
+	"upspin.io/upspin"
+)
+
+// seedWordsToConfirm is the number of words of a secret seed that the user
+// must type back to show that they wrote it down.
+const seedWordsToConfirm = 3
+
+// seedChallenge asks the user to type back randomly chosen words of a newly
+// generated secret seed before startup moves on.
+type seedChallenge struct {
+	user   upspin.UserName
+	keyDir string
+	step   string // The step that displays the seed.
+	words  []int  // Positions, counting from 1, of the words to type back.
+}
+
+// response returns the step that presents the challenge: "confirmSeed"
+// for the user's seed, and "confirmServerSeed" for the server user's.
+func (c *seedChallenge) response() *startupResponse {
+	step := "confirmSeed"
+	if c.step == "serverSecretSeed" {
+		step = "confirmServerSeed"
+	}
+	return &startupResponse{
+		Step:      step,
+		UserName:  c.user,
+		KeyDir:    c.keyDir,
+		SeedWords: c.words,
+	}
+}
+
+// challengeSeed asks the user to confirm the secret seed of the given user,
+// whose keys are in keyDir and whose seed is displayed by the given step,
+// choosing new words to type back.
+func (s *server) challengeSeed(user upspin.UserName, keyDir, step string) (*seedChallenge, error) {
+	seed, err := readSecretSeed(keyDir)
+	if err != nil {
+		return nil, err
+	}
+	words, err := chooseWords(len(seedWords(seed)), seedWordsToConfirm)
+	if err != nil {
+		return nil, err
+	}
+	c := &seedChallenge{
+		user:   user,
+		keyDir: keyDir,
+		step:   step,
+		words:  words,
+	}
+	s.mu.Lock()
+	s.seedCheck = c
+	s.mu.Unlock()
+	return c, nil
+}
+
+// pendingSeedCheck returns the unanswered seed challenge, if any.
+func (s *server) pendingSeedCheck() *seedChallenge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seedCheck
+}
+
+// confirmSeed checks the user's answer to the pending seed challenge and,
+// if it is right, clears the challenge and returns it. Wrong answers are
+// logged without the words that were typed.
+func (s *server) confirmSeed(answer []string) (*seedChallenge, error) {
+	c := s.pendingSeedCheck()
+	if c == nil {
+		return nil, errors.E(errors.NotExist, errors.Str("no secret seed is awaiting confirmation"))
+	}
+	seed, err := readSecretSeed(c.keyDir)
+	if err != nil {
+		return nil, err
+	}
+	words := seedWords(seed)
+	var wrong []string
+	for i, pos := range c.words {
+		got := ""
+		if i < len(answer) {
+			got = strings.ToLower(strings.TrimSpace(answer[i]))
+		}
+		if got != words[pos-1] {
+			wrong = append(wrong, fmt.Sprintf("word %d: REDACTED (%d letters)", pos, len(got)))
+		}
+	}
+	if len(wrong) > 0 {
+		logf("startup: wrong answer to the secret seed challenge for %s: %s", c.user, strings.Join(wrong, ", "))
+		return nil, errors.E(c.user, errors.Invalid, errors.Str("those are not the right words of your secret seed; check what you wrote down, or show the seed again"))
+	}
+	s.mu.Lock()
+	if s.seedCheck == c {
+		s.seedCheck = nil
+	}
+	s.mu.Unlock()
+	logf("startup: %s confirmed their secret seed", c.user)
+	return c, nil
+}
+
+// showSeed returns the step that displays the secret seed of the given
+// user, read from the key files in keyDir, and asks the user to confirm it
+// afresh once they have written it down.
+func (s *server) showSeed(user upspin.UserName, keyDir, step string) (*startupResponse, error) {
+	seed, err := readSecretSeed(keyDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.challengeSeed(user, keyDir, step); err != nil {
+		return nil, err
+	}
+	return &startupResponse{
+		Step:       step,
+		KeyDir:     keyDir,
+		SecretSeed: seed,
+		UserName:   user,
+	}, nil
+}
+
+// readSecretSeed returns the secret seed recorded, after a '#', in the
+// secret key file in keyDir. It checks that the seed generates the public
+// key in the same directory.
+func readSecretSeed(keyDir string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(keyDir, "secret.upspinkey"))
+	if os.IsNotExist(err) {
+		return "", errors.E(errors.NotExist, errors.Errorf("no secret key in %s", keyDir))
+	}
+	if err != nil {
+		return "", err
+	}
+	line := strings.SplitN(string(b), "\n", 2)[0]
+	i := strings.Index(line, "#")
+	if i < 0 {
+		return "", errors.E(errors.NotExist, errors.Errorf("the secret seed is not recorded in %s", keyDir))
+	}
+	seed := strings.TrimSpace(line[i+1:])
+	pub, _, err := keygen.FromSecret("p256", seed)
+	if err != nil {
+		return "", errors.E(errors.Invalid, errors.Errorf("invalid secret seed in %s: %v", keyDir, err))
+	}
+	b, err = ioutil.ReadFile(filepath.Join(keyDir, "public.upspinkey"))
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(pub) != strings.TrimSpace(string(b)) {
+		return "", errors.E(errors.Invalid, errors.Errorf("the secret seed in %s does not match the public key", keyDir))
+	}
+	return seed, nil
+}
+
+// keyDirOf returns the directory that holds the keys of the user in cfg.
+func keyDirOf(cfg upspin.Config) (string, error) {
+	keyDir := cfg.Value("secrets")
+	if keyDir == "none" {
+		return "", errors.E(cfg.UserName(), errors.NotExist, errors.Str("config has no keys"))
+	}
+	if keyDir == "" {
+		return config.DefaultSecretsDir(cfg.UserName())
+	}
+	return keyDir, nil
+}
+
+// seedWords returns the words of the given secret seed, which are
+// separated by '-' and '.'.
+func seedWords(seed string) []string {
+	return strings.FieldsFunc(strings.ToLower(seed), func(r rune) bool {
+		return r == '-' || r == '.'
+	})
+}
+
+// chooseWords returns k distinct random positions, counting from 1 and in
+// increasing order, of a seed of n words.
+func chooseWords(n, k int) ([]int, error) {
+	if n < k {
+		return nil, errors.E(errors.Invalid, errors.Errorf("secret seed has only %d words", n))
+	}
+	seen := make(map[int]bool)
+	var words []int
+	for len(words) < k {
+		r, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+		if err != nil {
+			return nil, err
+		}
+		pos := int(r.Int64()) + 1
+		if !seen[pos] {
+			seen[pos] = true
+			words = append(words, pos)
+		}
+	}
+	sort.Ints(words)
+	return words, nil
+}
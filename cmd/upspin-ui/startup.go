@@ -13,8 +13,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -23,7 +21,6 @@ import (
 
 	"upspin.io/bind"
 	"upspin.io/client"
-	"upspin.io/cmd/cacheserver/cacheutil"
 	"upspin.io/config"
 	"upspin.io/errors"
 	"upspin.io/flags"
@@ -51,6 +48,50 @@ var noneEndpoint = upspin.Endpoint{
 	NetAddr:   "none",
 }
 
+// startupRequest is sent by the client to perform a startup action.
+// The zero value requests the current step of the startup process.
+type startupRequest struct {
+	// Action is the action to perform, if any.
+	Action string `json:",omitempty"`
+
+	// Action: "signup" and "recover"
+	UserName upspin.UserName `json:",omitempty"`
+	// The key server address and TLS certificate directory default to
+	// the -keyserver and -tlscerts flags.
+	KeyServer string `json:",omitempty"`
+	TLSCerts  string `json:",omitempty"`
+
+	// Action: "recover"
+	SecretSeed string `json:",omitempty"`
+
+	// Action: "confirmSeed"
+	SeedWords []string `json:",omitempty"` // The words asked for, in order.
+
+	// Action: "specifyEndpoints"
+	DirServer   string `json:",omitempty"`
+	StoreServer string `json:",omitempty"`
+
+	// Action: "specifyGCP"
+	PrivateKeyData string `json:",omitempty"`
+
+	// Action: "createGCP"
+	BucketName string `json:",omitempty"`
+	BucketLoc  string `json:",omitempty"`
+	RegionZone string `json:",omitempty"` // Region and zone, separated by "/".
+
+	// Action: "configureServerUserName"
+	UserNameSuffix string `json:",omitempty"`
+
+	// Action: "configureServerHostName"
+	HostName string `json:",omitempty"`
+
+	// Action: "checkServerHostName"
+	Reset bool `json:",omitempty"` // Choose another host name.
+
+	// Action: "configureServer"
+	Writers string `json:",omitempty"` // Separated by white space.
+}
+
 // startupResponse is sent to the client in response to startup requests.
 type startupResponse struct {
 	// Step is the modal dialog that should be displayed to the user at
@@ -61,9 +102,14 @@ type startupResponse struct {
 	KeyDir     string `json:",omitempty"`
 	SecretSeed string `json:",omitempty"`
 
-	// Step: "verify"
+	// Step: "verify", "confirmSeed", and "confirmServerSeed"
 	UserName upspin.UserName `json:",omitempty"`
 
+	// Step: "confirmSeed" and "confirmServerSeed"
+	// The positions, counting from 1, of the words of the secret seed
+	// that the user must type back. KeyDir holds the keys.
+	SeedWords []int `json:",omitempty"`
+
 	// Step: "gcpDetails"
 	BucketName string   `json:",omitempty"`
 	Zones      []string `json:",omitempty"`
@@ -93,8 +139,16 @@ type startupResponse struct {
 //    - Prompt the user for a user name and server endpoints (Step: "signup").
 //    - Write a new config and generate keys (action "signup").
 //    - Register the user and keys with the key server (action "register").
+//    - Display the secret seed ("secretSeed") and ask the user to type back
+//      some of its words ("confirmSeed", action "confirmSeed"). The seed may
+//      be displayed again from the key files (action "showSeed").
+//    - Or, for an existing user, regenerate their keys from their secret seed
+//      and write a config using the endpoints in their key server record
+//      (action "recover").
 //  - Check that the config's user exists on the Key Server. If not:
 //    - Prompt the user to click the verification link in the email (Step: "verify").
+//    - Poll the key server in the background until the user appears; the
+//      front end waits for that with the "startup/verify" API route.
 //  - Check that the user has endpoints defined in the config file. If not:
 //    - Prompt the user to choose dir/store endpoints, deploy to GCP, or none.
 //      (Step: "serverSelect")
@@ -109,7 +163,8 @@ type startupResponse struct {
 //      - Create the GCP Storage Bucket, Address, and Compute Instance.
 //      - Prompt user for a user name for the server ("serverUserName").
 //      - Register the server user name with the key server.
-//      - Display server user proquint, ask user to write it down ("serverSecretSeed").
+//      - Display server user proquint, ask user to write it down ("serverSecretSeed"),
+//        and to type back some of its words ("confirmServerSeed").
 //      - Prompt user for a host name for the server ("serverHostName").
 //      - If they elect for a default, create a host name through host@upspin.io.
 //      - Check that the host name resolves to the server IP.
@@ -122,7 +177,7 @@ type startupResponse struct {
 // presented with a given step, startup returns a non-nil startupResponse. If
 // all the conditions are met, startup returns a non-nil Config. If an error
 // occurs startup returns that error.
-func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.Config, err error) {
+func (s *server) startup(req *startupRequest) (resp *startupResponse, cfg upspin.Config, err error) {
 	s.mu.Lock()
 	cfg = s.cfg
 	s.mu.Unlock()
@@ -130,10 +185,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		return nil, cfg, nil
 	}
 
-	if err := req.ParseForm(); err != nil {
-		return nil, nil, err
-	}
-	logf("startup: request: %v", formatRequest(req.Form))
+	logf("startup: request: %v", formatRequest(req))
 	defer func() {
 		if err != nil {
 			logf("startup: error: %v", err)
@@ -144,12 +196,16 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		}
 	}()
 
-	action := req.FormValue("action")
+	action := req.Action
 
 	var secretSeed, keyDir string
 	if action == "signup" {
 		// The user clicked the "Sign up" button on the signup dialog.
-		userName := upspin.UserName(req.FormValue("username"))
+		userName := req.UserName
+		ks, err := signupKeyServer(req)
+		if err != nil {
+			return nil, nil, err
+		}
 
 		if err := valid.UserName(userName); err != nil {
 			return nil, nil, err
@@ -163,14 +219,14 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		}
 
 		// Check whether userName already exists on the KeyServer.
-		if ok, err := isRegistered(userName); err != nil {
+		if ok, err := isRegistered(ks, userName); err != nil {
 			return nil, nil, err
 		} else if ok {
 			return nil, nil, errors.Errorf("%q is already registered.", userName)
 		}
 
 		// Write config file.
-		err = writeConfig(flags.Config, userName, upspin.Endpoint{}, upspin.Endpoint{}, false)
+		err = writeConfig(flags.Config, userName, ks, upspin.Endpoint{}, upspin.Endpoint{}, false)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -188,6 +244,19 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		action = "register"
 	}
 
+	if action == "recover" {
+		// The user clicked the "Recover" button on the recover dialog.
+		ks, err := signupKeyServer(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := recoverUser(ks, req.UserName, req.SecretSeed); err != nil {
+			return nil, nil, err
+		}
+		// Carry on as if the config had been there all along.
+		action = ""
+	}
+
 	// Look for a config file.
 	if !exists(flags.Config) {
 		// Config doesn't exist; need to sign up.
@@ -211,7 +280,13 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 	var response string
 	switch action {
 	case "register":
-		if err := signup.MakeRequest(cfg); err != nil {
+		if keyDir == "" {
+			// The user asked for the email to be sent again.
+			if err := s.checkResend(cfg); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := s.requestSignup(cfg); err != nil {
 			if keyDir != "" {
 				// We have just generated the keys, so we
 				// should remove both the keys and the config,
@@ -221,10 +296,15 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 			}
 			return nil, nil, err
 		}
+		s.sentVerification(cfg)
 		next := "verify"
 		if secretSeed != "" {
-			// Show the secret seed if we have just generated the key.
+			// Show the secret seed if we have just generated the key,
+			// and check that the user wrote it down before moving on.
 			next = "secretSeed"
+			if _, err := s.challengeSeed(cfg.UserName(), keyDir, next); err != nil {
+				logf("startup: cannot check secret seed: %v", err)
+			}
 		}
 		return &startupResponse{
 			Step:       next,
@@ -233,28 +313,59 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 			UserName:   cfg.UserName(),
 		}, nil, nil
 
+	case "showSeed":
+		// Display the secret seed that is to be confirmed, or the
+		// user's own, again.
+		var (
+			user   upspin.UserName
+			keyDir string
+			step   = "secretSeed"
+		)
+		if c := s.pendingSeedCheck(); c != nil {
+			user, keyDir, step = c.user, c.keyDir, c.step
+		} else if st != nil && st.Server.UserName != "" && !st.Server.SeedConfirmed {
+			user, keyDir, step = st.Server.UserName, st.Server.KeyDir, "serverSecretSeed"
+		} else {
+			user = cfg.UserName()
+			if keyDir, err = keyDirOf(cfg); err != nil {
+				return nil, nil, err
+			}
+		}
+		seedResp, err := s.showSeed(user, keyDir, step)
+		if err != nil {
+			return nil, nil, err
+		}
+		return seedResp, nil, nil
+
+	case "confirmSeed":
+		c, err := s.confirmSeed(req.SeedWords)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.step == "serverSecretSeed" && st != nil {
+			st.Server.SeedConfirmed = true
+			if err := st.save(); err != nil {
+				return nil, nil, err
+			}
+		}
+
 	case "specifyEndpoints":
-		dirHost := req.FormValue("dirServer")
-		dirEndpoint, err := hostnameToEndpoint(dirHost)
+		dirHost := req.DirServer
+		dirEndpoint, err := s.hostEndpoint(dirHost)
 		if err != nil {
 			return nil, nil, errors.Errorf("invalid hostname %q: %v", dirHost, err)
 		}
 		cfg = config.SetDirEndpoint(cfg, dirEndpoint)
-		storeHost := req.FormValue("storeServer")
-		storeEndpoint, err := hostnameToEndpoint(storeHost)
+		storeHost := req.StoreServer
+		storeEndpoint, err := s.hostEndpoint(storeHost)
 		if err != nil {
 			return nil, nil, errors.Errorf("invalid hostname %q: %v", storeHost, err)
 		}
 		cfg = config.SetStoreEndpoint(cfg, storeEndpoint)
 
 		// Check that the StoreServer is up.
-		store, err := bind.StoreServer(cfg, storeEndpoint)
-		if err != nil {
-			return nil, nil, errors.Errorf("could not find %q:\n%v", storeHost, err)
-		}
-		_, _, _, err = store.Get("Upspin:notexist")
-		if err != nil && !errors.Match(errors.E(errors.NotExist), err) {
-			return nil, nil, errors.Errorf("error communicating with %q:\n%v", storeHost, err)
+		if err := checkStoreServer(cfg, storeHost); err != nil {
+			return nil, nil, err
 		}
 
 		// Check that the DirServer is up, and create the user root.
@@ -268,7 +379,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		}
 
 		// Write config file with updated endpoints.
-		err = writeConfig(flags.Config, cfg.UserName(), dirEndpoint, storeEndpoint, true)
+		err = writeConfig(flags.Config, cfg.UserName(), configKeyServer(cfg), dirEndpoint, storeEndpoint, true)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -283,15 +394,13 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		cfg = config.SetStoreEndpoint(cfg, noneEndpoint)
 
 		// Write config file with updated "none" endpoints.
-		err = writeConfig(flags.Config, cfg.UserName(), noneEndpoint, noneEndpoint, true)
+		err = writeConfig(flags.Config, cfg.UserName(), configKeyServer(cfg), noneEndpoint, noneEndpoint, true)
 		if err != nil {
 			return nil, nil, err
 		}
 
 	case "specifyGCP":
-		privateKeyData := req.FormValue("privateKeyData")
-
-		st, err = gcpStateFromPrivateKeyJSON([]byte(privateKeyData))
+		st, err = gcpStateFromPrivateKeyJSON([]byte(req.PrivateKeyData))
 		if err != nil {
 			return nil, nil, err
 		}
@@ -299,24 +408,21 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		response = "gcpDetails"
 
 	case "createGCP":
-		bucketName := req.FormValue("bucketName")
-		bucketLoc := req.FormValue("bucketLoc")
-		regionZone := req.FormValue("regionZone")
-		p := strings.SplitN(regionZone, "/", 2)
+		p := strings.SplitN(req.RegionZone, "/", 2)
 		if len(p) != 2 {
-			return nil, nil, errors.Errorf("invalid region/zone %q", regionZone)
+			return nil, nil, errors.Errorf("invalid region/zone %q", req.RegionZone)
 		}
 		region, zone := p[0], p[1]
 
 		// Create the bucket, VM instance, and other associated bits.
-		if err := st.create(region, zone, bucketName, bucketLoc); err != nil {
+		if err := st.create(region, zone, req.BucketName, req.BucketLoc); err != nil {
 			return nil, nil, err
 		}
 
 		response = "serverUserName"
 
 	case "configureServerUserName":
-		suffix := req.FormValue("userNameSuffix")
+		suffix := req.UserNameSuffix
 
 		username, _, domain, err := user.Parse(cfg.UserName())
 		if err != nil {
@@ -334,7 +440,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		}
 		// Write config file.
 		serverCfgFile := flags.Config + "." + suffix
-		err = writeConfig(serverCfgFile, serverUser, upspin.Endpoint{}, upspin.Endpoint{}, false)
+		err = writeConfig(serverCfgFile, serverUser, configKeyServer(cfg), upspin.Endpoint{}, upspin.Endpoint{}, false)
 		if err != nil {
 			os.RemoveAll(keyDir)
 			return nil, nil, err
@@ -359,6 +465,9 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		if err := st.save(); err != nil {
 			return nil, nil, err
 		}
+		if _, err := s.challengeSeed(serverUser, keyDir, "serverSecretSeed"); err != nil {
+			return nil, nil, err
+		}
 
 		return &startupResponse{
 			Step:       "serverSecretSeed",
@@ -367,7 +476,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		}, nil, nil
 
 	case "configureServerHostName":
-		hostName := req.FormValue("hostName")
+		hostName := req.HostName
 
 		// Set up a default host name if none provided.
 		if hostName == "" {
@@ -390,7 +499,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		response = "waitServerHostName"
 
 	case "checkServerHostName":
-		if req.FormValue("reset") == "true" {
+		if req.Reset {
 			// User clicked the "Choose another host name" button.
 			// Zero out the host name field to give
 			// the user a second chance to select one.
@@ -405,7 +514,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		if err := hostResolvesTo(st.Server.HostName, st.Server.IPAddr); err != nil {
 			return nil, nil, err
 		}
-		ep, err := hostnameToEndpoint(st.Server.HostName)
+		ep, err := s.hostEndpoint(st.Server.HostName)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -413,7 +522,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		// Update the user config file and key server record.
 		cfg = config.SetDirEndpoint(cfg, ep)
 		cfg = config.SetStoreEndpoint(cfg, ep)
-		if err := writeConfig(flags.Config, cfg.UserName(), ep, ep, true); err != nil {
+		if err := writeConfig(flags.Config, cfg.UserName(), configKeyServer(cfg), ep, ep, true); err != nil {
 			return nil, nil, err
 		}
 		if err := putUser(cfg, nil); err != nil {
@@ -430,7 +539,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		}
 		serverCfg = config.SetDirEndpoint(serverCfg, ep)
 		serverCfg = config.SetStoreEndpoint(serverCfg, ep)
-		if err := writeConfig(serverCfgFile, st.Server.UserName, ep, ep, true); err != nil {
+		if err := writeConfig(serverCfgFile, st.Server.UserName, configKeyServer(serverCfg), ep, ep, true); err != nil {
 			return nil, nil, err
 		}
 		if err := putUser(cfg, serverCfg); err != nil {
@@ -440,7 +549,6 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		response = "serverWriters"
 
 	case "configureServer":
-		writersList := req.FormValue("writers")
 
 		// Gather list of writers.
 		// Always include the user and the server user.
@@ -448,7 +556,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 			st.Server.UserName: true,
 			cfg.UserName():     true,
 		}
-		for _, s := range strings.Fields(writersList) {
+		for _, s := range strings.Fields(req.Writers) {
 			name := upspin.UserName(s)
 			if name == "" {
 				continue
@@ -479,16 +587,16 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		// TODO: delete state file instead of saving?
 	}
 
-	// readOnly is set if the user explicitly decided to not nominate a
-	// directory or store server, meaning they are read-only.
-	readOnly := false
-
 	// If we're in the middle of setting up a GCP instance, prompt the user
 	// with the correct step of the process. Otherwise, if the user not
 	// registered with the KeyServer, prompt them to click the verification
 	// link. If they have a registered user, but not specified an endpoint
 	// (including 'unassigned') in the config file, prompt them to select
-	// Upspin servers.
+	// Upspin servers. Before all that, if the user has just been shown a
+	// secret seed, ask them to confirm that they wrote it down.
+	if c := s.pendingSeedCheck(); c != nil && response == "" {
+		return c.response(), nil, nil
+	}
 	if st != nil && response == "" {
 		// Deploying to GCP...
 		if st.APIsEnabled {
@@ -499,6 +607,9 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		}
 		if st.Server.UserName != "" {
 			response = "serverHostName"
+			if !st.Server.SeedConfirmed {
+				response = "confirmServerSeed"
+			}
 		}
 		if st.Server.HostName != "" {
 			response = "waitServerHostName"
@@ -506,11 +617,12 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 		if st.Server.Configured {
 			response = ""
 		}
-	} else if ok, err := isRegistered(cfg.UserName()); err != nil {
+	} else if ok, err := isRegistered(configKeyServer(cfg), cfg.UserName()); err != nil {
 		return nil, nil, err
 	} else if !ok {
-		// TODO: Read seed from secret.upspinkey
-		// and display proquint again?
+		// The user may display their secret seed again
+		// with the "showSeed" action.
+		s.watchRegistration(cfg)
 		return &startupResponse{
 			Step:     "verify",
 			UserName: cfg.UserName(),
@@ -525,10 +637,20 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 				Step: "serverSelect",
 			}, nil, nil
 		}
-		readOnly = true
+		// The user explicitly decided not to nominate a directory
+		// or store server, meaning they are read-only.
 	}
 
 	switch response {
+	case "confirmServerSeed":
+		// The server user's secret seed was shown before upspin-ui
+		// was restarted; ask for it afresh.
+		c, err := s.challengeSeed(st.Server.UserName, st.Server.KeyDir, "serverSecretSeed")
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.response(), nil, nil
+
 	case "gcpDetails":
 		// Prompt for GCP Details such as bucket name and eventually
 		// GCP zone/region, instance size, etc.
@@ -615,9 +737,11 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 	}
 
 	// Start cache if necessary.
-	cacheutil.Start(cfg)
+	if err := s.syncCache(cfg, flags.Config); err != nil {
+		logf("cache: %v", err)
+	}
 
-	if !readOnly {
+	if !isReadOnly(cfg) {
 		if err := makeRoot(cfg); err != nil {
 			return nil, nil, err
 		}
@@ -628,6 +752,7 @@ func (s *server) startup(req *http.Request) (resp *startupResponse, cfg upspin.C
 	s.mu.Lock()
 	s.cfg = cfg
 	s.cli = client.New(cfg)
+	s.profile = defaultProfile
 	s.mu.Unlock()
 
 	return nil, cfg, nil
@@ -661,10 +786,81 @@ func genkey(user upspin.UserName) (seed, keyDir string, err error) {
 	return seed, keyDir, nil
 }
 
+// recoverUser restores the keys and config of the given registered user.
+// It regenerates the user's keys from their secret seed, checks them against
+// the public key in the user's KeyServer record, and saves them in the
+// default directory for the user. It then writes a config file with the
+// endpoints in that record, and the given key server. Existing keys and
+// config files are never replaced, and any files written are removed if
+// recovery fails.
+func recoverUser(ks keyServerSettings, userName upspin.UserName, seed string) error {
+	if err := valid.UserName(userName); err != nil {
+		return err
+	}
+	if exists(flags.Config) {
+		return errors.Errorf("cannot recover: %s already exists", flags.Config)
+	}
+	u, err := lookupUser(ks, userName)
+	if errors.Match(errors.E(errors.NotExist), err) {
+		return errors.Errorf("%q is not registered. Sign up instead.", userName)
+	}
+	if err != nil {
+		return err
+	}
+
+	seed = strings.TrimSpace(seed)
+	pub, priv, err := keygen.FromSecret("p256", seed)
+	if err != nil {
+		return errors.E(errors.Invalid, errors.Errorf("invalid secret seed: %v", err))
+	}
+	if strings.TrimSpace(pub) != strings.TrimSpace(string(u.PublicKey)) {
+		return errors.E(userName, errors.Permission, errors.Str("the secret seed does not match the public key registered for this user"))
+	}
+
+	keyDir, err := config.DefaultSecretsDir(userName)
+	if err != nil {
+		return err
+	}
+	if exists(filepath.Join(keyDir, "secret.upspinkey")) {
+		return errors.Errorf("cannot recover keys in %s: keys already exist", keyDir)
+	}
+	madeDir := !exists(keyDir)
+	removeKeys := func() {
+		if madeDir {
+			os.RemoveAll(keyDir)
+			return
+		}
+		os.Remove(filepath.Join(keyDir, "public.upspinkey"))
+		os.Remove(filepath.Join(keyDir, "secret.upspinkey"))
+	}
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		return err
+	}
+	if err := keygen.SaveKeys(keyDir, false, pub, priv, seed); err != nil {
+		removeKeys()
+		return err
+	}
+
+	// Use the endpoints from the key server record. If there are none,
+	// the user will be asked to choose them.
+	var dir, store upspin.Endpoint
+	if len(u.Dirs) > 0 && u.Dirs[0].Transport != upspin.Unassigned {
+		dir = u.Dirs[0]
+	}
+	if len(u.Stores) > 0 && u.Stores[0].Transport != upspin.Unassigned {
+		store = u.Stores[0]
+	}
+	if err := writeConfig(flags.Config, userName, ks, dir, store, false); err != nil {
+		removeKeys()
+		return err
+	}
+	return nil
+}
+
 // writeConfig writes an Upspin config to the nominated file containing the
-// provided user name and endpoints.
+// provided user name, key server, and endpoints.
 // It will fail if file exists and allowOverwrite is false.
-func writeConfig(file string, user upspin.UserName, dir, store upspin.Endpoint, allowOverwrite bool) error {
+func writeConfig(file string, user upspin.UserName, ks keyServerSettings, dir, store upspin.Endpoint, allowOverwrite bool) error {
 	if exists(file) && !allowOverwrite {
 		return errors.Errorf("cannot write %s: file already exists", file)
 	}
@@ -672,8 +868,8 @@ func writeConfig(file string, user upspin.UserName, dir, store upspin.Endpoint,
 		return err
 	}
 	cfg := fmt.Sprintf("username: %s\n", user)
-	if *keyServerAddr != defaultKeyServer {
-		cfg += fmt.Sprintf("keyserver: remote,%s\n", *keyServerAddr)
+	if ks.Endpoint != config.New().KeyEndpoint() {
+		cfg += fmt.Sprintf("keyserver: %s\n", ks.Endpoint)
 	}
 	if dir != (upspin.Endpoint{}) {
 		cfg += fmt.Sprintf("dirserver: %s\n", dir)
@@ -682,8 +878,8 @@ func writeConfig(file string, user upspin.UserName, dir, store upspin.Endpoint,
 		cfg += fmt.Sprintf("storeserver: %s\n", store)
 	}
 	cfg += "packing: ee\n"
-	if *tlsCertDir != "" {
-		cfg += fmt.Sprintf("tlscerts: %s\n", *tlsCertDir)
+	if ks.TLSCerts != "" {
+		cfg += fmt.Sprintf("tlscerts: %s\n", ks.TLSCerts)
 	}
 	// Deactivated cache for now, as it seems to interact poorly with
 	// host@upspin.io. TODO(adg): turn it back on after more testing.
@@ -691,8 +887,81 @@ func writeConfig(file string, user upspin.UserName, dir, store upspin.Endpoint,
 	return ioutil.WriteFile(file, []byte(cfg), 0644)
 }
 
+// keyServerSettings names a key server and the directory of TLS root
+// certificates with which to reach it. They are chosen at signup, where they
+// default to the -keyserver and -tlscerts flags, and are recorded in the
+// config file, from which they are read thereafter.
+type keyServerSettings struct {
+	Endpoint upspin.Endpoint
+	TLSCerts string // Empty to use the system's roots.
+}
+
+// signupKeyServer returns the key server settings chosen in req, using the
+// -keyserver and -tlscerts flags for those that are not given. It checks
+// that the TLS certificate directory exists, and makes its name absolute.
+func signupKeyServer(req *startupRequest) (keyServerSettings, error) {
+	addr, dir := *keyServerAddr, *tlsCertDir
+	if a := strings.TrimSpace(req.KeyServer); a != "" {
+		addr = a
+	}
+	if d := strings.TrimSpace(req.TLSCerts); d != "" {
+		dir = d
+	}
+	if !strings.Contains(addr, ":") {
+		addr += ":443"
+	}
+	ks := keyServerSettings{
+		Endpoint: upspin.Endpoint{
+			Transport: upspin.Remote,
+			NetAddr:   upspin.NetAddr(addr),
+		},
+		TLSCerts: dir,
+	}
+	if ks.TLSCerts == "" {
+		return ks, nil
+	}
+	dir, err := filepath.Abs(ks.TLSCerts)
+	if err != nil {
+		return ks, err
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return ks, errors.E(errors.Invalid, errors.Errorf("TLS certificate directory %q does not exist", ks.TLSCerts))
+	}
+	ks.TLSCerts = dir
+	return ks, nil
+}
+
+// configKeyServer returns the key server settings recorded in cfg.
+func configKeyServer(cfg upspin.Config) keyServerSettings {
+	return keyServerSettings{
+		Endpoint: cfg.KeyEndpoint(),
+		TLSCerts: cfg.Value("tlscerts"),
+	}
+}
+
+// requestSignup asks the key server to send the user in cfg an email
+// with a link that completes their registration.
+func (s *server) requestSignup(cfg upspin.Config) error {
+	if s.signupFunc != nil {
+		return s.signupFunc(cfg)
+	}
+	return signup.MakeRequest(cfg)
+}
+
 // isRegistered reports whether the given user is present on the KeyServer.
-func isRegistered(user upspin.UserName) (bool, error) {
+func isRegistered(ks keyServerSettings, user upspin.UserName) (bool, error) {
+	_, err := lookupUser(ks, user)
+	if errors.Match(errors.E(errors.NotExist), err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lookupUser returns the KeyServer record for the given user.
+func lookupUser(ks keyServerSettings, user upspin.UserName) (*upspin.User, error) {
 	// Do the lookup request as the user "nobody@upspin.io" instead of the
 	// user we're looking for, so that bind doesn't cache the dialed
 	// KeyServer for the actual user with a nil factotum. Otherwise this
@@ -700,27 +969,17 @@ func isRegistered(user upspin.UserName) (bool, error) {
 	// Put of a server user. In any case, it doesn't matter who the calling
 	// user is because the KeyServer.Lookup requests are not authenticated.
 	cfg := config.SetUserName(config.New(), "nobody@upspin.io")
-
-	if *tlsCertDir != "" {
-		cfg = config.SetValue(cfg, "tlscerts", *tlsCertDir)
+	cfg = config.SetKeyEndpoint(cfg, ks.Endpoint)
+	if ks.TLSCerts != "" {
+		cfg = config.SetValue(cfg, "tlscerts", ks.TLSCerts)
 	}
 
-	key, err := bind.KeyServer(cfg, upspin.Endpoint{
-		Transport: upspin.Remote,
-		NetAddr:   upspin.NetAddr(*keyServerAddr),
-	})
+	key, err := bind.KeyServer(cfg, ks.Endpoint)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	usercache.ResetGlobal() // Avoid hitting the local user cache.
-	_, err = key.Lookup(user)
-	if errors.Match(errors.E(errors.NotExist), err) {
-		return false, nil
-	}
-	if err != nil {
-		return false, err
-	}
-	return true, nil
+	return key.Lookup(user)
 }
 
 // makeRoot checks whether the given config's user's root exists and creates it
@@ -850,12 +1109,42 @@ func hasEndpoints(configFile string) (bool, error) {
 	return bytes.Contains(b, []byte("\ndirserver:")), nil
 }
 
+// checkStoreServer checks that the StoreServer in cfg, whose host name is
+// given for error messages, is up.
+func checkStoreServer(cfg upspin.Config, host string) error {
+	store, err := bind.StoreServer(cfg, cfg.StoreEndpoint())
+	if err != nil {
+		return errors.Errorf("could not find %q:\n%v", host, err)
+	}
+	_, _, _, err = store.Get("Upspin:notexist")
+	if err != nil && !errors.Match(errors.E(errors.NotExist), err) {
+		return errors.Errorf("error communicating with %q:\n%v", host, err)
+	}
+	return nil
+}
+
+// isReadOnly reports whether cfg names no directory server, as when the user
+// chose not to nominate servers during signup. Such a user may read the files
+// of others but has no tree of their own and cannot write files.
+func isReadOnly(cfg upspin.Config) bool {
+	return cfg.DirEndpoint().Transport == upspin.Unassigned
+}
+
 // exists reports whether the given path is accessible.
 func exists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
 
+// hostEndpoint returns the endpoint of the directory or store server with
+// the given host name, as given by the user.
+func (s *server) hostEndpoint(hostname string) (upspin.Endpoint, error) {
+	if s.endpointFunc != nil {
+		return s.endpointFunc(hostname)
+	}
+	return hostnameToEndpoint(hostname)
+}
+
 // hostnameToEndpoint returns the remote endpoint for the given host name,
 // appending :443 if no port is provided.
 func hostnameToEndpoint(hostname string) (upspin.Endpoint, error) {
@@ -883,40 +1172,29 @@ func serverConfig(name upspin.UserName) (cfg upspin.Config, filename string, err
 }
 
 // formatRequest returns a human-readable string representation of the given
-// request values, redacting any private key information.
-func formatRequest(vals url.Values) string {
-	var buf bytes.Buffer
-	fmt.Fprint(&buf, "{")
-	first := true
-	for k, vs := range vals {
+// request, redacting any private key information.
+func formatRequest(req *startupRequest) string {
+	if req == nil {
+		return "<nil>"
+	}
+	r := *req
+	// Redact secret seeds, and words of them, from the log file, so
+	// users don't inadvertently leak their Upspin keys to the world when
+	// reporting bugs.
+	if r.SecretSeed != "" {
+		r.SecretSeed = "REDACTED"
+	}
+	if len(r.SeedWords) > 0 {
+		r.SeedWords = []string{"REDACTED"}
+	}
+	if r.PrivateKeyData != "" {
 		// Redact private key data from the log file, so users don't
 		// inadvertently leak their cloud project credentials to the
 		// world when reporting bugs.
-		if k == "privateKeyData" {
-			vs = []string{"REDACTED"}
-		}
-		// This field is always "startup" so omit it.
-		if k == "method" {
-			continue
-		}
-		// Don't redundantly log the session key.
-		if k == "key" {
-			continue
-		}
-		if first {
-			fmt.Fprint(&buf, "\n")
-			first = false
-		} else {
-			fmt.Fprint(&buf, ",\n")
-		}
-		fmt.Fprintf(&buf, "\t%q: %q", k, vs)
-	}
-	if first {
-		fmt.Fprint(&buf, "}")
-	} else {
-		fmt.Fprint(&buf, "\n}")
+		r.PrivateKeyData = "REDACTED"
 	}
-	return buf.String()
+	b, _ := json.MarshalIndent(&r, "", "\t")
+	return string(b)
 }
 
 // formatResponse returns a human-readable string representation of the given
@@ -0,0 +1,782 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"augie.upspin.io/uiclient"
+
+	"upspin.io/bind"
+	"upspin.io/config"
+	"upspin.io/errors"
+	"upspin.io/flags"
+	"upspin.io/key/keygen"
+	"upspin.io/upspin"
+
+	keyserverrpc "upspin.io/rpc/keyserver"
+)
+
+// signupStep is a step of a signup script run by TestSignup.
+type signupStep struct {
+	desc string
+
+	// before, if non-nil, is run before the request is sent.
+	before func(e *signupEnv) error
+
+	// restart, if set, replaces the server with a new one before the
+	// request is sent, as if upspin-ui had been restarted.
+	restart bool
+
+	// req is the startup request to send.
+	req startupRequest
+
+	// seed, if "last", sets req.SecretSeed to the secret seed most recently
+	// returned by startup. If "other", it sets it to a newly generated seed.
+	// If "words", it sets req.SeedWords to the words of the last seed that
+	// the pending seed challenge asks for, and if "wrong words", to words
+	// that differ from those.
+	seed string
+
+	// noCerts, if set, leaves req.TLSCerts empty. Otherwise signup and
+	// recover requests name the certificate directory of the TLS key
+	// server started by TestSignup, as well as its address.
+	noCerts bool
+
+	// step is the Step that startup should return,
+	// or the empty string if it should return a config.
+	step string
+
+	// err, if non-empty, is a string that the error returned by startup
+	// should contain. If it is "*", any error is accepted.
+	err string
+
+	// exist and absent list files that should and should not exist after
+	// the request. The name "config" is the config file, and names of the
+	// form "keys/<user>/<file>" are in the default key directory of <user>.
+	exist, absent []string
+
+	// configLines lists lines that the config file should contain
+	// after the request. In them, $KEYSERVER and $TLSCERTS stand for the
+	// address and certificate directory of the TLS key server.
+	configLines []string
+
+	// after, if non-nil, is run once the result has been checked.
+	after func(e *signupEnv) error
+}
+
+// signupScripts are the scripts run by TestSignup. The user names are
+// distinct across scripts, as all scripts share an in-process key server.
+// The host name "inprocess" names the in-process directory and store
+// servers; see newSignupServer.
+var signupScripts = []struct {
+	name  string
+	steps []signupStep
+}{{
+	name: "no endpoints",
+	steps: []signupStep{{
+		desc:   "initial",
+		step:   "signup",
+		absent: []string{"config"},
+	}, {
+		desc:        "signup",
+		req:         startupRequest{Action: "signup", UserName: "ann@example.com"},
+		step:        "secretSeed",
+		exist:       []string{"config", "keys/ann@example.com/public.upspinkey", "keys/ann@example.com/secret.upspinkey"},
+		configLines: []string{"username: ann@example.com", "packing: ee"},
+	}, {
+		desc: "wrote seed down",
+		step: "confirmSeed",
+	}, {
+		desc: "wrong seed words",
+		req:  startupRequest{Action: "confirmSeed"},
+		seed: "wrong words",
+		err:  "not the right words",
+	}, {
+		desc: "show seed again",
+		req:  startupRequest{Action: "showSeed"},
+		step: "secretSeed",
+	}, {
+		desc: "wrote seed down again",
+		step: "confirmSeed",
+	}, {
+		desc: "confirm seed",
+		req:  startupRequest{Action: "confirmSeed"},
+		seed: "words",
+		step: "verify",
+	}, {
+		desc:  "unverified",
+		step:  "verify",
+		after: checkVerification("ann@example.com"),
+	}, {
+		// checkVerification has verified the email address.
+		desc: "verified",
+		step: "serverSelect",
+	}, {
+		desc:        "choose no endpoints",
+		req:         startupRequest{Action: "specifyNoEndpoints"},
+		configLines: []string{"dirserver: unassigned", "storeserver: unassigned"},
+	}, {
+		desc:    "restart",
+		restart: true,
+		after:   checkReadOnly("ann@example.com"),
+	}},
+}, {
+	name: "in-process endpoints",
+	steps: []signupStep{{
+		desc: "signup",
+		req:  startupRequest{Action: "signup", UserName: "bob@example.com"},
+		step: "secretSeed",
+	}, {
+		desc: "confirm seed",
+		req:  startupRequest{Action: "confirmSeed"},
+		seed: "words",
+		step: "verify",
+	}, {
+		desc:   "verified",
+		before: verifyEmail("bob@example.com"),
+		step:   "serverSelect",
+	}, {
+		desc: "choose endpoints",
+		req:  startupRequest{Action: "specifyEndpoints", DirServer: "inprocess", StoreServer: "inprocess"},
+	}, {
+		desc:    "restart",
+		restart: true,
+	}},
+}, {
+	name: "invalid user name",
+	steps: []signupStep{{
+		desc:   "signup",
+		req:    startupRequest{Action: "signup", UserName: "carl"},
+		err:    "*",
+		absent: []string{"config"},
+	}},
+}, {
+	name: "user name with suffix",
+	steps: []signupStep{{
+		desc:   "signup",
+		req:    startupRequest{Action: "signup", UserName: "carl+test@example.com"},
+		err:    "must not contain a + symbol",
+		absent: []string{"config", "keys/carl+test@example.com"},
+	}},
+}, {
+	name: "already registered",
+	steps: []signupStep{{
+		desc:   "signup",
+		before: registerUser("dana@example.com"),
+		req:    startupRequest{Action: "signup", UserName: "dana@example.com"},
+		err:    "already registered",
+		absent: []string{"config", "keys/dana@example.com"},
+	}},
+}, {
+	name: "key directory exists",
+	steps: []signupStep{{
+		desc:   "signup",
+		before: makeKeyDir("erin@example.com"),
+		req:    startupRequest{Action: "signup", UserName: "erin@example.com"},
+		err:    "directory already exists",
+		absent: []string{"config", "keys/erin@example.com/secret.upspinkey"},
+	}, {
+		desc:   "after failure",
+		step:   "signup",
+		absent: []string{"config"},
+	}},
+}, {
+	name: "signup request fails",
+	steps: []signupStep{{
+		desc:   "signup",
+		before: failSignup,
+		req:    startupRequest{Action: "signup", UserName: "fred@example.com"},
+		err:    "signup request failed",
+		absent: []string{"config", "keys/fred@example.com"},
+	}, {
+		desc: "retry",
+		req:  startupRequest{Action: "signup", UserName: "fred@example.com"},
+		step: "secretSeed",
+	}},
+}, {
+	name: "repeated signup",
+	steps: []signupStep{{
+		desc: "signup",
+		req:  startupRequest{Action: "signup", UserName: "gail@example.com"},
+		step: "secretSeed",
+	}, {
+		desc:  "signup again",
+		req:   startupRequest{Action: "signup", UserName: "gail@example.com"},
+		err:   "file already exists",
+		exist: []string{"config", "keys/gail@example.com/secret.upspinkey"},
+	}},
+}, {
+	name: "recover",
+	steps: []signupStep{{
+		desc: "signup",
+		req:  startupRequest{Action: "signup", UserName: "hal@example.com"},
+		step: "secretSeed",
+	}, {
+		desc: "confirm seed",
+		req:  startupRequest{Action: "confirmSeed"},
+		seed: "words",
+		step: "verify",
+	}, {
+		desc:   "verified",
+		before: verifyEmail("hal@example.com"),
+		step:   "serverSelect",
+	}, {
+		desc: "choose endpoints",
+		req:  startupRequest{Action: "specifyEndpoints", DirServer: "inprocess", StoreServer: "inprocess"},
+	}, {
+		desc:    "lose keys and config",
+		before:  loseFiles("config", "keys/hal@example.com"),
+		restart: true,
+		step:    "signup",
+	}, {
+		desc:   "recover with wrong seed",
+		req:    startupRequest{Action: "recover", UserName: "hal@example.com"},
+		seed:   "other",
+		err:    "does not match",
+		absent: []string{"config", "keys/hal@example.com"},
+	}, {
+		desc:   "recover with invalid seed",
+		req:    startupRequest{Action: "recover", UserName: "hal@example.com", SecretSeed: "not-a-seed"},
+		err:    "invalid secret seed",
+		absent: []string{"config", "keys/hal@example.com"},
+	}, {
+		desc:  "recover",
+		req:   startupRequest{Action: "recover", UserName: "hal@example.com"},
+		seed:  "last",
+		exist: []string{"config", "keys/hal@example.com/public.upspinkey", "keys/hal@example.com/secret.upspinkey"},
+	}, {
+		desc:    "restart",
+		restart: true,
+	}},
+}, {
+	name: "recover unregistered user",
+	steps: []signupStep{{
+		desc:   "recover",
+		req:    startupRequest{Action: "recover", UserName: "ivy@example.com"},
+		seed:   "other",
+		err:    "not registered",
+		absent: []string{"config", "keys/ivy@example.com"},
+	}},
+}, {
+	name: "recover over existing keys",
+	steps: []signupStep{{
+		desc: "signup",
+		req:  startupRequest{Action: "signup", UserName: "jack@example.com"},
+		step: "secretSeed",
+	}, {
+		desc:    "restart before confirming seed",
+		restart: true,
+		step:    "verify",
+	}, {
+		desc: "show seed after restart",
+		req:  startupRequest{Action: "showSeed"},
+		step: "secretSeed",
+	}, {
+		desc: "confirm seed",
+		req:  startupRequest{Action: "confirmSeed"},
+		seed: "words",
+		step: "verify",
+	}, {
+		desc:   "verified",
+		before: verifyEmail("jack@example.com"),
+		step:   "serverSelect",
+	}, {
+		desc:    "lose config",
+		before:  loseFiles("config"),
+		restart: true,
+		step:    "signup",
+	}, {
+		desc:   "recover",
+		req:    startupRequest{Action: "recover", UserName: "jack@example.com"},
+		seed:   "last",
+		err:    "keys already exist",
+		exist:  []string{"keys/jack@example.com/secret.upspinkey"},
+		absent: []string{"config"},
+	}},
+}, {
+	name: "self-hosted key server",
+	steps: []signupStep{{
+		desc:        "signup",
+		req:         startupRequest{Action: "signup", UserName: "ken@example.com"},
+		step:        "secretSeed",
+		configLines: []string{"keyserver: remote,$KEYSERVER", "tlscerts: $TLSCERTS"},
+	}, {
+		desc: "confirm seed",
+		req:  startupRequest{Action: "confirmSeed"},
+		seed: "words",
+		step: "verify",
+	}, {
+		desc:   "verified",
+		before: verifyEmail("ken@example.com"),
+		step:   "serverSelect",
+	}, {
+		desc:        "choose no endpoints",
+		req:         startupRequest{Action: "specifyNoEndpoints"},
+		configLines: []string{"keyserver: remote,$KEYSERVER", "tlscerts: $TLSCERTS"},
+	}, {
+		desc:    "restart",
+		restart: true,
+	}},
+}, {
+	name: "self-hosted key server without certificates",
+	steps: []signupStep{{
+		desc:    "signup",
+		req:     startupRequest{Action: "signup", UserName: "lee@example.com"},
+		noCerts: true,
+		err:     "*",
+		absent:  []string{"config", "keys/lee@example.com"},
+	}},
+}}
+
+// signupEnv is the environment in which a signup script runs.
+type signupEnv struct {
+	ks         *tlsKeyServer
+	s          *server
+	failSignup bool   // Whether the next signup request should fail.
+	seed       string // The secret seed most recently returned by startup.
+}
+
+// newSignupServer returns a new server for the script running in e.
+// The server's signup requests succeed unless e.failSignup is set, as the
+// key server has no signup process, and it takes the host name "inprocess"
+// to name the in-process directory and store servers.
+func (e *signupEnv) newSignupServer() (*server, error) {
+	s, err := newServer()
+	if err != nil {
+		return nil, err
+	}
+	s.signupFunc = func(upspin.Config) error {
+		if e.failSignup {
+			e.failSignup = false
+			return errors.Str("signup request failed")
+		}
+		return nil
+	}
+	s.endpointFunc = func(hostname string) (upspin.Endpoint, error) {
+		if hostname == "inprocess" {
+			return inProcess, nil
+		}
+		return hostnameToEndpoint(hostname)
+	}
+	return s, nil
+}
+
+// verifyEmail returns a function that registers the user in the config file
+// with the key server, as if the user had clicked the verification link
+// sent by the key server. The user is registered directly with the
+// in-process key server, which the TLS key server also serves.
+func verifyEmail(user upspin.UserName) func(*signupEnv) error {
+	return func(*signupEnv) error {
+		cfg, err := config.FromFile(flags.Config)
+		if err != nil {
+			return err
+		}
+		if cfg.UserName() != user {
+			return errors.Errorf("config is for %q, want %q", cfg.UserName(), user)
+		}
+		return putUser(config.SetKeyEndpoint(cfg, inProcess), nil)
+	}
+}
+
+// tlsKeyServer is the in-process key server served over HTTPS with a
+// certificate that is not signed by a system root, as a self-hosted key
+// server might be. The signup scripts use it as their key server.
+type tlsKeyServer struct {
+	addr    string // Host and port.
+	certDir string // Holds the server's certificate.
+	ts      *httptest.Server
+}
+
+// startTLSKeyServer starts a tlsKeyServer.
+func startTLSKeyServer() (*tlsKeyServer, error) {
+	cfg, err := inProcessConfig("keyserver@example.com")
+	if err != nil {
+		return nil, err
+	}
+	key, err := bind.KeyServer(cfg, inProcess)
+	if err != nil {
+		return nil, err
+	}
+	certDir, err := ioutil.TempDir("", "upspin-ui-test-certs")
+	if err != nil {
+		return nil, err
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	ks := &tlsKeyServer{
+		addr:    ts.Listener.Addr().String(),
+		certDir: certDir,
+		ts:      ts,
+	}
+	ts.Config.Handler = keyserverrpc.New(cfg, key, upspin.NetAddr(ks.addr))
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(certDir, "test.pem"), cert, 0644); err != nil {
+		ks.stop()
+		return nil, err
+	}
+	return ks, nil
+}
+
+// stop stops the key server and removes its certificate directory.
+func (ks *tlsKeyServer) stop() {
+	ks.ts.Close()
+	os.RemoveAll(ks.certDir)
+}
+
+// registerUser returns a function that registers the given user, with a new
+// key pair, with the key server.
+func registerUser(user upspin.UserName) func(*signupEnv) error {
+	return func(*signupEnv) error {
+		cfg, err := inProcessConfig(user)
+		if err != nil {
+			return err
+		}
+		return putUser(cfg, nil)
+	}
+}
+
+// makeKeyDir returns a function that creates the default key directory
+// for the given user.
+func makeKeyDir(user upspin.UserName) func(*signupEnv) error {
+	return func(*signupEnv) error {
+		dir, err := config.DefaultSecretsDir(user)
+		if err != nil {
+			return err
+		}
+		return os.MkdirAll(dir, 0700)
+	}
+}
+
+// loseFiles returns a function that removes the named files, which are
+// named as in signupStep.exist.
+func loseFiles(names ...string) func(*signupEnv) error {
+	return func(*signupEnv) error {
+		for _, name := range names {
+			p, err := signupFile(name)
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// checkReadOnly checks, through the HTTP API, that the server reports that
+// the given user is read-only and refuses to modify the tree, that adding
+// the in-process servers as the user's endpoints lifts that, and that the
+// endpoints may then be changed only to servers that hold the user's root.
+func checkReadOnly(user upspin.UserName) func(*signupEnv) error {
+	return func(e *signupEnv) error {
+		ts := httptest.NewServer(e.s)
+		defer ts.Close()
+		c := uiclient.New(ts.URL, e.s.key)
+
+		root := upspin.PathName(user + "/")
+		r, err := c.Startup(nil)
+		if err != nil {
+			return err
+		}
+		if !r.ReadOnly {
+			return errors.Str("startup did not report read-only mode")
+		}
+		_, err = c.MakeDirectory(root + "dir")
+		if err := expectError(err, "ReadOnly"); err != nil {
+			return err
+		}
+		if _, err := c.SetEndpoints("inprocess", "inprocess"); err != nil {
+			return err
+		}
+		if r, err = c.Startup(nil); err != nil {
+			return err
+		}
+		if r.ReadOnly {
+			return errors.Str("startup reported read-only mode after adding endpoints")
+		}
+		if _, err := c.MakeDirectory(root + "dir"); err != nil {
+			return err
+		}
+		// The in-process servers now hold the root.
+		if _, err := c.SetEndpoints("inprocess", "inprocess"); err != nil {
+			return err
+		}
+		// Nothing listens at this address.
+		old, err := ioutil.ReadFile(flags.Config)
+		if err != nil {
+			return err
+		}
+		if _, err := c.SetEndpoints("localhost:1", "localhost:1"); err == nil {
+			return errors.Str("moved to servers that do not exist")
+		}
+		return expectFile(flags.Config, string(old))
+	}
+}
+
+// checkVerification checks, through the HTTP API, that the verification
+// email may not be sent again straight away, and that the server notices
+// when the given user verifies their email address.
+func checkVerification(user upspin.UserName) func(*signupEnv) error {
+	return func(e *signupEnv) error {
+		ts := httptest.NewServer(e.s)
+		defer ts.Close()
+		c := uiclient.New(ts.URL, e.s.key)
+
+		_, err := c.Startup(&uiclient.StartupRequest{Action: "register"})
+		if err := expectError(err, "Transient"); err != nil {
+			return errors.Errorf("resending verification email: %v", err)
+		}
+		st, err := e.s.waitVerified(context.Background(), 0)
+		if err != nil {
+			return err
+		}
+		if st.UserName != user || st.Registered || st.ResendIn <= 0 {
+			return errors.Errorf("before verification, got status %+v", st)
+		}
+
+		if err := verifyEmail(user)(e); err != nil {
+			return err
+		}
+		vs, err := c.WaitVerified()
+		if err != nil {
+			return err
+		}
+		if !vs.Registered {
+			return errors.Errorf("after verification, got status %+v", vs)
+		}
+		return nil
+	}
+}
+
+// failSignup causes the next signup request to fail.
+func failSignup(e *signupEnv) error {
+	e.failSignup = true
+	return nil
+}
+
+// TestSignup runs each of signupScripts against a new server, with its
+// own home directory and config file, and the TLS key server.
+func TestSignup(t *testing.T) {
+	// Register the in-process servers before the first signup.
+	if _, err := inProcessConfig("nobody@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := startTLSKeyServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.stop()
+
+	for _, script := range signupScripts {
+		script := script
+		t.Run(script.name, func(t *testing.T) {
+			runSignupScript(t, ks, script.steps)
+		})
+	}
+}
+
+// runSignupScript runs the given steps in a new environment
+// that uses the given key server.
+func runSignupScript(t *testing.T, ks *tlsKeyServer, steps []signupStep) {
+	home, restore := tempHome(t)
+	defer restore()
+	flags.Config = filepath.Join(home, "upspin", "config")
+
+	e := &signupEnv{ks: ks}
+	var err error
+	if e.s, err = e.newSignupServer(); err != nil {
+		t.Fatal(err)
+	}
+	for i, step := range steps {
+		if err := runSignupStep(e, step); err != nil {
+			t.Fatalf("step %d (%s): %v", i+1, step.desc, err)
+		}
+	}
+}
+
+// tempHome makes a temporary directory the home directory, and returns it
+// and a function that restores the home directory and flags.Config and
+// removes the temporary directory.
+func tempHome(t *testing.T) (home string, restore func()) {
+	home, err := ioutil.TempDir("", "upspin-ui-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldHome, oldConfig := os.Getenv("HOME"), flags.Config
+	if err := os.Setenv("HOME", home); err != nil {
+		t.Fatal(err)
+	}
+	return home, func() {
+		os.Setenv("HOME", oldHome)
+		flags.Config = oldConfig
+		os.RemoveAll(home)
+	}
+}
+
+// runSignupStep runs a single step of a signup script and checks its result.
+func runSignupStep(e *signupEnv, step signupStep) error {
+	if step.before != nil {
+		if err := step.before(e); err != nil {
+			return err
+		}
+	}
+	if step.restart {
+		s, err := e.newSignupServer()
+		if err != nil {
+			return err
+		}
+		e.s = s
+	}
+
+	req := step.req
+	switch step.seed {
+	case "last":
+		req.SecretSeed = e.seed
+	case "other":
+		_, _, seed, err := keygen.Generate("p256")
+		if err != nil {
+			return err
+		}
+		req.SecretSeed = seed
+	case "words", "wrong words":
+		c := e.s.pendingSeedCheck()
+		if c == nil {
+			return errors.Str("no seed challenge is pending")
+		}
+		words := seedWords(e.seed)
+		for _, pos := range c.words {
+			w := words[pos-1]
+			if step.seed == "wrong words" {
+				w += "x"
+			}
+			req.SeedWords = append(req.SeedWords, w)
+		}
+	}
+	if req.Action == "signup" || req.Action == "recover" {
+		req.KeyServer = e.ks.addr
+		if !step.noCerts {
+			req.TLSCerts = e.ks.certDir
+		}
+	}
+	resp, cfg, err := e.s.startup(&req)
+	if resp != nil && resp.SecretSeed != "" {
+		e.seed = resp.SecretSeed
+	}
+	switch {
+	case step.err != "":
+		if err == nil {
+			return errors.Errorf("got no error, want %q", step.err)
+		}
+		if step.err != "*" && !strings.Contains(err.Error(), step.err) {
+			return errors.Errorf("got error %q, want %q", err, step.err)
+		}
+	case err != nil:
+		return err
+	case step.step == "" && resp != nil:
+		return errors.Errorf("got step %q, want config", resp.Step)
+	case step.step == "" && cfg == nil:
+		return errors.Str("got no config")
+	case step.step != "" && resp == nil:
+		return errors.Errorf("got config, want step %q", step.step)
+	case step.step != "" && resp.Step != step.step:
+		return errors.Errorf("got step %q, want %q", resp.Step, step.step)
+	case resp != nil && resp.SecretSeed != "":
+		if !exists(filepath.Join(resp.KeyDir, "secret.upspinkey")) {
+			return errors.Errorf("no secret key in key directory %q", resp.KeyDir)
+		}
+	}
+
+	for _, name := range step.exist {
+		p, err := signupFile(name)
+		if err != nil {
+			return err
+		}
+		if !exists(p) {
+			return errors.Errorf("%s does not exist", name)
+		}
+	}
+	for _, name := range step.absent {
+		p, err := signupFile(name)
+		if err != nil {
+			return err
+		}
+		if exists(p) {
+			return errors.Errorf("%s exists", name)
+		}
+	}
+	if len(step.configLines) > 0 {
+		b, err := ioutil.ReadFile(flags.Config)
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(string(b), "\n")
+		vars := strings.NewReplacer("$KEYSERVER", e.ks.addr, "$TLSCERTS", e.ks.certDir)
+	Lines:
+		for _, want := range step.configLines {
+			want = vars.Replace(want)
+			for _, l := range lines {
+				if l == want {
+					continue Lines
+				}
+			}
+			return errors.Errorf("config does not contain %q:\n%s", want, b)
+		}
+	}
+	if step.after != nil {
+		return step.after(e)
+	}
+	return nil
+}
+
+// signupFile returns the file name for the given signupStep file name.
+func signupFile(name string) (string, error) {
+	if name == "config" {
+		return flags.Config, nil
+	}
+	p := strings.SplitN(name, "/", 3)
+	if len(p) < 2 || p[0] != "keys" {
+		return "", errors.Errorf("bad file name %q", name)
+	}
+	dir, err := config.DefaultSecretsDir(upspin.UserName(p[1]))
+	if err != nil {
+		return "", err
+	}
+	if len(p) == 3 {
+		return filepath.Join(dir, p[2]), nil
+	}
+	return dir, nil
+}
+
+// TestFormatRedactsSecrets checks that requests and responses are logged
+// without secret seeds, the words of them, or cloud credentials.
+func TestFormatRedactsSecrets(t *testing.T) {
+	const seed = "zafah-tomiv-lodog-pupod.kilaz-fosip-limul-tuvut"
+	secrets := []string{seed, "zafah", "kilaz", "private-key-data"}
+	for _, s := range []string{
+		formatRequest(&startupRequest{Action: "recover", UserName: "ann@example.com", SecretSeed: seed}),
+		formatRequest(&startupRequest{Action: "confirmSeed", SeedWords: []string{"zafah", "kilaz"}}),
+		formatRequest(&startupRequest{Action: "specifyGCP", PrivateKeyData: "private-key-data"}),
+		formatResponse(&startupResponse{Step: "secretSeed", SecretSeed: seed}),
+	} {
+		if !strings.Contains(s, "REDACTED") {
+			t.Errorf("nothing redacted in %s", s)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(s, secret) {
+				t.Errorf("%q not redacted in %s", secret, s)
+			}
+		}
+	}
+}
@@ -49,14 +49,48 @@ function FormatEntryAttr(entry) {
 	return s;
 }
 
+// PreviewKind returns the kind of preview that the server can generate for
+// the named file ("markdown", "image", "pdf", or "text"), or null if the file
+// should be downloaded instead of previewed.
+function PreviewKind(name) {
+	var base = name.slice(name.lastIndexOf("/")+1);
+	if (base == "Access" || name.indexOf("/Group/") >= 0) {
+		return "text";
+	}
+	if (base.indexOf(".") < 0) {
+		return null;
+	}
+	var ext = base.slice(base.lastIndexOf(".")+1).toLowerCase();
+	switch (ext) {
+	case "md": case "markdown":
+		return "markdown";
+	case "jpg": case "jpeg": case "png": case "gif":
+		return "image";
+	case "pdf":
+		return "pdf";
+	case "txt": case "go": case "js": case "py": case "sh": case "c": case "h":
+	case "json": case "yaml": case "yml": case "html": case "css":
+		return "text";
+	}
+	return null;
+}
+
 // Inspector displays a modal containing the details of the given entity.
-function Inspect(entry) {
+// The query is appended to the entry's download URL.
+function Inspect(entry, query) {
 	var el = $("#mInspector");
 	el.find(".up-entry-name").text(entry.Name);
 	el.find(".up-entry-size").text(FormatEntrySize(entry));
 	el.find(".up-entry-time").text(FormatEntryTime(entry));
 	el.find(".up-entry-attr").text(FormatEntryAttr(entry));
 	el.find(".up-entry-writer").text(entry.Writer);
+	var downloadEl = el.find(".up-entry-download");
+	if (entry.Attr & 3) {
+		// Directories and links cannot be downloaded.
+		downloadEl.hide();
+	} else {
+		downloadEl.show().attr("href", "/" + entry.Name + query + "&download=1");
+	}
 	el.modal("show");
 }
 
@@ -112,11 +146,274 @@ function Mkdir(basePath, mkdir) {
 	});
 }
 
+// Edit displays a modal containing a text editor for the named file.
+// The get and save arguments are the page's get and save functions,
+// used to fetch the file's contents and to write the edited version.
+// The saved argument is a niladic function called after a successful save.
+function Edit(path, get, save, saved) {
+	var el = $("#mEditor");
+	var contentsEl = el.find(".up-contents").val("").hide();
+	var loadingEl = el.find(".up-loading").show();
+	var errorEl = el.find(".up-error").hide();
+	var button = el.find(".up-save-button").prop("disabled", true);
+	var sequence;
+
+	function reportError(err) {
+		loadingEl.hide();
+		errorEl.show().text(err);
+		button.prop("disabled", false);
+	}
+
+	el.find(".up-path").text(path);
+	get(path, function(contents, seq) {
+		sequence = seq;
+		loadingEl.hide();
+		contentsEl.val(contents).show();
+		button.prop("disabled", false);
+	}, function(err) {
+		reportError(err);
+		button.prop("disabled", true);
+	});
+
+	button.off("click").click(function() {
+		button.prop("disabled", true);
+		errorEl.hide();
+		save(path, contentsEl.val(), sequence, function(seq) {
+			sequence = seq;
+			el.modal("hide");
+			saved();
+		}, reportError);
+	});
+
+	el.modal("show");
+}
+
+// Rotate displays a modal that replaces the user's keys and shows the
+// progress of re-wrapping their files for the new key.
+// The rotate and status arguments are the page's rotate and rotateStatus
+// functions, which start a rotation and report its progress.
+function Rotate(rotate, status) {
+	var el = $("#mRotate");
+	var confirmEl = el.find(".up-rotate-confirm").show();
+	var doneEl = el.find(".up-rotate-done").hide();
+	var progressEl = el.find(".up-rotate-progress").hide();
+	var errorEl = el.find(".up-error").hide();
+	var warningEl = el.find(".up-warning").hide();
+	var button = el.find(".up-rotate-button").show().prop("disabled", false);
+
+	function reportError(err) {
+		errorEl.show().text(err);
+		button.prop("disabled", false);
+	}
+
+	function update(data) {
+		doneEl.show();
+		doneEl.find(".up-keydir").text(data.KeyDir);
+		if (data.SecretSeed) {
+			// Only the response that starts the rotation has the seed.
+			doneEl.find(".up-secretseed").text(data.SecretSeed);
+		}
+		if (data.Warning) {
+			warningEl.show().text(data.Warning);
+		}
+		if (!data.Rewrap) {
+			return;
+		}
+		progressEl.show();
+		var msg = "Re-wrapped " + data.Rewrapped + " of " + data.Files + " encrypted files";
+		if (data.Done) {
+			msg += ".";
+		} else {
+			msg += " found so far...";
+		}
+		progressEl.find(".up-rotate-message").text(msg);
+		var pct = data.Done ? 100 : 0;
+		if (!data.Done && data.Files > 0) {
+			pct = Math.floor(100 * data.Rewrapped / data.Files);
+		}
+		progressEl.find(".progress-bar").css("width", pct + "%");
+		var failedEl = progressEl.find(".up-rotate-failed").empty();
+		var failed = data.Failed || [];
+		for (var i=0; i<failed.length; i++) {
+			failedEl.append($("<li>").text("Could not re-wrap " + failed[i]));
+		}
+		if (data.Error) {
+			errorEl.show().text(data.Error);
+		}
+		if (!data.Done) {
+			window.setTimeout(function() {
+				status(update, reportError);
+			}, 1000);
+		}
+	}
+
+	button.off("click").click(function() {
+		button.prop("disabled", true);
+		errorEl.hide();
+		rotate(el.find(".up-rewrap").is(":checked"), function(data) {
+			confirmEl.hide();
+			button.hide();
+			update(data);
+		}, reportError);
+	});
+
+	el.modal("show");
+}
+
+// Config displays a modal that edits the settings in the active config file.
+// The getConfig and putConfig arguments are the page's functions of the
+// same names. Saving the settings reloads the page, as they may change the
+// servers that the browsers use.
+function Config(getConfig, putConfig) {
+	var el = $("#mConfig");
+	var errorEl = el.find(".up-error").hide();
+	var button = el.find(".up-save").prop("disabled", true);
+	var inputs = el.find("input[data-field]").val("");
+	var checksum = "";
+
+	function reportError(err) {
+		errorEl.show().text(err);
+		button.prop("disabled", false);
+	}
+
+	getConfig(function(data) {
+		checksum = data.Checksum;
+		el.find(".up-config-file").text(data.File);
+		inputs.each(function() {
+			var input = $(this);
+			input.val(data.Fields[input.data("field")]);
+		});
+		var other = data.Other || [];
+		el.find(".up-config-other").toggle(other.length > 0)
+			.find("code").text(other.join(", "));
+		button.prop("disabled", false);
+	}, reportError);
+
+	button.off("click").click(function() {
+		button.prop("disabled", true);
+		errorEl.hide();
+		var fields = {};
+		inputs.each(function() {
+			var input = $(this);
+			fields[input.data("field")] = $.trim(input.val());
+		});
+		putConfig(fields, checksum, function() {
+			window.location.reload();
+		}, reportError);
+	});
+
+	el.modal("show");
+}
+
+// Endpoints displays a modal that changes the user's directory and store
+// servers, or gives a read-only user servers. The getConfig and setEndpoints
+// arguments are the page's functions of the same names. Changing the
+// servers reloads the page, so that the browsers show the user's tree.
+function Endpoints(readOnly, getConfig, setEndpoints) {
+	var el = $("#mEndpoints");
+	var errorEl = el.find(".up-error").hide();
+	var button = el.find(".up-save");
+	var laddaButton = Ladda.create(button[0]);
+	el.find(".up-endpoints-readonly").toggle(readOnly);
+	el.find(".up-endpoints-move").toggle(!readOnly);
+
+	// hostName returns the host name of a config endpoint,
+	// such as "remote,upspin.example.com:443".
+	function hostName(ep) {
+		if (ep && ep.indexOf("inprocess") == 0) {
+			return "inprocess";
+		}
+		if (!ep || ep.indexOf("remote,") != 0) {
+			return "";
+		}
+		return ep.substr("remote,".length).replace(/:443$/, "");
+	}
+
+	if (!readOnly) {
+		getConfig(function(data) {
+			$("#endpointsDirServer").val(hostName(data.Fields.DirServer));
+			$("#endpointsStoreServer").val(hostName(data.Fields.StoreServer));
+		}, function(err) {
+			errorEl.show().text(err);
+		});
+	}
+
+	button.off("click").click(function() {
+		errorEl.hide();
+		laddaButton.start();
+		var dir = $.trim($("#endpointsDirServer").val());
+		var store = $.trim($("#endpointsStoreServer").val()) || dir;
+		setEndpoints(dir, store, function() {
+			window.location.reload();
+		}, function(err) {
+			laddaButton.stop();
+			errorEl.show().text(err);
+		});
+	});
+
+	el.modal("show");
+}
+
+// Cache displays a modal that shows the status of the active profile's
+// cacheserver and turns it on and off, restarts it, and flushes it.
+// The cache argument holds the page's functions for the cache API routes.
+function Cache(cache) {
+	var el = $("#mCache");
+	var errorEl = el.find(".up-error").hide();
+	var enabledEl = el.find(".up-cache-enabled");
+	var buttons = el.find(".up-cache-restart, .up-cache-flush");
+
+	function reportError(err) {
+		errorEl.show().text(err);
+		enabledEl.prop("disabled", false);
+		buttons.prop("disabled", false);
+	}
+
+	function update(data) {
+		enabledEl.prop("checked", data.Enabled).prop("disabled", false);
+		el.find(".up-cache-endpoint").text(data.Endpoint || "none");
+		var running = "not running";
+		if (data.Running && data.Managed) {
+			running = "running";
+		} else if (data.Running) {
+			running = "running (not started by upspin-ui)";
+		}
+		el.find(".up-cache-running").text(running);
+		el.find(".up-cache-dir").text(data.Dir);
+		el.find(".up-cache-size").text(data.Files + " files, " +
+			data.Size + " of " + data.SizeLimit + " bytes");
+		el.find(".up-cache-restart").prop("disabled", !data.Enabled || (data.Running && !data.Managed));
+		el.find(".up-cache-flush").prop("disabled", data.Running && !data.Managed);
+		if (data.Error) {
+			errorEl.show().text(data.Error);
+		}
+	}
+
+	function run(fn) {
+		return function() {
+			errorEl.hide();
+			enabledEl.prop("disabled", true);
+			buttons.prop("disabled", true);
+			fn(update, reportError);
+		};
+	}
+
+	enabledEl.off("change").change(run(function(success, error) {
+		cache.set(enabledEl.is(":checked"), success, error);
+	}));
+	el.find(".up-cache-restart").off("click").click(run(cache.restart));
+	el.find(".up-cache-flush").off("click").click(run(cache.flush));
+
+	run(cache.status)();
+	el.modal("show");
+}
+
 // Browser instantiates an Upspin tree browser and appends it to parentEl.
 function Browser(parentEl, page) {
 	var browser = {
 		path: "",
 		entries: [],
+		as: "", // Server user as whom this pane acts, if any.
 		navigate: navigate,
 		refresh: refresh,
 		reportError: reportError
@@ -125,12 +422,36 @@ function Browser(parentEl, page) {
 	var el = $("body > .up-template.up-browser").clone().removeClass("up-template");
 	el.appendTo(parentEl);
 
+	// Offer to act as one of the current user's server users, if any.
+	var asEl = el.find(".up-as").hide();
+	var serverUsers = page.serverUsers();
+	if (serverUsers.length > 0) {
+		var asSel = asEl.find("select");
+		var users = [page.username()].concat(serverUsers);
+		for (var i=0; i<users.length; i++) {
+			asSel.append($("<option/>").attr("value", users[i]).text(users[i]));
+		}
+		asSel.change(function() {
+			var u = asSel.val();
+			browser.as = u == page.username() ? "" : u;
+			navigate(u + "/");
+		});
+		asEl.show();
+	}
+
+	// writable reports whether this pane may modify the tree. Server users
+	// have servers of their own even if the current user does not.
+	function writable() {
+		return !page.readOnly() || browser.as != "";
+	}
+
 	var firstNav = true;
 	function navigate(path) {
 		browser.path = path;
+		el.find(".up-mkdir, .up-delete, .up-copy").toggle(writable());
 		drawPath();
 		drawLoading("Loading directory...");
-		page.list(path, function(entries) {
+		page.list(path, function(entries, partialError) {
 			var isOwnRoot = path == page.username()+"/";
 			var noEntries = !entries || entries.length == 0;
 			if (firstNav && isOwnRoot && noEntries) {
@@ -138,6 +459,10 @@ function Browser(parentEl, page) {
 			}
 			firstNav = false;
 			drawEntries(entries);
+			if (partialError) {
+				// Some entries could not be listed.
+				errorEl.show().text(partialError);
+			}
 		}, function(error) {
 			reportError(error);
 		});
@@ -162,6 +487,10 @@ function Browser(parentEl, page) {
 		if (!e.originalEvent.dataTransfer || e.originalEvent.dataTransfer.files.length == 0) {
 			return;
 		}
+		if (!writable()) {
+			reportError("You cannot upload files in read-only mode.");
+			return;
+		}
 
 		drawLoading("Uploading files...");
 
@@ -313,9 +642,22 @@ function Browser(parentEl, page) {
 			} else if (isLink) {
 				glyph = "share-alt";
 			}
-			entryEl.find(".up-entry-icon").addClass("glyphicon-"+glyph);
-
 			var name = entry.Name;
+			var query = "?token=" + entry.FileToken;
+			if (browser.as) {
+				query += "&as=" + encodeURIComponent(browser.as);
+			}
+			var preview = isDir || isLink ? null : PreviewKind(name);
+
+			var iconEl = entryEl.find(".up-entry-icon");
+			if (preview == "image") {
+				iconEl.removeClass("glyphicon").append($("<img>")
+					.addClass("up-entry-thumbnail")
+					.attr("src", "/_thumbnail/" + name + query));
+			} else {
+				iconEl.addClass("glyphicon-"+glyph);
+			}
+
 			var shortName = name.slice(name.lastIndexOf("/")+1);
 			var nameEl = entryEl.find(".up-entry-name");
 			if (isDir) {
@@ -328,9 +670,13 @@ function Browser(parentEl, page) {
 						navigate(p);
 					});
 			} else {
+				var href = "/" + name + query;
+				if (preview) {
+					href = "/_preview/" + name + query;
+				}
 				$("<a>")
 					.text(shortName)
-					.attr("href", "/" + name + "?token=" + entry.FileToken)
+					.attr("href", href)
 					.attr("target", "_blank")
 					.appendTo(nameEl);
 			}
@@ -344,10 +690,20 @@ function Browser(parentEl, page) {
 
 			entryEl.find(".up-entry-time").text(FormatEntryTime(entry));
 
+			var editEl = entryEl.find(".up-entry-edit");
+			if (isDir || isLink) {
+				editEl.hide();
+			} else {
+				editEl.data("up-path", name);
+				editEl.click(function() {
+					Edit($(this).data("up-path"), page.get, page.save, refresh);
+				});
+			}
+
 			var inspectEl = entryEl.find(".up-entry-inspect");
-			inspectEl.data("up-entry", entry);
+			inspectEl.data("up-entry", entry).data("up-query", query);
 			inspectEl.click(function() {
-				Inspect($(this).closest(".up-entry").data("up-entry"));
+				Inspect($(this).data("up-entry"), $(this).data("up-query"));
 			});
 
 			parent.append(entryEl);
@@ -365,25 +721,95 @@ function Browser(parentEl, page) {
 
 // Startup manages the signup process and fetches the name of the logged-in
 // user and the XSRF token for making subsequent requests.
-function Startup(xhr, doneCallback) {
+// waitVerified waits for the user in the "verify" step to click the link
+// in the verification email.
+function Startup(xhr, waitVerified, doneCallback) {
+
+	// keyServer returns the key server and TLS certificate directory
+	// chosen on the signup dialog. Empty values select the defaults.
+	function keyServer() {
+		return {
+			KeyServer: $.trim($("#signupKeyServer").val()),
+			TLSCerts: $.trim($("#signupTLSCerts").val())
+		};
+	}
 
-	$("#mSignup").find("button").click(function() {
-		action({
-			action: "signup",
-			username: $("#signupUserName").val(),
-		});
+	$("#mSignup").find(".up-signup-keyserver").hide();
+	$("#mSignup").find(".up-signup-advanced").click(function(e) {
+		e.preventDefault();
+		$(this).hide();
+		$("#mSignup").find(".up-signup-keyserver").show();
+	});
+	$("#mSignup").find("button.up-signup").click(function() {
+		action($.extend({
+			Action: "signup",
+			UserName: $("#signupUserName").val()
+		}, keyServer()));
+	});
+	$("#mSignup").find("button.up-recover").click(function() {
+		show({Step: "recover"});
+	});
+
+	$("#mRecover").find("button.up-recover").click(function() {
+		action($.extend({
+			Action: "recover",
+			UserName: $("#recoverUserName").val(),
+			SecretSeed: $("#recoverSecretSeed").val()
+		}, keyServer()));
+	});
+	$("#mRecover").find("button.up-back").click(function() {
+		show({Step: "signup"});
 	});
 
 	$("#mSecretSeed").find("button").click(function() {
 		action();
 	});
 
-	$("#mVerify").find("button.up-resend").click(function() {
-		action({action: "register"});
+	$("#mConfirmSeed").find("button.up-confirm").click(function() {
+		var words = [];
+		$("#mConfirmSeed").find(".up-seed-words input").each(function() {
+			words.push($.trim($(this).val()));
+		});
+		action({
+			Action: "confirmSeed",
+			SeedWords: words
+		});
 	});
-	$("#mVerify").find("button.up-proceed").click(function() {
-		action();
+	$("#mConfirmSeed").find("button.up-show").click(function() {
+		action({Action: "showSeed"});
+	});
+
+	$("#mVerify").find("button.up-show").click(function() {
+		action({Action: "showSeed"});
 	});
+	$("#mVerify").find("button.up-resend").click(function() {
+		action({Action: "register"});
+	});
+
+	// awaitVerification waits for the user to click the verification
+	// link while the "verify" step is shown, and then moves on.
+	var awaiting = false;
+	function awaitVerification() {
+		if (awaiting || step != "verify") {
+			return;
+		}
+		awaiting = true;
+		waitVerified(function(resp) {
+			awaiting = false;
+			if (step != "verify") {
+				return;
+			}
+			if (resp.Registered) {
+				action();
+				return;
+			}
+			awaitVerification();
+		}, function(err) {
+			awaiting = false;
+			console.log("waiting for verification:", err);
+			window.setTimeout(awaitVerification, 5000);
+		});
+	}
 
 	$("#mServerSelect").find("button").click(function() {
 		switch (true) {
@@ -394,16 +820,16 @@ function Startup(xhr, doneCallback) {
 			show({Step: "serverGCP"});
 			break;
 		case $("#serverSelectNone").is(":checked"):
-			action({action: "specifyNoEndpoints"});
+			action({Action: "specifyNoEndpoints"});
 			break;
 		}
 	});
 
 	$("#mServerExisting").find("button").click(function() {
 		action({
-			action: "specifyEndpoints",
-			dirServer: $("#serverExistingDirServer").val(),
-			storeServer: $("#serverExistingStoreServer").val()
+			Action: "specifyEndpoints",
+			DirServer: $("#serverExistingDirServer").val(),
+			StoreServer: $("#serverExistingStoreServer").val()
 		});
 	});
 
@@ -419,8 +845,8 @@ function Startup(xhr, doneCallback) {
 		};
 		r.onload = function(state) {
 			action({
-				action: "specifyGCP",
-				privateKeyData: r.result
+				Action: "specifyGCP",
+				PrivateKeyData: r.result
 			});
 		};
 		r.readAsText(fileEl[0].files[0]);
@@ -428,48 +854,48 @@ function Startup(xhr, doneCallback) {
 
 	$("#mGCPDetails").find("button").click(function() {
 		action({
-			action: "createGCP",
-			bucketName: $("#gcpDetailsBucketName").val(),
-			bucketLoc: $("#gcpDetailsBucketLoc").val(),
-			regionZone: $("#gcpDetailsRegionZone").val()
+			Action: "createGCP",
+			BucketName: $("#gcpDetailsBucketName").val(),
+			BucketLoc: $("#gcpDetailsBucketLoc").val(),
+			RegionZone: $("#gcpDetailsRegionZone").val()
 		});
 	});
 
 	$("#mServerUserName").find("button").click(function() {
 		action({
-			action: "configureServerUserName",
-			userNameSuffix: $("#serverUserNameSuffix").val()
+			Action: "configureServerUserName",
+			UserNameSuffix: $("#serverUserNameSuffix").val()
 		});
 	});
 
 	$("#mServerSecretSeed").find("button").click(function() {
 		// Performing an empty action will bounce the user to the next
-		// screen, serverHostName, with the server IP address populated
-		// by the server side.
+		// screen, confirmServerSeed, and then to serverHostName with
+		// the server IP address populated by the server side.
 		action({});
 	});
 
 	$("#mServerHostName").find("button").click(function() {
 		action({
-			action: "configureServerHostName",
-			hostName: $("#serverHostName").val()
+			Action: "configureServerHostName",
+			HostName: $("#serverHostName").val()
 		});
 	});
 
 	$("#mWaitServerHostName").find("button.btn-primary").click(function() {
-		action({action: "checkServerHostName"});
+		action({Action: "checkServerHostName"});
 	});
 	$("#mWaitServerHostName").find("button.btn-danger").click(function() {
 		action({
-			action: "checkServerHostName",
-			reset: "true"
+			Action: "checkServerHostName",
+			Reset: true
 		});
 	});
 
 	$("#mServerWriters").find("button").click(function() {
 		action({
-			action: "configureServer",
-			writers: $("#serverWriters").val()
+			Action: "configureServer",
+			Writers: $("#serverWriters").val()
 		});
 	});
 
@@ -489,11 +915,28 @@ function Startup(xhr, doneCallback) {
 		case "signup":
 			el = $("#mSignup");
 			break;
+		case "recover":
+			el = $("#mRecover");
+			break;
 		case "secretSeed":
 			el = $("#mSecretSeed");
 			$("#secretSeedKeyDir").text(data.KeyDir);
 			$("#secretSeedSecretSeed").text(data.SecretSeed);
 			break;
+		case "confirmSeed":
+		case "confirmServerSeed":
+			el = $("#mConfirmSeed");
+			el.find(".up-username").text(data.UserName);
+			var words = el.find(".up-seed-words").empty();
+			for (var i=0; i < data.SeedWords.length; i++) {
+				var group = $("<div/>").addClass("form-group");
+				group.append($("<label/>").text("Word " + data.SeedWords[i]));
+				group.append($("<input/>").attr("type", "text")
+					.attr("autocomplete", "off")
+					.addClass("form-control"));
+				words.append(group);
+			}
+			break;
 		case "verify":
 			el = $("#mVerify");
 			el.find(".up-username").text(data.UserName);
@@ -590,6 +1033,8 @@ function Startup(xhr, doneCallback) {
 		el.find("button, input, select").prop("disabled", false);
 		el.find(".up-error").hide();
 		el.modal("show");
+
+		awaitVerification();
 	}
 	function success(resp) {
 		if (!resp.Startup) {
@@ -634,6 +1079,7 @@ function Startup(xhr, doneCallback) {
 function Page() {
 	var page = {
 		username: "",
+		serverUsers: [],
 		key: ""
 	};
 
@@ -642,6 +1088,11 @@ function Page() {
 	function errorHandler(callback) {
 		return function(jqXHR, textStatus, errorThrown) {
 			console.log(textStatus, errorThrown);
+			var resp = jqXHR.responseJSON;
+			if (resp && resp.Error) {
+				callback(resp.Error.Message);
+				return;
+			}
 			if (errorThrown) {
 				callback(errorThrown);
 				return;
@@ -650,85 +1101,98 @@ function Page() {
 		}
 	}
 
-	function list(path, success, error) {
-		$.ajax("/_upspin", {
-			method: "POST",
-			data: {
-				key: page.key,
-				method: "list",
-				path: path,
-			},
-			dataType: "json",
-			success: function(data) {
-				if (data.Error) {
-					error(data.Error);
-					return;
+	// apiURL returns the URL for the given API route and Upspin path name.
+	function apiURL(route, path) {
+		var url = "/api/v1/" + route;
+		if (path) {
+			url += path.split("/").map(encodeURIComponent).join("/");
+		}
+		return url;
+	}
+
+	// request makes an API request with the given HTTP method, route, path
+	// and request object, calling success with the decoded response or
+	// error with a human-readable error string.
+	// acting is the user as whom requests are being made, or the empty
+	// string for the current user. It is set by the methods returned by
+	// actingAs for the duration of each call.
+	var acting = "";
+
+	// actingAs returns a copy of the given methods that make their requests
+	// as the server user returned by as, if any.
+	function actingAs(as, methods) {
+		var m = {};
+		$.each(methods, function(name, fn) {
+			m[name] = function() {
+				acting = as();
+				try {
+					return fn.apply(this, arguments);
+				} finally {
+					acting = "";
 				}
-				success(data.Entries);
-			},
-			error: errorHandler(error)
+			};
 		});
+		return m;
 	}
 
-	function rm(paths, success, error) {
-		$.ajax("/_upspin", {
-			method: "POST",
-			data: {
-				key: page.key,
-				method: "rm",
-				paths: paths
-			},
+	// headers returns the HTTP headers for an API request.
+	function headers() {
+		var h = {"X-Upspin-Key": page.key};
+		if (acting) {
+			h["X-Upspin-As"] = acting;
+		}
+		return h;
+	}
+
+	function request(method, route, path, data, success, error) {
+		var opts = {
+			method: method,
+			headers: headers(),
 			dataType: "json",
-			success: function(data) {
-				if (data.Error) {
-					error(data.Error);
-					return;
-				}
-				success();
-			},
+			success: success,
 			error: errorHandler(error)
-		});
+		};
+		if (data !== undefined) {
+			opts.contentType = "application/json";
+			opts.data = JSON.stringify(data);
+		}
+		$.ajax(apiURL(route, path), opts);
+	}
+
+	function list(path, success, error) {
+		request("GET", "dir/", path, undefined, function(data) {
+			success(data.Entries, data.Error);
+		}, error);
+	}
+
+	function rm(paths, success, error) {
+		request("POST", "delete", null, {Paths: paths}, function() {
+			success();
+		}, error);
 	}
 
 	function copy(paths, dest, success, error) {
-		$.ajax("/_upspin", {
-			method: "POST",
-			data: {
-				key: page.key,
-				method: "copy",
-				paths: paths,
-				dest: dest
-			},
-			dataType: "json",
-			success: function(data) {
-				if (data.Error) {
-					error(data.Error);
-					return;
-				}
-				success();
-			},
-			error: errorHandler(error)
-		});
+		request("POST", "copy", null, {Paths: paths, Dest: dest}, function() {
+			success();
+		}, error);
 	}
 
 	function mkdir(path, success, error) {
-		$.ajax("/_upspin", {
-			method: "POST",
-			data: {
-				key: page.key,
-				method: "mkdir",
-				path: path
-			},
-			dataType: "json",
-			success: function(data) {
-				if (data.Error) {
-					error(data.Error);
-					return;
-				}
-				success();
-			},
-			error: errorHandler(error)
-		});
+		request("PUT", "dir/", path, undefined, function() {
+			success();
+		}, error);
+	}
+
+	function get(path, success, error) {
+		request("GET", "text/", path, undefined, function(data) {
+			success(data.Contents, data.Sequence);
+		}, error);
+	}
+
+	function save(path, contents, sequence, success, error) {
+		request("PUT", "text/", path, {Contents: contents, Sequence: sequence}, function(data) {
+			success(data.Sequence);
+		}, error);
 	}
 
 	function put(dir, files, success, error) {
@@ -736,68 +1200,145 @@ function Page() {
 		// a FormData object and turn off any of the pre-processing
 		// jQuery might do.
 		var fd = new FormData();
-		fd.append("key", page.key);
-		fd.append("method", "put");
-		fd.append("dir", dir);
 		for (var i = 0; i < files.length; i++) {
 			fd.append("file"+i, files[i]);
 		}
-		$.ajax("/_upspin", {
+		$.ajax(apiURL("upload/", dir), {
 			method: "POST",
+			headers: headers(),
 			data: fd,
 			contentType: false,
 			processData: false,
 			cache: false,
 			dataType: "json",
-			success: function(data) {
-				if (data.Error) {
-					error(data.Error);
-					return;
-				}
+			success: function() {
 				success();
 			},
 			error: errorHandler(error)
 		});
 	}
 
-	function startup(data, success, error) {
-		$.ajax("/_upspin", {
-			method: "POST",
-			data: $.extend({
-				key: page.key,
-				method: "startup"
-			}, data),
-			dataType: "json",
-			success: function(data) {
-				if (data.Error) {
-					error(data.Error);
-					return;
+	function rotate(rewrap, success, error) {
+		request("POST", "keys/rotate", null, {Rewrap: rewrap}, success, error);
+	}
+
+	function rotateStatus(success, error) {
+		request("GET", "keys/rotate", null, undefined, success, error);
+	}
+
+	function getConfig(success, error) {
+		request("GET", "config", null, undefined, success, error);
+	}
+
+	function putConfig(fields, checksum, success, error) {
+		request("PUT", "config", null, {Fields: fields, Checksum: checksum}, success, error);
+	}
+
+	function setEndpoints(dir, store, success, error) {
+		request("POST", "endpoints", null, {DirServer: dir, StoreServer: store}, success, error);
+	}
+
+	var cache = {
+		status: function(success, error) {
+			request("GET", "cache", null, undefined, success, error);
+		},
+		set: function(enabled, success, error) {
+			request("PUT", "cache", null, {Enabled: enabled}, success, error);
+		},
+		restart: function(success, error) {
+			request("POST", "cache/restart", null, undefined, success, error);
+		},
+		flush: function(success, error) {
+			request("POST", "cache/flush", null, undefined, success, error);
+		}
+	};
+
+	function profiles(success, error) {
+		request("GET", "profiles", null, undefined, function(data) {
+			success(data.Profiles);
+		}, error);
+	}
+
+	function switchProfile(name, success, error) {
+		request("POST", "profiles", null, {Name: name}, function(data) {
+			success(data.Profiles);
+		}, error);
+	}
+
+	// showProfiles offers a choice of profiles in the header if there is
+	// more than one. Choosing one switches to it and reloads the page,
+	// as the file tokens issued for the previous profile are no longer
+	// valid.
+	function showProfiles() {
+		profiles(function(ps) {
+			var sel = $("#headerProfile").empty();
+			var usable = 0;
+			for (var i=0; i<ps.length; i++) {
+				var p = ps[i];
+				var label = p.Name;
+				if (p.UserName) {
+					label += " (" + p.UserName + ")";
 				}
-				success(data);
-			},
-			error: errorHandler(error)
+				var opt = $("<option/>").attr("value", p.Name).text(label);
+				if (p.Error) {
+					opt.prop("disabled", true).attr("title", p.Error);
+				} else {
+					usable++;
+				}
+				if (p.Active) {
+					opt.prop("selected", true);
+				}
+				sel.append(opt);
+			}
+			if (usable < 2) {
+				return;
+			}
+			sel.removeClass("up-template").off("change").change(function() {
+				switchProfile(sel.val(), function() {
+					window.location.reload();
+				}, function(err) {
+					alert(err);
+					showProfiles();
+				});
+			});
+		}, function(err) {
+			console.log("listing profiles:", err);
 		});
 	}
 
+	function startup(data, success, error) {
+		request("POST", "startup", null, data || {}, success, error);
+	}
+
+	function waitVerified(success, error) {
+		request("GET", "startup/verify", null, undefined, success, error);
+	}
+
 	function startBrowsers(leftPath, rightPath) {
 		var browser1, browser2;
 		var parentEl = $(".up-browser-parent");
-		var methods = {
+		var shared = {
 			username: function() { return page.username; },
+			serverUsers: function() { return page.serverUsers; },
+			readOnly: function() { return page.readOnly; }
+		};
+		var methods = {
 			rm: rm,
 			copy: copy,
 			list: list,
 			mkdir: mkdir,
-			put: put
+			put: put,
+			get: get,
+			save: save
 		}
 		browser1 = new Browser(parentEl, $.extend({
 			copyDestination: function() { return browser2.path },
 			refreshDestination: function() { browser2.refresh(); }
-		}, methods));
+		}, shared, actingAs(function() { return browser1.as; }, methods)));
 		browser2 = new Browser(parentEl, $.extend({
 			copyDestination: function() { return browser1.path },
 			refreshDestination: function() { browser1.refresh(); }
-		}, methods));
+		}, shared, actingAs(function() { return browser2.as; }, methods)));
 		browser1.navigate(leftPath);
 		browser2.navigate(rightPath);
 	}
@@ -828,12 +1369,32 @@ function Page() {
 	}
 
 	// Begin the Startup sequence.
-	Startup(startup, function(data) {
+	Startup(startup, waitVerified, function(data) {
 		// When startup is complete, note the
 		// user name and launch the browsers.
 		page.username = data.UserName;
+		page.serverUsers = data.ServerUsers || [];
+		page.readOnly = !!data.ReadOnly;
+		$("#headerReadOnly").toggleClass("up-template", !page.readOnly);
+		$("#headerEndpoints").removeClass("up-template").click(function(e) {
+			e.preventDefault();
+			Endpoints(page.readOnly, getConfig, setEndpoints);
+		});
 		$("#headerUsername").text(page.username);
 		$("#headerVersion").text(data.Version);
+		showProfiles();
+		$("#headerRotate").removeClass("up-template").click(function(e) {
+			e.preventDefault();
+			Rotate(rotate, rotateStatus);
+		});
+		$("#headerConfig").removeClass("up-template").click(function(e) {
+			e.preventDefault();
+			Config(getConfig, putConfig);
+		});
+		$("#headerCache").removeClass("up-template").click(function(e) {
+			e.preventDefault();
+			Cache(cache);
+		});
 		startBrowsers(data.LeftPath, data.RightPath);
 	});
 }
@@ -0,0 +1,187 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+const (
+	// verifyMinInterval and verifyMaxInterval bound the interval at which
+	// the key server is polled for a user who has yet to verify their
+	// email address. The interval doubles after each unsuccessful poll.
+	verifyMinInterval = 2 * time.Second
+	verifyMaxInterval = time.Minute
+
+	// verifyWaitTimeout is the longest that a request for the
+	// verification status waits for the user to be registered.
+	verifyWaitTimeout = 30 * time.Second
+
+	// resendInterval is the shortest interval at which verification
+	// emails may be sent to a user.
+	resendInterval = time.Minute
+)
+
+// verifyStatus is the response to requests for the verification status.
+type verifyStatus struct {
+	UserName upspin.UserName
+
+	// Registered reports whether the user is present on the key server,
+	// meaning they have clicked the verification link.
+	Registered bool
+
+	// ResendIn is the number of seconds until another verification
+	// email may be sent.
+	ResendIn int
+}
+
+// verifier polls the key server, with backoff, until its user appears.
+type verifier struct {
+	user upspin.UserName
+	ks   keyServerSettings
+
+	registered chan struct{} // Closed when the user is registered.
+	wake       chan struct{} // Restarts the backoff.
+	stop       chan struct{} // Closed when the verifier is no longer needed.
+
+	mu   sync.Mutex
+	sent time.Time // When the last verification email was sent.
+}
+
+// poll polls the key server until the user is registered
+// or the verifier is stopped.
+func (v *verifier) poll() {
+	interval := verifyMinInterval
+	for {
+		ok, err := isRegistered(v.ks, v.user)
+		if err != nil {
+			logf("verify: looking up %s: %v", v.user, err)
+		}
+		if ok {
+			logf("verify: %s is registered", v.user)
+			close(v.registered)
+			return
+		}
+		select {
+		case <-v.stop:
+			return
+		case <-v.wake:
+			interval = verifyMinInterval
+		case <-time.After(interval):
+			if interval *= 2; interval > verifyMaxInterval {
+				interval = verifyMaxInterval
+			}
+		}
+	}
+}
+
+func (v *verifier) isRegistered() bool {
+	select {
+	case <-v.registered:
+		return true
+	default:
+		return false
+	}
+}
+
+// status returns the verification status of the user.
+func (v *verifier) status() verifyStatus {
+	v.mu.Lock()
+	wait := resendInterval - time.Since(v.sent)
+	v.mu.Unlock()
+	st := verifyStatus{
+		UserName:   v.user,
+		Registered: v.isRegistered(),
+	}
+	if wait > 0 {
+		st.ResendIn = int((wait + time.Second - 1) / time.Second)
+	}
+	return st
+}
+
+// watchRegistration returns the verifier for the user in cfg,
+// starting one, and stopping any other, if there is none.
+func (s *server) watchRegistration(cfg upspin.Config) *verifier {
+	ks := configKeyServer(cfg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v := s.verifier; v != nil && v.user == cfg.UserName() && v.ks == ks {
+		return v
+	}
+	s.stopVerifier()
+	v := &verifier{
+		user:       cfg.UserName(),
+		ks:         ks,
+		registered: make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	s.verifier = v
+	go v.poll()
+	return v
+}
+
+// stopVerifier stops and forgets the current verifier, if any.
+// s.mu must be held.
+func (s *server) stopVerifier() {
+	if s.verifier != nil {
+		close(s.verifier.stop)
+		s.verifier = nil
+	}
+}
+
+// checkResend reports an error if a verification email was sent to the
+// user in cfg too recently for another to be sent.
+func (s *server) checkResend(cfg upspin.Config) error {
+	s.mu.Lock()
+	v := s.verifier
+	s.mu.Unlock()
+	if v == nil || v.user != cfg.UserName() {
+		return nil
+	}
+	if st := v.status(); st.ResendIn > 0 {
+		return errors.E(errors.Transient, errors.Errorf("a verification email was sent less than %v ago; wait %d seconds before sending another", resendInterval, st.ResendIn))
+	}
+	return nil
+}
+
+// sentVerification records that a verification email was sent to the user
+// in cfg, and polls the key server promptly for their registration.
+func (s *server) sentVerification(cfg upspin.Config) {
+	v := s.watchRegistration(cfg)
+	v.mu.Lock()
+	v.sent = time.Now()
+	v.mu.Unlock()
+	select {
+	case v.wake <- struct{}{}:
+	default:
+	}
+}
+
+// waitVerified waits for up to the given time, or until ctx is done, for
+// the user awaiting verification to be registered with the key server,
+// and returns their verification status.
+func (s *server) waitVerified(ctx context.Context, timeout time.Duration) (verifyStatus, error) {
+	s.mu.Lock()
+	v, cfg := s.verifier, s.cfg
+	s.mu.Unlock()
+	if v == nil {
+		if cfg != nil {
+			return verifyStatus{UserName: cfg.UserName(), Registered: true}, nil
+		}
+		return verifyStatus{}, errors.E(errors.NotExist, errors.Str("no user is awaiting verification"))
+	}
+	select {
+	case <-v.registered:
+	case <-ctx.Done():
+	case <-time.After(timeout):
+	}
+	return v.status(), nil
+}
@@ -0,0 +1,384 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"upspin.io/errors"
+	"upspin.io/upspin"
+)
+
+// davPrefix is the URL path prefix under which the WebDAV handler serves the
+// Upspin name space. The path /_webdav/ann@example.com/foo corresponds to the
+// Upspin path name ann@example.com/foo.
+const davPrefix = "/_webdav"
+
+// newDAVHandler returns a WebDAV handler that serves the Upspin name space
+// using the given server's client.
+func newDAVHandler(s *server) http.Handler {
+	return &webdav.Handler{
+		Prefix:     davPrefix,
+		FileSystem: davFS{s},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logf("webdav: %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+}
+
+// serveDAV serves WebDAV requests. As WebDAV clients cannot supply the
+// session key as a form value, they must instead provide it as the password
+// in HTTP Basic authentication. The user name is ignored.
+// The request's context carries the config and client with which it is
+// served; see davFS.client.
+func (s *server) serveDAV(w http.ResponseWriter, r *http.Request) {
+	_, password, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(s.key)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="upspin-ui"`)
+		http.Error(w, "Invalid key", http.StatusUnauthorized)
+		return
+	}
+	cfg, cli := s.client()
+	if cli == nil {
+		http.Error(w, "No configuration", http.StatusServiceUnavailable)
+		return
+	}
+	if davMutates[r.Method] && isReadOnly(cfg) {
+		http.Error(w, readOnlyError.Error(), http.StatusForbidden)
+		return
+	}
+	ctx := context.WithValue(r.Context(), davClientKey{}, davClient{cfg, cli})
+	s.dav.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// davClientKey is the context key for the davClient of a WebDAV request.
+type davClientKey struct{}
+
+// davClient holds the config and client with which a WebDAV request is
+// served, so that a profile switch does not take effect part way through.
+type davClient struct {
+	cfg upspin.Config
+	cli upspin.Client
+}
+
+// davMutates holds the WebDAV methods that modify the tree.
+var davMutates = map[string]bool{
+	"PUT":       true,
+	"MKCOL":     true,
+	"DELETE":    true,
+	"COPY":      true,
+	"MOVE":      true,
+	"PROPPATCH": true,
+}
+
+// davFS implements webdav.FileSystem using the server's Upspin client.
+// The root of the file system contains the current user's root directory.
+type davFS struct {
+	s *server
+}
+
+// client returns the config and client with which the request whose
+// context is ctx is served.
+func (fs davFS) client(ctx context.Context) (upspin.Config, upspin.Client) {
+	if c, ok := ctx.Value(davClientKey{}).(davClient); ok {
+		return c.cfg, c.cli
+	}
+	return fs.s.client()
+}
+
+// davName converts a WebDAV file name to an Upspin path name.
+// It returns the empty string for the root of the file system.
+func davName(name string) upspin.PathName {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name != "" && !strings.Contains(name, "/") {
+		// A user's root.
+		name += "/"
+	}
+	return upspin.PathName(name)
+}
+
+// osError converts an Upspin error to the equivalent os package error,
+// so that the webdav package reports the correct HTTP status.
+func osError(op string, name upspin.PathName, err error) error {
+	if err == nil {
+		return nil
+	}
+	var osErr error
+	switch {
+	case errors.Match(errors.E(errors.NotExist), err):
+		osErr = os.ErrNotExist
+	case errors.Match(errors.E(errors.Exist), err):
+		osErr = os.ErrExist
+	case errors.Match(errors.E(errors.Permission), err),
+		errors.Match(errors.E(errors.Private), err):
+		osErr = os.ErrPermission
+	default:
+		return err
+	}
+	return &os.PathError{Op: op, Path: string(name), Err: osErr}
+}
+
+func (fs davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p := davName(name)
+	if p == "" {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	_, cli := fs.client(ctx)
+	_, err := cli.MakeDirectory(p)
+	return osError("mkdir", p, err)
+}
+
+func (fs davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	cfg, cli := fs.client(ctx)
+	p := davName(name)
+	// Only writes replace the file. The webdav package opens files
+	// O_RDWR without O_CREATE or O_TRUNC to set their properties,
+	// which is treated as opening them for reading.
+	if flag&(os.O_WRONLY|os.O_CREATE|os.O_TRUNC) != 0 {
+		if p == "" {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+		}
+		if flag&os.O_EXCL != 0 {
+			if _, err := cli.Lookup(p, true); err == nil {
+				return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+			}
+		}
+		// Upspin files are written in their entirety,
+		// so any existing content is replaced.
+		f, err := cli.Create(p)
+		if err != nil {
+			return nil, osError("open", p, err)
+		}
+		return &davFile{cfg: cfg, cli: cli, name: p, w: f, modTime: time.Now()}, nil
+	}
+
+	if p == "" {
+		return &davFile{cfg: cfg, cli: cli}, nil
+	}
+	de, err := cli.Lookup(p, true)
+	if err != nil {
+		return nil, osError("open", p, err)
+	}
+	f := &davFile{cfg: cfg, cli: cli, name: p, entry: de}
+	if !de.IsDir() {
+		f.r, err = newBlockReader(cfg, de)
+		if err != nil {
+			return nil, osError("open", p, err)
+		}
+	}
+	return f, nil
+}
+
+func (fs davFS) RemoveAll(ctx context.Context, name string) error {
+	p := davName(name)
+	if p == "" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	_, cli := fs.client(ctx)
+	return osError("remove", p, rm(cli, p))
+}
+
+func (fs davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldp, newp := davName(oldName), davName(newName)
+	if oldp == "" || newp == "" {
+		return &os.PathError{Op: "rename", Path: oldName, Err: os.ErrPermission}
+	}
+	_, cli := fs.client(ctx)
+	_, err := cli.Rename(oldp, newp)
+	return osError("rename", oldp, err)
+}
+
+func (fs davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	p := davName(name)
+	if p == "" {
+		return rootInfo{}, nil
+	}
+	_, cli := fs.client(ctx)
+	de, err := cli.Lookup(p, true)
+	if err != nil {
+		return nil, osError("stat", p, err)
+	}
+	return entryInfo{de}, nil
+}
+
+// davFile implements webdav.File. It reads a file or lists a directory
+// named by entry, or writes a new file through w, or, if name is empty,
+// lists the root of the file system. It uses the config and client of the
+// request that opened it.
+type davFile struct {
+	cfg  upspin.Config
+	cli  upspin.Client
+	name upspin.PathName
+
+	// For reading files and directories.
+	entry *upspin.DirEntry
+	r     *blockReader
+	dir   []os.FileInfo // Unread directory entries; nil until first Readdir.
+
+	// For writing files.
+	w       upspin.File
+	written int64
+	modTime time.Time
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, &os.PathError{Op: "read", Path: string(f.name), Err: errors.Str("not a readable file")}
+	}
+	return f.r.Read(p)
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if f.r == nil {
+		if f.w != nil {
+			return f.w.Seek(offset, whence)
+		}
+		return 0, nil
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	if f.w == nil {
+		return 0, &os.PathError{Op: "write", Path: string(f.name), Err: errors.Str("file not open for writing")}
+	}
+	n, err := f.w.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.dir == nil {
+		if err := f.loadDir(); err != nil {
+			return nil, err
+		}
+	}
+	if count <= 0 {
+		fis := f.dir
+		f.dir = f.dir[:0]
+		return fis, nil
+	}
+	if len(f.dir) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.dir) {
+		count = len(f.dir)
+	}
+	fis := f.dir[:count]
+	f.dir = f.dir[count:]
+	return fis, nil
+}
+
+// loadDir reads the entries of the directory into f.dir.
+func (f *davFile) loadDir() error {
+	f.dir = []os.FileInfo{}
+	if f.name == "" {
+		// The root of the file system holds the user's root.
+		de, err := f.cli.Lookup(upspin.PathName(f.cfg.UserName())+"/", true)
+		if err != nil {
+			return osError("readdir", f.name, err)
+		}
+		f.dir = append(f.dir, entryInfo{de})
+		return nil
+	}
+	if f.entry == nil || !f.entry.IsDir() {
+		return &os.PathError{Op: "readdir", Path: string(f.name), Err: errors.Str("not a directory")}
+	}
+	des, err := f.cli.Glob(upspin.AllFilesGlob(f.entry.Name))
+	if err != nil && err != upspin.ErrFollowLink {
+		return osError("readdir", f.name, err)
+	}
+	for _, de := range des {
+		f.dir = append(f.dir, entryInfo{de})
+	}
+	return nil
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	switch {
+	case f.w != nil:
+		return writeInfo{f}, nil
+	case f.name == "":
+		return rootInfo{}, nil
+	}
+	return entryInfo{f.entry}, nil
+}
+
+func (f *davFile) Close() error {
+	var err error
+	if f.r != nil {
+		err = f.r.Close()
+	}
+	if f.w != nil {
+		err = osError("close", f.name, f.w.Close())
+	}
+	return err
+}
+
+// entryInfo implements os.FileInfo for a DirEntry.
+type entryInfo struct {
+	de *upspin.DirEntry
+}
+
+func (fi entryInfo) Name() string {
+	name := strings.TrimSuffix(string(fi.de.Name), "/")
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func (fi entryInfo) Size() int64 {
+	size, _ := fi.de.Size()
+	return size
+}
+
+func (fi entryInfo) Mode() os.FileMode {
+	if fi.de.IsDir() {
+		return os.ModeDir | 0700
+	}
+	return 0600
+}
+
+func (fi entryInfo) ModTime() time.Time { return fi.de.Time.Go() }
+func (fi entryInfo) IsDir() bool        { return fi.de.IsDir() }
+func (fi entryInfo) Sys() interface{}   { return fi.de }
+
+// ETag implements webdav.ETager, so that WebDAV clients see the same entity
+// tags as those served by serveContent.
+func (fi entryInfo) ETag(ctx context.Context) (string, error) {
+	return entryETag(fi.de), nil
+}
+
+// rootInfo implements os.FileInfo for the root of the WebDAV file system.
+type rootInfo struct{}
+
+func (rootInfo) Name() string       { return "/" }
+func (rootInfo) Size() int64        { return 0 }
+func (rootInfo) Mode() os.FileMode  { return os.ModeDir | 0500 }
+func (rootInfo) ModTime() time.Time { return time.Time{} }
+func (rootInfo) IsDir() bool        { return true }
+func (rootInfo) Sys() interface{}   { return nil }
+
+// writeInfo implements os.FileInfo for a file that is being written.
+type writeInfo struct {
+	f *davFile
+}
+
+func (fi writeInfo) Name() string       { return path.Base(string(fi.f.name)) }
+func (fi writeInfo) Size() int64        { return fi.f.written }
+func (fi writeInfo) Mode() os.FileMode  { return 0600 }
+func (fi writeInfo) ModTime() time.Time { return fi.f.modTime }
+func (fi writeInfo) IsDir() bool        { return false }
+func (fi writeInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,98 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"upspin.io/upspin"
+)
+
+// davRequest sends a WebDAV request for the given Upspin path name to ts,
+// authenticating with the key of s.
+func davRequest(t *testing.T, s *server, ts *httptest.Server, method string, name upspin.PathName, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+davPrefix+"/"+string(name), strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("", s.key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestDAVProppatchKeepsContent(t *testing.T) {
+	const user = "dav-proppatch@example.com"
+	s, err := newInProcessServer(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	const content = "do not clobber me"
+	name := upspin.PathName(user + "/proppatch.txt")
+	_, cli := s.client()
+	if _, err := cli.Put(name, []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	// File managers set properties like this on files they copy or touch.
+	const body = `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:upspin-ui-test">
+  <D:set><D:prop><Z:color>red</Z:color></D:prop></D:set>
+</D:propertyupdate>`
+	resp := davRequest(t, s, ts, "PROPPATCH", name, body)
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("PROPPATCH %s: status %q, want %d", name, resp.Status, http.StatusMultiStatus)
+	}
+
+	got, err := cli.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("after PROPPATCH, %s holds %q, want %q", name, got, content)
+	}
+}
+
+func TestDAVPutReplacesContent(t *testing.T) {
+	const user = "dav-put@example.com"
+	s, err := newInProcessServer(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	name := upspin.PathName(user + "/put.txt")
+	_, cli := s.client()
+	if _, err := cli.Put(name, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	const content = "new"
+	resp := davRequest(t, s, ts, "PUT", name, content)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT %s: status %q, want %d", name, resp.Status, http.StatusCreated)
+	}
+
+	got, err := cli.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("after PUT, %s holds %q, want %q", name, got, content)
+	}
+}
@@ -0,0 +1,587 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fakegcp implements a local stand-in for the subset of the Google
+// Cloud Platform APIs used by upspin-ui to deploy an upspinserver:
+// Service Management, Compute, IAM, and Storage.
+//
+// The fake keeps its state in memory, completes every long-running operation
+// on the first poll, and does not verify credentials beyond requiring that a
+// token obtained from its token endpoint is presented.
+// Failures may be injected for individual API methods using Fail.
+package fakegcp // import "augie.upspin.io/fakegcp"
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+	iam "google.golang.org/api/iam/v1"
+	servicemanagement "google.golang.org/api/servicemanagement/v1"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// token is the access token issued by the fake token endpoint.
+const token = "fakegcp-token"
+
+// Server is a fake Google Cloud Platform API server.
+type Server struct {
+	// URL is the base URL of the server, with no trailing slash.
+	// The APIs are served beneath URL+"/compute/", URL+"/iam/",
+	// URL+"/servicemanagement/", and URL+"/storage/", and OAuth2 tokens
+	// are issued at URL+"/token".
+	URL string
+
+	// ProjectID is the ID of the only project known to the server.
+	ProjectID string
+
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	calls     map[string]int
+	failures  map[string][]*apiError
+	nextOp    int
+	enabled   map[string]bool
+	accounts  map[string]*iam.ServiceAccount // Keyed by email.
+	keys      map[string]int                 // Number of keys, keyed by email.
+	buckets   map[string]*storage.Bucket
+	addresses map[string]*compute.Address  // Keyed by region/name.
+	firewalls map[string]*compute.Firewall // Keyed by name.
+	instances map[string]*compute.Instance // Keyed by zone/name.
+}
+
+// regions are the Compute regions and zones reported by the server.
+var regions = []struct {
+	name   string
+	status string
+	zones  []string
+}{
+	{"us-central1", "UP", []string{"us-central1-a", "us-central1-b"}},
+	{"europe-west1", "UP", []string{"europe-west1-b", "europe-west1-c"}},
+	{"asia-east1", "DOWN", []string{"asia-east1-a"}},
+}
+
+// validZone reports whether the given region, or zone if non-empty, exists.
+func validZone(region, zone string) bool {
+	for _, r := range regions {
+		if zone == "" && r.name == region {
+			return true
+		}
+		for _, z := range r.zones {
+			if zone != "" && z == zone {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewServer starts and returns a new Server for the given project ID.
+// The caller should call Close when finished, to shut it down.
+func NewServer(projectID string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ProjectID: projectID,
+		key:       key,
+		calls:     make(map[string]int),
+		failures:  make(map[string][]*apiError),
+		enabled:   make(map[string]bool),
+		accounts:  make(map[string]*iam.ServiceAccount),
+		keys:      make(map[string]int),
+		buckets:   make(map[string]*storage.Bucket),
+		addresses: make(map[string]*compute.Address),
+		firewalls: make(map[string]*compute.Firewall),
+		instances: make(map[string]*compute.Instance),
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s, nil
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// ServiceAccountKey returns a JSON-encoded service account private key for
+// an owner of the project, like that downloaded from the Cloud Console.
+// Its token URI refers to the server's token endpoint.
+func (s *Server) ServiceAccountKey() []byte {
+	pemKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(s.key),
+	})
+	b, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     s.ProjectID,
+		"private_key_id": "fakegcp",
+		"private_key":    string(pemKey),
+		"client_email":   "owner@" + s.ProjectID + ".iam.gserviceaccount.com",
+		"client_id":      "1",
+		"token_uri":      s.URL + "/token",
+	})
+	return b
+}
+
+// Fail causes the next call to the given API method to fail with the given
+// HTTP status code and Google API error reason (such as "backendError").
+// Methods are named by their Google API method IDs, such as
+// "storage.buckets.insert" or "compute.instances.insert"; requests for
+// OAuth2 tokens are named "token". Successive calls to Fail for the same
+// method queue failures for successive calls to that method.
+func (s *Server) Fail(method string, code int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], &apiError{
+		code:    code,
+		reason:  reason,
+		message: fmt.Sprintf("injected failure of %s", method),
+	})
+}
+
+// Calls reports the number of times the given API method has been called,
+// including calls that failed.
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// Enabled reports whether the named API has been enabled.
+func (s *Server) Enabled(api string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enabled[api]
+}
+
+// Bucket returns the named Storage bucket, or nil if it does not exist.
+func (s *Server) Bucket(name string) *storage.Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[name]
+}
+
+// Address returns the named static IP address in the given region,
+// or nil if it does not exist.
+func (s *Server) Address(region, name string) *compute.Address {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addresses[region+"/"+name]
+}
+
+// Instance returns the named Compute instance in the given zone,
+// or nil if it does not exist.
+func (s *Server) Instance(zone, name string) *compute.Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.instances[zone+"/"+name]
+}
+
+// Firewall returns the named firewall rule, or nil if it does not exist.
+func (s *Server) Firewall(name string) *compute.Firewall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.firewalls[name]
+}
+
+// ServiceAccountKeys returns the number of keys created for the service
+// account with the given email address.
+func (s *Server) ServiceAccountKeys(email string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[email]
+}
+
+// apiError is an error in the form returned by Google APIs.
+type apiError struct {
+	code    int
+	reason  string
+	message string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+func notFound(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusNotFound, "notFound", fmt.Sprintf(format, args...)}
+}
+
+func alreadyExists(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusConflict, "alreadyExists", fmt.Sprintf(format, args...)}
+}
+
+func invalid(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, "invalid", fmt.Sprintf(format, args...)}
+}
+
+// route describes an API method. In its pattern, each "*" path element
+// matches any single element, which is passed to the handler in args.
+type route struct {
+	httpMethod string
+	pattern    string
+	method     string // Google API method ID.
+	handler    func(s *Server, r *http.Request, args []string) (interface{}, *apiError)
+}
+
+var routes = []route{
+	{"POST", "/token", "token", (*Server).token},
+
+	{"POST", "/servicemanagement/v1/services/*", "servicemanagement.services.enable", (*Server).enableService},
+	{"GET", "/servicemanagement/v1/operations/*", "servicemanagement.operations.get", (*Server).serviceOperation},
+
+	{"GET", "/compute/v1/projects/*/regions", "compute.regions.list", (*Server).listRegions},
+	{"POST", "/compute/v1/projects/*/regions/*/addresses", "compute.addresses.insert", (*Server).insertAddress},
+	{"GET", "/compute/v1/projects/*/regions/*/addresses/*", "compute.addresses.get", (*Server).getAddress},
+	{"GET", "/compute/v1/projects/*/regions/*/operations/*", "compute.regionOperations.get", (*Server).computeOperation},
+	{"POST", "/compute/v1/projects/*/global/firewalls", "compute.firewalls.insert", (*Server).insertFirewall},
+	{"GET", "/compute/v1/projects/*/global/operations/*", "compute.globalOperations.get", (*Server).computeOperation},
+	{"POST", "/compute/v1/projects/*/zones/*/instances", "compute.instances.insert", (*Server).insertInstance},
+	{"GET", "/compute/v1/projects/*/zones/*/operations/*", "compute.zoneOperations.get", (*Server).computeOperation},
+
+	{"POST", "/iam/v1/projects/*/serviceAccounts", "iam.projects.serviceAccounts.create", (*Server).createServiceAccount},
+	{"GET", "/iam/v1/projects/*/serviceAccounts/*", "iam.projects.serviceAccounts.get", (*Server).getServiceAccount},
+	{"POST", "/iam/v1/projects/*/serviceAccounts/*/keys", "iam.projects.serviceAccounts.keys.create", (*Server).createServiceAccountKey},
+
+	{"POST", "/storage/v1/b", "storage.buckets.insert", (*Server).insertBucket},
+	{"GET", "/storage/v1/b/*", "storage.buckets.get", (*Server).getBucket},
+	{"PUT", "/storage/v1/b/*", "storage.buckets.update", (*Server).updateBucket},
+}
+
+// match reports whether the path matches the pattern, and returns the path
+// elements that matched "*" elements of the pattern.
+func match(pattern, path string) ([]string, bool) {
+	pe := strings.Split(strings.Trim(pattern, "/"), "/")
+	e := strings.Split(strings.Trim(path, "/"), "/")
+	if len(pe) != len(e) {
+		return nil, false
+	}
+	var args []string
+	for i := range pe {
+		switch {
+		case pe[i] == "*":
+			args = append(args, e[i])
+		case pe[i] != e[i]:
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, rt := range routes {
+		if r.Method != rt.httpMethod {
+			continue
+		}
+		args, ok := match(rt.pattern, r.URL.Path)
+		if !ok {
+			continue
+		}
+		resp, err := s.call(rt, r, args)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	writeError(w, notFound("no such method: %s %s", r.Method, r.URL.Path))
+}
+
+// call checks the request's credentials and any injected failures,
+// and then invokes the route's handler.
+func (s *Server) call(rt route, r *http.Request, args []string) (interface{}, *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[rt.method]++
+	if f := s.failures[rt.method]; len(f) > 0 {
+		s.failures[rt.method] = f[1:]
+		return nil, f[0]
+	}
+	if rt.method != "token" {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			return nil, &apiError{http.StatusUnauthorized, "authError", "invalid credentials"}
+		}
+		if strings.Contains(rt.pattern, "/projects/*") && args[0] != s.ProjectID {
+			return nil, notFound("project %q not found", args[0])
+		}
+	}
+	return rt.handler(s, r, args)
+}
+
+func writeError(w http.ResponseWriter, e *apiError) {
+	var resp struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Errors  []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	resp.Error.Code = e.code
+	resp.Error.Message = e.message
+	resp.Error.Errors = append(resp.Error.Errors, struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}{e.reason, e.message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// decode decodes the JSON request body into v.
+func decode(r *http.Request, v interface{}) *apiError {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return invalid("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// opName returns a new, unique operation name.
+func (s *Server) opName() string {
+	s.nextOp++
+	return fmt.Sprintf("operation-%d", s.nextOp)
+}
+
+// OAuth2.
+
+func (s *Server) token(r *http.Request, _ []string) (interface{}, *apiError) {
+	if r.FormValue("assertion") == "" {
+		return nil, invalid("missing assertion")
+	}
+	return map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	}, nil
+}
+
+// Service Management.
+
+func (s *Server) enableService(r *http.Request, args []string) (interface{}, *apiError) {
+	const suffix = ":enable"
+	if !strings.HasSuffix(args[0], suffix) {
+		return nil, notFound("no such method: %s", args[0])
+	}
+	var req servicemanagement.EnableServiceRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	if req.ConsumerId != "project:"+s.ProjectID {
+		return nil, notFound("consumer %q not found", req.ConsumerId)
+	}
+	s.enabled[strings.TrimSuffix(args[0], suffix)] = true
+	return &servicemanagement.Operation{
+		Name: "operations/" + s.opName(),
+		Done: false,
+	}, nil
+}
+
+func (s *Server) serviceOperation(r *http.Request, args []string) (interface{}, *apiError) {
+	return &servicemanagement.Operation{
+		Name: "operations/" + args[0],
+		Done: true,
+	}, nil
+}
+
+// Compute.
+
+// computeOp returns a running Compute operation in the given scope,
+// which is one of "zones/<zone>", "regions/<region>", or "global".
+func (s *Server) computeOp(scope string) *compute.Operation {
+	op := &compute.Operation{
+		Name:   s.opName(),
+		Status: "RUNNING",
+	}
+	base := s.URL + "/compute/v1/projects/" + s.ProjectID + "/"
+	switch {
+	case strings.HasPrefix(scope, "zones/"):
+		op.Zone = base + scope
+	case strings.HasPrefix(scope, "regions/"):
+		op.Region = base + scope
+	}
+	return op
+}
+
+func (s *Server) computeOperation(r *http.Request, args []string) (interface{}, *apiError) {
+	return &compute.Operation{
+		Name:   args[len(args)-1],
+		Status: "DONE",
+	}, nil
+}
+
+func (s *Server) listRegions(r *http.Request, _ []string) (interface{}, *apiError) {
+	list := &compute.RegionList{}
+	base := s.URL + "/compute/v1/projects/" + s.ProjectID + "/zones/"
+	for _, r := range regions {
+		region := &compute.Region{Name: r.name, Status: r.status}
+		for _, z := range r.zones {
+			region.Zones = append(region.Zones, base+z)
+		}
+		list.Items = append(list.Items, region)
+	}
+	return list, nil
+}
+
+func (s *Server) insertAddress(r *http.Request, args []string) (interface{}, *apiError) {
+	region := args[1]
+	if !validZone(region, "") {
+		return nil, notFound("region %q not found", region)
+	}
+	var addr compute.Address
+	if err := decode(r, &addr); err != nil {
+		return nil, err
+	}
+	key := region + "/" + addr.Name
+	if s.addresses[key] != nil {
+		return nil, alreadyExists("address %q already exists", addr.Name)
+	}
+	// Allocate addresses from TEST-NET-3 (RFC 5737).
+	addr.Address = fmt.Sprintf("203.0.113.%d", len(s.addresses)+1)
+	addr.Region = region
+	s.addresses[key] = &addr
+	return s.computeOp("regions/" + region), nil
+}
+
+func (s *Server) getAddress(r *http.Request, args []string) (interface{}, *apiError) {
+	addr := s.addresses[args[1]+"/"+args[2]]
+	if addr == nil {
+		return nil, notFound("address %q not found", args[2])
+	}
+	return addr, nil
+}
+
+func (s *Server) insertFirewall(r *http.Request, args []string) (interface{}, *apiError) {
+	var fw compute.Firewall
+	if err := decode(r, &fw); err != nil {
+		return nil, err
+	}
+	if s.firewalls[fw.Name] != nil {
+		return nil, alreadyExists("firewall %q already exists", fw.Name)
+	}
+	s.firewalls[fw.Name] = &fw
+	return s.computeOp("global"), nil
+}
+
+func (s *Server) insertInstance(r *http.Request, args []string) (interface{}, *apiError) {
+	zone := args[1]
+	if !validZone("", zone) {
+		return nil, notFound("zone %q not found", zone)
+	}
+	var inst compute.Instance
+	if err := decode(r, &inst); err != nil {
+		return nil, err
+	}
+	key := zone + "/" + inst.Name
+	if s.instances[key] != nil {
+		return nil, alreadyExists("instance %q already exists", inst.Name)
+	}
+	inst.Status = "RUNNING"
+	s.instances[key] = &inst
+	return s.computeOp("zones/" + zone), nil
+}
+
+// IAM.
+
+func (s *Server) createServiceAccount(r *http.Request, args []string) (interface{}, *apiError) {
+	var req iam.CreateServiceAccountRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	if req.AccountId == "" {
+		return nil, invalid("missing account ID")
+	}
+	email := req.AccountId + "@" + s.ProjectID + ".iam.gserviceaccount.com"
+	if s.accounts[email] != nil {
+		return nil, alreadyExists("service account %q already exists", email)
+	}
+	acct := &iam.ServiceAccount{
+		Name:      "projects/" + s.ProjectID + "/serviceAccounts/" + email,
+		ProjectId: s.ProjectID,
+		Email:     email,
+	}
+	if req.ServiceAccount != nil {
+		acct.DisplayName = req.ServiceAccount.DisplayName
+	}
+	s.accounts[email] = acct
+	return acct, nil
+}
+
+func (s *Server) getServiceAccount(r *http.Request, args []string) (interface{}, *apiError) {
+	acct := s.accounts[args[1]]
+	if acct == nil {
+		return nil, notFound("service account %q not found", args[1])
+	}
+	return acct, nil
+}
+
+func (s *Server) createServiceAccountKey(r *http.Request, args []string) (interface{}, *apiError) {
+	email := args[1]
+	if s.accounts[email] == nil {
+		return nil, notFound("service account %q not found", email)
+	}
+	s.keys[email]++
+	id := fmt.Sprintf("key-%d", s.keys[email])
+	data := fmt.Sprintf(`{"type":"service_account","client_email":%q,"private_key_id":%q}`, email, id)
+	return &iam.ServiceAccountKey{
+		Name:           "projects/" + s.ProjectID + "/serviceAccounts/" + email + "/keys/" + id,
+		PrivateKeyData: base64.StdEncoding.EncodeToString([]byte(data)),
+	}, nil
+}
+
+// Storage.
+
+func (s *Server) insertBucket(r *http.Request, _ []string) (interface{}, *apiError) {
+	if p := r.FormValue("project"); p != s.ProjectID {
+		return nil, notFound("project %q not found", p)
+	}
+	var b storage.Bucket
+	if err := decode(r, &b); err != nil {
+		return nil, err
+	}
+	if b.Name == "" {
+		return nil, invalid("missing bucket name")
+	}
+	if s.buckets[b.Name] != nil {
+		return nil, &apiError{http.StatusConflict, "conflict", fmt.Sprintf("bucket %q already exists", b.Name)}
+	}
+	s.buckets[b.Name] = &b
+	return &b, nil
+}
+
+func (s *Server) getBucket(r *http.Request, args []string) (interface{}, *apiError) {
+	b := s.buckets[args[0]]
+	if b == nil {
+		return nil, notFound("bucket %q not found", args[0])
+	}
+	return b, nil
+}
+
+func (s *Server) updateBucket(r *http.Request, args []string) (interface{}, *apiError) {
+	if s.buckets[args[0]] == nil {
+		return nil, notFound("bucket %q not found", args[0])
+	}
+	var b storage.Bucket
+	if err := decode(r, &b); err != nil {
+		return nil, err
+	}
+	b.Name = args[0]
+	s.buckets[b.Name] = &b
+	return &b, nil
+}
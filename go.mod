@@ -8,5 +8,6 @@ require (
 	golang.org/x/oauth2 v0.0.0-20170928010508-bb50c06baba3
 	google.golang.org/api v0.0.0-20171025000339-52fedcc3d56e
 	google.golang.org/appengine v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 	upspin.io v0.0.0-20191119235922-d3902620b3d3
 )
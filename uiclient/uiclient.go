@@ -0,0 +1,653 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package uiclient provides a client for the HTTP API served by upspin-ui.
+// It may be used to drive a running upspin-ui from Go programs,
+// such as integration tests and automation scripts.
+package uiclient // import "augie.upspin.io/uiclient"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"upspin.io/upspin"
+)
+
+// KeyHeader is the HTTP header that carries the request key.
+const KeyHeader = "X-Upspin-Key"
+
+// AsHeader is the HTTP header that names the server user as whom a request
+// is performed.
+const AsHeader = "X-Upspin-As"
+
+// Client makes requests to a running upspin-ui server.
+type Client struct {
+	base string          // Base URL of the server, without a trailing slash.
+	key  string          // Request key.
+	as   upspin.UserName // Server user as whom to make requests, if any.
+
+	// HTTPClient is the HTTP client used to make requests.
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the upspin-ui server at the given base URL
+// (for example, "http://localhost:8000") that uses the given request key.
+func New(baseURL, key string) *Client {
+	return &Client{
+		base: strings.TrimSuffix(baseURL, "/"),
+		key:  key,
+	}
+}
+
+// As returns a copy of c that performs file operations (List, MakeDirectory,
+// Delete, Copy, Put, and Get) as the given server user of the current user,
+// as listed in StartupResult.ServerUsers. Other requests made with the
+// returned Client fail.
+func (c *Client) As(user upspin.UserName) *Client {
+	cc := *c
+	cc.as = user
+	return &cc
+}
+
+// NewFromURL returns a Client for the URL printed by upspin-ui at startup,
+// which has the form http://localhost:8000/#key=<key>.
+func NewFromURL(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	const prefix = "key="
+	if !strings.HasPrefix(u.Fragment, prefix) {
+		return nil, fmt.Errorf("uiclient: no key in URL %q", rawURL)
+	}
+	key := strings.TrimPrefix(u.Fragment, prefix)
+	u.Fragment = ""
+	u.Path = ""
+	return New(u.String(), key), nil
+}
+
+// Error is an error returned by the upspin-ui server.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Kind is the kind of error, such as "NotExist" or "Permission".
+	// It is the name of the corresponding upspin.io/errors Kind,
+	// or "NoConfig" if the server has not completed startup.
+	Kind string
+
+	Path    upspin.PathName
+	User    upspin.UserName
+	Op      string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Entry is a directory entry returned by List. Its FileToken authorizes
+// requests for the file's contents.
+type Entry struct {
+	*upspin.DirEntry
+	FileToken string
+}
+
+// StartupRequest is a request to perform an action of the startup process.
+// The zero value asks for the current step of the process.
+type StartupRequest struct {
+	Action string `json:",omitempty"`
+
+	// Action: "signup" and "recover"
+	UserName upspin.UserName `json:",omitempty"`
+	// The key server address and TLS certificate directory default to
+	// the server's -keyserver and -tlscerts flags.
+	KeyServer string `json:",omitempty"`
+	TLSCerts  string `json:",omitempty"`
+
+	// Action: "recover"
+	SecretSeed string `json:",omitempty"`
+
+	// Action: "confirmSeed"
+	// The words of the secret seed asked for by the "confirmSeed" or
+	// "confirmServerSeed" step, in order.
+	SeedWords []string `json:",omitempty"`
+
+	// Action: "specifyEndpoints"
+	DirServer   string `json:",omitempty"`
+	StoreServer string `json:",omitempty"`
+
+	// Action: "specifyGCP"
+	PrivateKeyData string `json:",omitempty"`
+
+	// Action: "createGCP"
+	BucketName string `json:",omitempty"`
+	BucketLoc  string `json:",omitempty"`
+	RegionZone string `json:",omitempty"`
+
+	// Action: "configureServerUserName"
+	UserNameSuffix string `json:",omitempty"`
+
+	// Action: "configureServerHostName"
+	HostName string `json:",omitempty"`
+
+	// Action: "checkServerHostName"
+	Reset bool `json:",omitempty"`
+
+	// Action: "configureServer"
+	Writers string `json:",omitempty"`
+}
+
+// StartupStep describes the step of the startup process that the user
+// should be presented with.
+type StartupStep struct {
+	Step string
+
+	KeyDir     string
+	SecretSeed string
+
+	UserName upspin.UserName
+
+	// SeedWords holds the positions, counting from 1, of the words of
+	// the secret seed that the "confirmSeed" and "confirmServerSeed"
+	// steps ask for.
+	SeedWords []int
+
+	BucketName string
+	Zones      []string
+	Locations  []string
+
+	UserNamePrefix string
+	UserNameSuffix string
+	UserNameDomain string
+
+	IPAddr   string
+	HostName string
+
+	Writers []upspin.UserName
+}
+
+// StartupResult is the result of a startup request.
+// If Startup is non-nil the startup process is not complete.
+// Otherwise the remaining fields describe the configured user.
+type StartupResult struct {
+	Startup   *StartupStep
+	UserName  upspin.UserName
+	LeftPath  upspin.PathName
+	RightPath upspin.PathName
+	Version   string
+
+	// ServerUsers lists the users that may be passed to As.
+	ServerUsers []upspin.UserName
+
+	// ReadOnly is set if the config names no directory or store server,
+	// in which case requests that modify the tree fail with Kind
+	// "ReadOnly". Use SetEndpoints to name them.
+	ReadOnly bool
+}
+
+// Startup performs the given startup action.
+func (c *Client) Startup(req *StartupRequest) (*StartupResult, error) {
+	if req == nil {
+		req = &StartupRequest{}
+	}
+	var resp StartupResult
+	if err := c.do("POST", "startup", "", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// VerifyStatus describes a user who has been sent a verification email.
+type VerifyStatus struct {
+	UserName upspin.UserName
+
+	// Registered reports whether the user has clicked the verification
+	// link. Once they have, Startup moves on from the "verify" step.
+	Registered bool
+
+	// ResendIn is the number of seconds until the "register" startup
+	// action may send another verification email.
+	ResendIn int
+}
+
+// WaitVerified waits for up to 30 seconds for the user in the "verify"
+// step of the startup process to click the link in the verification email.
+func (c *Client) WaitVerified() (*VerifyStatus, error) {
+	var resp VerifyStatus
+	if err := c.do("GET", "startup/verify", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// List returns the entries of the given directory. If only some of the
+// entries could be listed, it returns those entries and an *Error that
+// describes the failure.
+func (c *Client) List(dir upspin.PathName) ([]*Entry, error) {
+	var resp struct {
+		Entries []*Entry
+		Error   string
+	}
+	if err := c.do("GET", "dir/", dir, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return resp.Entries, &Error{StatusCode: http.StatusOK, Kind: "Other", Message: resp.Error}
+	}
+	return resp.Entries, nil
+}
+
+// MakeDirectory creates the named directory.
+func (c *Client) MakeDirectory(name upspin.PathName) (*upspin.DirEntry, error) {
+	var resp struct {
+		Entry *upspin.DirEntry
+	}
+	if err := c.do("PUT", "dir/", name, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Entry, nil
+}
+
+// Delete recursively deletes the given paths.
+func (c *Client) Delete(paths ...upspin.PathName) error {
+	req := struct {
+		Paths []upspin.PathName
+	}{paths}
+	return c.do("POST", "delete", "", req, nil)
+}
+
+// Copy recursively copies the given paths to the destination directory.
+func (c *Client) Copy(dest upspin.PathName, paths ...upspin.PathName) error {
+	req := struct {
+		Paths []upspin.PathName
+		Dest  upspin.PathName
+	}{paths, dest}
+	return c.do("POST", "copy", "", req, nil)
+}
+
+// SetEndpoints gives the current user the named directory and store servers,
+// updating their key server record and config. The directory server must
+// hold the user's root, unless the user is read-only, in which case the root
+// is created.
+func (c *Client) SetEndpoints(dirServer, storeServer string) (*ConfigFile, error) {
+	req := struct{ DirServer, StoreServer string }{dirServer, storeServer}
+	var resp ConfigFile
+	if err := c.do("POST", "endpoints", "", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Profile describes a config file that the server may switch to.
+// If Error is set the config could not be loaded.
+type Profile struct {
+	Name     string
+	File     string
+	UserName upspin.UserName
+	Active   bool
+	Error    string
+}
+
+// Profiles returns the profiles available to the server.
+func (c *Client) Profiles() ([]*Profile, error) {
+	var resp struct {
+		Profiles []*Profile
+	}
+	if err := c.do("GET", "profiles", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Profiles, nil
+}
+
+// SwitchProfile makes the named profile the active one. File tokens in
+// entries returned by List under other profiles are no longer valid.
+func (c *Client) SwitchProfile(name string) error {
+	req := struct{ Name string }{name}
+	return c.do("POST", "profiles", "", req, nil)
+}
+
+// ConfigFields holds the settings of a config file that may be edited.
+// An empty field is absent from the file.
+type ConfigFields struct {
+	UserName    upspin.UserName
+	KeyServer   string
+	DirServer   string
+	StoreServer string
+	Packing     string
+	Cache       string
+	TLSCerts    string
+	Secrets     string
+}
+
+// ConfigFile describes the config file of the active profile.
+type ConfigFile struct {
+	File   string
+	Fields ConfigFields
+
+	// Other lists the other keys in the file, which are kept as they are.
+	Other []string
+
+	// Checksum identifies the contents of the file.
+	Checksum string
+}
+
+// Config returns the settings in the active profile's config file.
+func (c *Client) Config() (*ConfigFile, error) {
+	var resp ConfigFile
+	if err := c.do("GET", "config", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateConfig validates and saves the given settings in the active
+// profile's config file, which must still have the given checksum.
+// The previous file is kept as a backup.
+func (c *Client) UpdateConfig(fields ConfigFields, checksum string) (*ConfigFile, error) {
+	req := struct {
+		Fields   ConfigFields
+		Checksum string
+	}{fields, checksum}
+	var resp ConfigFile
+	if err := c.do("PUT", "config", "", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CacheStatus describes the cacheserver of the active profile.
+type CacheStatus struct {
+	Enabled   bool
+	Endpoint  string
+	Running   bool
+	Managed   bool
+	Dir       string
+	Size      int64
+	Files     int
+	SizeLimit int64
+	Error     string
+}
+
+// CacheStatus reports the status and size of the active profile's cache.
+func (c *Client) CacheStatus() (*CacheStatus, error) {
+	return c.cache("GET", "cache", nil)
+}
+
+// SetCache turns the cache on or off in the active profile's config file.
+func (c *Client) SetCache(enabled bool) (*CacheStatus, error) {
+	return c.cache("PUT", "cache", struct{ Enabled bool }{enabled})
+}
+
+// RestartCache restarts the cacheserver started by upspin-ui.
+func (c *Client) RestartCache() (*CacheStatus, error) {
+	return c.cache("POST", "cache/restart", nil)
+}
+
+// FlushCache removes the contents of the cache, restarting the cacheserver
+// started by upspin-ui.
+func (c *Client) FlushCache() (*CacheStatus, error) {
+	return c.cache("POST", "cache/flush", nil)
+}
+
+func (c *Client) cache(method, route string, req interface{}) (*CacheStatus, error) {
+	var resp CacheStatus
+	if err := c.do(method, route, "", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RotateStatus describes the progress of a key rotation.
+type RotateStatus struct {
+	KeyDir     string
+	SecretSeed string
+	Rewrap     bool
+	Done       bool
+	Files      int
+	Rewrapped  int
+	Failed     []upspin.PathName
+	Error      string
+}
+
+// RotateKeys replaces the current user's keys. If rewrap is set, the server
+// then re-wraps the user's encrypted files for the new key in the
+// background; use RotationStatus to follow its progress.
+func (c *Client) RotateKeys(rewrap bool) (*RotateStatus, error) {
+	req := struct{ Rewrap bool }{rewrap}
+	var resp RotateStatus
+	if err := c.do("POST", "keys/rotate", "", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RotationStatus reports the progress of the most recent key rotation.
+func (c *Client) RotationStatus() (*RotateStatus, error) {
+	var resp RotateStatus
+	if err := c.do("GET", "keys/rotate", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Text returns the contents of the named text file and its sequence number,
+// which should be passed to PutText when saving an edited version.
+func (c *Client) Text(name upspin.PathName) (contents string, seq int64, err error) {
+	var resp struct {
+		Contents string
+		Sequence int64
+	}
+	if err := c.do("GET", "text/", name, nil, &resp); err != nil {
+		return "", 0, err
+	}
+	return resp.Contents, resp.Sequence, nil
+}
+
+// PutText writes contents to the named file if its sequence number is seq,
+// or if it does not exist and seq is upspin.SeqNotExist. It returns the
+// sequence number of the newly written file.
+func (c *Client) PutText(name upspin.PathName, seq int64, contents string) (int64, error) {
+	req := struct {
+		Contents string
+		Sequence int64
+	}{contents, seq}
+	var resp struct {
+		Sequence int64
+	}
+	if err := c.do("PUT", "text/", name, req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Sequence, nil
+}
+
+// Group describes a Group file.
+type Group struct {
+	// Members holds the members as written in the file.
+	Members []string
+
+	// Users holds the users that the group expands to once
+	// nested groups have been resolved.
+	Users []upspin.UserName
+
+	// Unreadable lists the nested groups that could not be read,
+	// whose users are therefore missing from Users.
+	Unreadable []UnreadableGroup
+}
+
+// UnreadableGroup describes a nested group that could not be read.
+type UnreadableGroup struct {
+	Group upspin.PathName
+	Error string
+}
+
+// Groups returns the names of the current user's Group files.
+func (c *Client) Groups() ([]upspin.PathName, error) {
+	var resp struct {
+		Groups []upspin.PathName
+	}
+	if err := c.do("GET", "groups", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Groups, nil
+}
+
+// Group returns the members of the named Group file.
+func (c *Client) Group(name upspin.PathName) (*Group, error) {
+	var resp Group
+	if err := c.do("GET", "groups/", name, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateGroup adds members to and then removes members from the named Group
+// file, creating it if necessary, and returns its new members.
+func (c *Client) UpdateGroup(name upspin.PathName, add, remove []string) (*Group, error) {
+	req := struct {
+		Add    []string `json:",omitempty"`
+		Remove []string `json:",omitempty"`
+	}{add, remove}
+	var resp Group
+	if err := c.do("POST", "groups/", name, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Put uploads the contents of r as a file with the given name in dir.
+func (c *Client) Put(dir upspin.PathName, name string, r io.Reader) error {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file0", name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, r); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.apiURL("upload/", dir), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return c.send(req, nil)
+}
+
+// Get returns the contents of the file described by the given entry,
+// as returned by List.
+func (c *Client) Get(e *Entry) ([]byte, error) {
+	u := c.base + "/" + escapePath(e.Name) + "?token=" + url.QueryEscape(e.FileToken)
+	if c.as != "" {
+		u += "&as=" + url.QueryEscape(string(c.as))
+	}
+	resp, err := c.httpClient().Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{
+			StatusCode: resp.StatusCode,
+			Kind:       "Other",
+			Path:       e.Name,
+			Message:    strings.TrimSpace(string(b)),
+		}
+	}
+	return b, nil
+}
+
+// do makes an API request with the given method, route, and path name,
+// sending req (if non-nil) as a JSON body and decoding the JSON response
+// into resp (if non-nil).
+func (c *Client) do(method, route string, name upspin.PathName, req, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	r, err := http.NewRequest(method, c.apiURL(route, name), body)
+	if err != nil {
+		return err
+	}
+	if req != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(r, resp)
+}
+
+// send sends the given request, with the request key, and decodes the
+// JSON response into resp (if non-nil) or returns the server's error.
+func (c *Client) send(r *http.Request, resp interface{}) error {
+	r.Header.Set(KeyHeader, c.key)
+	if c.as != "" {
+		r.Header.Set(AsHeader, string(c.as))
+	}
+	res, err := c.httpClient().Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error *Error
+		}
+		if err := json.Unmarshal(b, &e); err != nil || e.Error == nil {
+			return &Error{
+				StatusCode: res.StatusCode,
+				Kind:       "Other",
+				Message:    fmt.Sprintf("%s: %s", res.Status, bytes.TrimSpace(b)),
+			}
+		}
+		e.Error.StatusCode = res.StatusCode
+		return e.Error
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(b, resp)
+}
+
+// apiURL returns the URL of the given API route and path name.
+func (c *Client) apiURL(route string, name upspin.PathName) string {
+	return c.base + "/api/v1/" + route + escapePath(name)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// escapePath escapes each element of the given path name for use in a URL.
+func escapePath(name upspin.PathName) string {
+	elems := strings.Split(string(name), "/")
+	for i, e := range elems {
+		elems[i] = url.PathEscape(e)
+	}
+	return strings.Join(elems, "/")
+}